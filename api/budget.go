@@ -0,0 +1,63 @@
+package api
+
+import (
+	"github.com/trae2api/pkg/tokenizer"
+)
+
+// 计算增量 token 数时参考的已输出文本长度（字符数）
+const budgetTailRunes = 16
+
+// tokenBudget 按 token 数限制输出长度，推理内容同样计入
+type tokenBudget struct {
+	tk        tokenizer.Tokenizer
+	remaining int
+	// limit 为 0 表示不限制
+	limit int
+	// tail 最近输出的文本，使跨越增量边界的单词不被重复计数
+	tail string
+}
+
+func newTokenBudget(model string, limit int) *tokenBudget {
+	return &tokenBudget{tk: tokenizer.ForModel(model), remaining: limit, limit: limit}
+}
+
+// take 返回预算内可以输出的前缀，exceeded 为 true 表示文本超出了剩余预算
+func (b *tokenBudget) take(text string) (string, bool) {
+	if b.limit <= 0 || text == "" {
+		return text, false
+	}
+
+	if n := b.cost(text); n <= b.remaining {
+		b.accept(text, n)
+		return text, false
+	}
+
+	// 二分查找不超过剩余预算的最长前缀
+	runes := []rune(text)
+	lo, hi := 0, len(runes)
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if b.cost(string(runes[:mid])) <= b.remaining {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	b.remaining = 0
+	return string(runes[:lo]), true
+}
+
+// cost 返回在已输出文本之后追加 text 新增的 token 数
+func (b *tokenBudget) cost(text string) int {
+	return b.tk.Count(b.tail+text) - b.tk.Count(b.tail)
+}
+
+// accept 扣除预算并更新最近输出的文本
+func (b *tokenBudget) accept(text string, n int) {
+	b.remaining -= n
+	tail := []rune(b.tail + text)
+	if len(tail) > budgetTailRunes {
+		tail = tail[len(tail)-budgetTailRunes:]
+	}
+	b.tail = string(tail)
+}
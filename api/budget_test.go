@@ -0,0 +1,79 @@
+package api_test
+
+import (
+	"testing"
+
+	"github.com/trae2api/pkg/traefake"
+)
+
+// streamUsage 返回流式响应最后一个数据块中的 usage
+func streamUsage(t *testing.T, body string) map[string]interface{} {
+	t.Helper()
+	chunks := chatChunks(t, body)
+	if len(chunks) == 0 {
+		t.Fatalf("响应中没有数据块: %s", body)
+	}
+	usage, _ := chunks[len(chunks)-1]["usage"].(map[string]interface{})
+	return usage
+}
+
+func TestCompletionTokenLimit(t *testing.T) {
+	const answer = "The quick brown fox jumps over the lazy dog and keeps running far away from here."
+
+	tests := []struct {
+		name  string
+		model string
+		// 推理内容
+		reasoning string
+		limit     map[string]interface{}
+		want      int
+		// 推理内容占满上限时正文为空
+		wantEmptyContent bool
+	}{
+		{name: "max_tokens", model: "gpt-4o", limit: map[string]interface{}{"max_tokens": 5}, want: 5},
+		{name: "max_completion_tokens", model: "gpt-4o", limit: map[string]interface{}{"max_completion_tokens": 7}, want: 7},
+		{name: "max_completion_tokens 优先", model: "gpt-4o", limit: map[string]interface{}{"max_tokens": 3, "max_completion_tokens": 6}, want: 6},
+		// 推理内容与正文共用同一个上限
+		{name: "推理内容计入上限", model: "deepseek-r1", reasoning: "Let me think about it.", limit: map[string]interface{}{"max_tokens": 8}, want: 8},
+		{name: "推理内容占满上限", model: "deepseek-r1", reasoning: "Let me think about it carefully before answering.", limit: map[string]interface{}{"max_tokens": 4}, want: 4, wantEmptyContent: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, traefake.Options{})
+			env.fake.SetDefault(traefake.Reply(tt.reasoning, answer, "stop"))
+
+			extra := map[string]interface{}{"reasoning_format": "reasoning_content"}
+			for k, v := range tt.limit {
+				extra[k] = v
+			}
+			w := env.chat(t, tt.model, "hi", extra)
+			resp := decodeJSON(t, w)
+			choice := resp["choices"].([]interface{})[0].(map[string]interface{})
+			if choice["finish_reason"] != "length" {
+				t.Errorf("finish_reason = %v, want length", choice["finish_reason"])
+			}
+			usage := resp["usage"].(map[string]interface{})
+			if usage["completion_tokens"] != float64(tt.want) {
+				t.Errorf("completion_tokens = %v, want %d", usage["completion_tokens"], tt.want)
+			}
+			content := choice["message"].(map[string]interface{})["content"].(string)
+			if (content == "") != tt.wantEmptyContent || len(content) >= len(answer) {
+				t.Errorf("content = %q", content)
+			}
+
+			extra["stream"] = true
+			extra["stream_options"] = map[string]interface{}{"include_usage": true}
+			w = env.chat(t, tt.model, "hi", extra)
+			streamed, _, finishReason := streamToolCalls(t, w.Body.String())
+			if finishReason != "length" {
+				t.Errorf("流式 finish_reason = %v, want length", finishReason)
+			}
+			if streamed != content {
+				t.Errorf("流式 content = %q, 非流式 %q", streamed, content)
+			}
+			if usage := streamUsage(t, w.Body.String()); usage["completion_tokens"] != float64(tt.want) {
+				t.Errorf("流式 completion_tokens = %v, want %d", usage["completion_tokens"], tt.want)
+			}
+		})
+	}
+}
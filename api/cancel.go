@@ -0,0 +1,125 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/trae2api/config"
+	"github.com/trae2api/pkg/logger"
+	"github.com/trae2api/pkg/traesse"
+	"github.com/trae2api/pkg/upstream"
+)
+
+const (
+	cancelReasonClient   = "client_disconnected"
+	cancelReasonDeadline = "deadline_exceeded"
+)
+
+// cancelStats 被取消的对话请求统计，通过 /debug/vars 查看
+//
+// client_disconnected 与 deadline_exceeded 为按原因统计的请求数，
+// completion_tokens 与 reasoning_tokens 为取消前已经生成的 token 数。
+var cancelStats = expvar.NewMap("chat_cancellations")
+
+// withRequestDeadline 返回绑定客户端请求并带有处理时间上限的 context
+func withRequestDeadline(parent context.Context) (context.Context, context.CancelFunc) {
+	timeout := atoiOr(config.UpstreamRequestTimeout, 1800)
+	if timeout == 0 {
+		return context.WithCancel(parent)
+	}
+	return context.WithTimeout(parent, time.Duration(timeout)*time.Second)
+}
+
+// abortChat 记录被取消的对话请求，超过处理时间上限时以 504 结束响应，客户端已断开时不再写回
+func abortChat(ctx context.Context, sink completionSink, model string, rounds int, elapsed time.Duration, generated tokenUsage) {
+	reason := cancelReasonClient
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		reason = cancelReasonDeadline
+	}
+	cancelStats.Add(reason, 1)
+	cancelStats.Add("completion_tokens", int64(generated.CompletionTokens))
+	cancelStats.Add("reasoning_tokens", int64(generated.ReasoningTokens))
+
+	logger.Log.WithFields(logrus.Fields{
+		"event":            "chat_cancelled",
+		"reason":           reason,
+		"model":            model,
+		"rounds":           rounds,
+		"elapsed":          elapsed.Round(time.Millisecond).String(),
+		"completionTokens": generated.CompletionTokens,
+		"reasoningTokens":  generated.ReasoningTokens,
+	}).Warn("对话请求已取消，关闭上游连接")
+
+	if reason == cancelReasonDeadline {
+		sink.Fail(http.StatusGatewayTimeout, "timeout", fmt.Sprintf("请求处理超过 %v 仍未完成", elapsed.Round(time.Second)))
+	}
+}
+
+// streamItem 从上游事件流读取到的一项
+type streamItem struct {
+	ev  traesse.Event
+	msg *traesse.Message
+	err error
+}
+
+// streamReader 在单独的 goroutine 中读取上游事件流，使等待上游数据时也能立即响应取消
+//
+// 事件流只由读取 goroutine 关闭，关闭前通过取消请求的 context 中断阻塞的读取，
+// 避免在读取的同时关闭响应体。
+type streamReader struct {
+	stream upstream.ChatStream
+	cancel context.CancelFunc
+	items  chan streamItem
+	done   chan struct{}
+	once   sync.Once
+}
+
+// openStream 发起上游对话请求并开始读取事件流
+func openStream(ctx context.Context, traeReq *upstream.ChatRequest) (*streamReader, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	stream, err := traeUpstream.Chat(ctx, traeReq)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	r := &streamReader{
+		stream: stream,
+		cancel: cancel,
+		items:  make(chan streamItem),
+		done:   make(chan struct{}),
+	}
+	go r.run()
+	return r, nil
+}
+
+func (r *streamReader) run() {
+	defer close(r.items)
+	defer r.stream.Close()
+	for {
+		ev, msg, err := r.stream.Next()
+		select {
+		case r.items <- streamItem{ev: ev, msg: msg, err: err}:
+		case <-r.done:
+			return
+		}
+		// 读取结束或连接出错
+		if err != nil && msg == nil {
+			return
+		}
+	}
+}
+
+// Close 中断上游请求并结束读取 goroutine，可以重复调用
+func (r *streamReader) Close() {
+	r.once.Do(func() {
+		close(r.done)
+		r.cancel()
+	})
+}
@@ -0,0 +1,116 @@
+package api
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/trae2api/config"
+	"github.com/trae2api/pkg/logger"
+	"github.com/trae2api/pkg/upstream"
+)
+
+// 单次同步模型目录的超时时间
+const catalogSyncTimeout = 30 * time.Second
+
+// modelCatalog 后台同步的 Trae 模型目录缓存
+type modelCatalog struct {
+	mu sync.RWMutex
+	// Trae 模型名 -> 模型配置
+	models map[string]upstream.ModelConfig
+	// 最近一次同步成功的时间，零值表示尚未同步成功
+	syncedAt time.Time
+}
+
+var catalog = &modelCatalog{}
+
+// StartModelCatalogSync 立即同步一次 Trae 模型目录，之后按 MODEL_CATALOG_SYNC_INTERVAL 定期同步
+func StartModelCatalogSync() {
+	seconds, err := strconv.Atoi(config.ModelCatalogSyncInterval)
+	if err != nil || seconds <= 0 {
+		logger.Log.Info("模型目录同步已关闭，模型列表仅由模型注册表决定")
+		return
+	}
+
+	go func() {
+		catalog.sync()
+		ticker := time.NewTicker(time.Duration(seconds) * time.Second)
+		for range ticker.C {
+			catalog.sync()
+		}
+	}()
+}
+
+// sync 拉取 Trae 模型目录并与缓存比较，同步失败时保留已缓存的目录
+func (m *modelCatalog) sync() {
+	ctx, cancel := context.WithTimeout(context.Background(), catalogSyncTimeout)
+	defer cancel()
+
+	traeResp, err := traeUpstream.ListModels(ctx)
+	if err != nil {
+		logger.Log.Errorf("同步模型目录失败，继续使用缓存: %v", err)
+		return
+	}
+
+	models := make(map[string]upstream.ModelConfig, len(traeResp.ModelConfigs))
+	for _, model := range traeResp.ModelConfigs {
+		models[model.Name] = model
+	}
+
+	m.mu.Lock()
+	previous, initial := m.models, m.syncedAt.IsZero()
+	m.models = models
+	m.syncedAt = time.Now()
+	m.mu.Unlock()
+
+	if initial {
+		logger.Log.Infof("模型目录同步完成，共 %d 个模型", len(models))
+		return
+	}
+	logCatalogChanges(previous, models)
+}
+
+// logCatalogChanges 记录模型的新增、下线与多模态能力变化
+func logCatalogChanges(previous, current map[string]upstream.ModelConfig) {
+	emit := func(change string, model upstream.ModelConfig) {
+		logger.Log.WithFields(logrus.Fields{
+			"event":      "model_catalog_changed",
+			"change":     change,
+			"model":      model.Name,
+			"multimodal": model.Multimodal,
+		}).Warn("Trae 模型目录发生变化")
+	}
+
+	for name, model := range current {
+		old, ok := previous[name]
+		if !ok {
+			emit("added", model)
+		} else if old.Multimodal != model.Multimodal {
+			emit("multimodal_changed", model)
+		}
+	}
+	for name, model := range previous {
+		if _, ok := current[name]; !ok {
+			emit("removed", model)
+		}
+	}
+}
+
+// lookup 返回缓存中的 Trae 模型，synced 为 false 表示目录尚未同步成功
+func (m *modelCatalog) lookup(name string) (model upstream.ModelConfig, found bool, synced bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.syncedAt.IsZero() {
+		return upstream.ModelConfig{}, false, false
+	}
+	model, found = m.models[name]
+	return model, found, true
+}
+
+// available 判断 Trae 当前是否提供该模型，目录尚未同步时不做限制
+func (m *modelCatalog) available(name string) bool {
+	_, found, synced := m.lookup(name)
+	return found || !synced
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/trae2api/pkg/logger"
+	"github.com/trae2api/pkg/upstream"
+)
+
+// captureLogs 在测试期间记录 logger.Log 输出的日志
+func captureLogs(t *testing.T) *test.Hook {
+	t.Helper()
+	saved := logrus.LevelHooks{}
+	for level, hooks := range logger.Log.Hooks {
+		saved[level] = append([]logrus.Hook(nil), hooks...)
+	}
+	hook := test.NewLocal(logger.Log)
+	t.Cleanup(func() { logger.Log.ReplaceHooks(saved) })
+	return hook
+}
+
+// catalogChanges 返回日志中记录的模型目录变化，格式为 "change model"，按字母顺序排列
+func catalogChanges(hook *test.Hook) []string {
+	var changes []string
+	for _, entry := range hook.AllEntries() {
+		if entry.Data["event"] != "model_catalog_changed" {
+			continue
+		}
+		changes = append(changes, entry.Data["change"].(string)+" "+entry.Data["model"].(string))
+	}
+	sort.Strings(changes)
+	return changes
+}
+
+func TestLogCatalogChanges(t *testing.T) {
+	catalogOf := func(models ...upstream.ModelConfig) map[string]upstream.ModelConfig {
+		m := make(map[string]upstream.ModelConfig, len(models))
+		for _, model := range models {
+			m[model.Name] = model
+		}
+		return m
+	}
+	gpt := upstream.ModelConfig{Name: "gpt-4o", Multimodal: true}
+	r1 := upstream.ModelConfig{Name: "deepseek-R1"}
+
+	tests := []struct {
+		name     string
+		previous map[string]upstream.ModelConfig
+		current  map[string]upstream.ModelConfig
+		want     []string
+	}{
+		{name: "没有变化", previous: catalogOf(gpt, r1), current: catalogOf(gpt, r1)},
+		{name: "新增", previous: catalogOf(gpt), current: catalogOf(gpt, r1), want: []string{"added deepseek-R1"}},
+		{name: "下线", previous: catalogOf(gpt, r1), current: catalogOf(gpt), want: []string{"removed deepseek-R1"}},
+		// 改名表现为旧模型下线、新模型新增
+		{
+			name:     "改名",
+			previous: catalogOf(gpt, r1),
+			current:  catalogOf(gpt, upstream.ModelConfig{Name: "deepseek-r1-0528"}),
+			want:     []string{"added deepseek-r1-0528", "removed deepseek-R1"},
+		},
+		{
+			name:     "多模态能力变化",
+			previous: catalogOf(gpt, r1),
+			current:  catalogOf(upstream.ModelConfig{Name: "gpt-4o"}, r1),
+			want:     []string{"multimodal_changed gpt-4o"},
+		},
+		// 只有展示名变化时不记录
+		{
+			name:     "其他字段变化",
+			previous: catalogOf(gpt),
+			current:  catalogOf(upstream.ModelConfig{Name: "gpt-4o", DisplayName: "GPT-4o", Multimodal: true}),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hook := captureLogs(t)
+			logCatalogChanges(tt.previous, tt.current)
+			if got := catalogChanges(hook); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("变化 %v, want %v", got, tt.want)
+			}
+			for _, entry := range hook.AllEntries() {
+				if entry.Level != logrus.WarnLevel {
+					t.Errorf("日志级别 %v, want warning", entry.Level)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,461 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/trae2api/pkg/traesse"
+)
+
+// systemFingerprint 标识生成响应的后端配置，随模拟的 IDE 版本变化
+const systemFingerprint = "fp_trae_" + IdeVersionCde
+
+// newChatCompletionID 生成 chatcmpl- 开头的响应ID，同一响应的所有数据块共用
+func newChatCompletionID() string {
+	return "chatcmpl-" + strings.ReplaceAll(uuid.New().String(), "-", "")
+}
+
+// chatChoice 单个候选回复的输出状态
+type chatChoice struct {
+	format reasoningFormatter
+	// tools 不为空时从正文中解析工具调用
+	tools *toolCallParser
+
+	content strings.Builder
+	// reasoning 以 reasoning_content 字段返回的推理内容
+	reasoning    strings.Builder
+	finishReason string
+
+	// 流式模式下是否已写出角色数据块
+	started bool
+}
+
+// newChatChoices 创建 n 个候选的输出状态
+func newChatChoices(n int, reasoningFormat string, toolsEnabled bool) []*chatChoice {
+	choices := make([]*chatChoice, n)
+	for i := range choices {
+		choices[i] = &chatChoice{format: reasoningFormatter{format: reasoningFormat}}
+		if toolsEnabled {
+			choices[i].tools = newToolCallParser()
+		}
+	}
+	return choices
+}
+
+// split 将增量拆分为正文、推理内容以及新解析出的工具调用
+func (ch *chatChoice) split(d completionDelta) (string, string, []ToolCall) {
+	var calls []ToolCall
+	if ch.tools != nil {
+		d.Content, calls = ch.tools.push(d.Content)
+	}
+	content, reasoning := ch.format.apply(d)
+	// 工具调用之间的空白内容不再输出
+	if len(calls) > 0 || (ch.tools != nil && len(ch.tools.calls) > 0) {
+		if strings.TrimSpace(content) == "" {
+			content = ""
+		}
+	}
+	return content, reasoning, calls
+}
+
+// flush 返回工具调用解析器中暂存的正文，并关闭仍未关闭的 <think> 标签
+func (ch *chatChoice) flush() string {
+	var content string
+	if ch.tools != nil {
+		content, _ = ch.format.apply(completionDelta{Content: ch.tools.flush()})
+		if len(ch.tools.calls) > 0 && strings.TrimSpace(content) == "" {
+			content = ""
+		}
+	}
+	return content + ch.format.close()
+}
+
+// resolveFinishReason 解析出工具调用时结束原因为 tool_calls
+func (ch *chatChoice) resolveFinishReason(finishReason string) string {
+	if ch.tools != nil && len(ch.tools.calls) > 0 {
+		return "tool_calls"
+	}
+	return finishReason
+}
+
+// chatAggregateSink 收集全部候选的内容后返回 chat.completion 响应
+type chatAggregateSink struct {
+	mu      sync.Mutex
+	c       *gin.Context
+	id      string
+	created int64
+	model   string
+	choices []*chatChoice
+	usage   tokenUsage
+
+	// 已结束的候选数
+	finished int
+	// 是否已写出响应
+	responded bool
+	// 为 true 时所有候选均为空也正常返回
+	allowEmpty bool
+}
+
+func newChatAggregateSink(c *gin.Context, model string, choices []*chatChoice) *chatAggregateSink {
+	return &chatAggregateSink{
+		c:       c,
+		id:      newChatCompletionID(),
+		created: time.Now().Unix(),
+		model:   model,
+		choices: choices,
+	}
+}
+
+// queueChoice 非流式模式下通过响应头返回排队位置
+func (s *chatAggregateSink) queueChoice(index int, ev *traesse.QueueEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	setQueueHeader(s.c, ev)
+	return nil
+}
+
+func (s *chatAggregateSink) deltaChoice(index int, d completionDelta) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ch := s.choices[index]
+	content, reasoning, _ := ch.split(d)
+	ch.content.WriteString(content)
+	ch.reasoning.WriteString(reasoning)
+	return nil
+}
+
+func (s *chatAggregateSink) finishChoice(index int, finishReason string, usage tokenUsage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ch := s.choices[index]
+	ch.content.WriteString(ch.flush())
+	ch.finishReason = ch.resolveFinishReason(finishReason)
+	s.usage = s.usage.add(usage)
+	s.finished++
+
+	if s.responded || s.finished < len(s.choices) {
+		return nil
+	}
+	s.responded = true
+
+	// 如果没有收集到任何响应，返回错误
+	empty := true
+	for _, ch := range s.choices {
+		if ch.content.Len() > 0 || ch.reasoning.Len() > 0 || (ch.tools != nil && len(ch.tools.calls) > 0) {
+			empty = false
+			break
+		}
+	}
+	if empty && !s.allowEmpty {
+		writeError(s.c, http.StatusInternalServerError, "api_error", "未收到远程服务的响应")
+		return nil
+	}
+
+	choices := make([]map[string]interface{}, len(s.choices))
+	for i, ch := range s.choices {
+		choices[i] = map[string]interface{}{
+			"index":         i,
+			"message":       ch.message(),
+			"finish_reason": ch.finishReason,
+		}
+	}
+
+	// 构造与OpenAI兼容的响应格式
+	s.c.JSON(http.StatusOK, map[string]interface{}{
+		"id":                 s.id,
+		"object":             "chat.completion",
+		"created":            s.created,
+		"model":              s.model,
+		"system_fingerprint": systemFingerprint,
+		"choices":            choices,
+		"usage":              s.usage.openAI(),
+	})
+	return nil
+}
+
+// message 构造非流式响应中的 message 对象
+func (ch *chatChoice) message() map[string]interface{} {
+	message := map[string]interface{}{
+		"role":    "assistant",
+		"content": ch.content.String(),
+	}
+	if ch.reasoning.Len() > 0 {
+		message["reasoning_content"] = ch.reasoning.String()
+	}
+	if ch.tools != nil && len(ch.tools.calls) > 0 {
+		// 非流式响应中的工具调用不带 index
+		calls := make([]ToolCall, len(ch.tools.calls))
+		for i, call := range ch.tools.calls {
+			call.Index = nil
+			calls[i] = call
+		}
+		message["tool_calls"] = calls
+		if text := strings.TrimSpace(ch.content.String()); text != "" {
+			message["content"] = text
+		} else {
+			message["content"] = nil
+		}
+	}
+	return message
+}
+
+// failChoice 任一候选失败时整个请求返回错误
+func (s *chatAggregateSink) failChoice(index int, status int, errType string, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fail(status, errType, message)
+}
+
+func (s *chatAggregateSink) queueTimeoutChoice(index int, retryAfter int, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.responded {
+		writeQueueTimeout(s.c, retryAfter, message, s.fail)
+	}
+}
+
+// fail 返回错误响应，调用方需持有 s.mu
+func (s *chatAggregateSink) fail(status int, errType string, message string) {
+	if s.responded {
+		return
+	}
+	s.responded = true
+	writeError(s.c, status, errType, message)
+}
+
+// chatStreamSink 以 chat.completion.chunk 的形式逐条写出内容，多个候选的数据块按到达顺序交错输出
+type chatStreamSink struct {
+	mu      sync.Mutex
+	c       *gin.Context
+	id      string
+	created int64
+	model   string
+	choices []*chatChoice
+	usage   tokenUsage
+	started bool
+	// 已结束的候选数
+	finished int
+	// 是否已在流中返回错误
+	failed bool
+
+	// includeUsage 为 true 时在结束前额外发送携带 usage 的数据块
+	includeUsage bool
+}
+
+func newChatStreamSink(c *gin.Context, model string, choices []*chatChoice) *chatStreamSink {
+	return &chatStreamSink{
+		c:       c,
+		id:      newChatCompletionID(),
+		created: time.Now().Unix(),
+		model:   model,
+		choices: choices,
+	}
+}
+
+// start 在首次写出数据前设置流式响应头，并为候选写出只包含角色的第一个数据块
+func (s *chatStreamSink) start(index int) error {
+	if !s.started {
+		s.started = true
+		s.c.Header("Content-Type", "text/event-stream")
+		s.c.Header("Cache-Control", "no-cache")
+		s.c.Header("Connection", "keep-alive")
+		s.c.Header("Transfer-Encoding", "chunked")
+	}
+
+	ch := s.choices[index]
+	if ch.started {
+		return nil
+	}
+	ch.started = true
+	return s.writeChunk(index, map[string]interface{}{"role": "assistant", "content": ""}, nil)
+}
+
+// writeChunk 写出指定候选的一个 chat.completion.chunk
+func (s *chatStreamSink) writeChunk(index int, delta map[string]interface{}, finishReason interface{}) error {
+	return s.writeRawChunk([]map[string]interface{}{
+		{
+			"index":         index,
+			"delta":         delta,
+			"finish_reason": finishReason,
+		},
+	}, nil)
+}
+
+// writeRawChunk 写出指定 choices 的数据块，开启 include_usage 时每个数据块都带有 usage 字段
+func (s *chatStreamSink) writeRawChunk(choices []map[string]interface{}, usage map[string]interface{}) error {
+	chunk := map[string]interface{}{
+		"id":                 s.id,
+		"object":             "chat.completion.chunk",
+		"created":            s.created,
+		"model":              s.model,
+		"system_fingerprint": systemFingerprint,
+		"choices":            choices,
+	}
+	if s.includeUsage {
+		chunk["usage"] = usage
+	}
+	return writeSSEData(s.c, chunk)
+}
+
+func (s *chatStreamSink) queueChoice(index int, ev *traesse.QueueEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// 出错后不再输出其他候选的内容
+	if s.failed {
+		return nil
+	}
+
+	// 排队状态通过响应头与 SSE 注释返回，不写入回复内容。排队期间不开始输出
+	setQueueHeader(s.c, ev)
+	if !s.started {
+		return nil
+	}
+	return writeQueueComment(s.c, ev)
+}
+
+func (s *chatStreamSink) deltaChoice(index int, d completionDelta) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// 出错后不再输出其他候选的内容
+	if s.failed {
+		return nil
+	}
+
+	if err := s.start(index); err != nil {
+		return err
+	}
+
+	content, reasoning, calls := s.choices[index].split(d)
+	if err := s.writeDelta(index, content, reasoning); err != nil {
+		return err
+	}
+	for _, call := range calls {
+		if err := s.writeChunk(index, map[string]interface{}{"tool_calls": []ToolCall{call}}, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeDelta 写出正文与推理内容增量
+func (s *chatStreamSink) writeDelta(index int, content string, reasoning string) error {
+	if content == "" && reasoning == "" {
+		return nil
+	}
+
+	delta := map[string]interface{}{}
+	if content != "" {
+		delta["content"] = content
+	}
+	if reasoning != "" {
+		delta["reasoning_content"] = reasoning
+	}
+	return s.writeChunk(index, delta, nil)
+}
+
+func (s *chatStreamSink) finishChoice(index int, finishReason string, usage tokenUsage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// 出错后不再输出其他候选的内容
+	if s.failed {
+		return nil
+	}
+
+	if err := s.start(index); err != nil {
+		return err
+	}
+
+	ch := s.choices[index]
+	if err := s.writeDelta(index, ch.flush(), ""); err != nil {
+		return err
+	}
+	if err := s.writeChunk(index, map[string]interface{}{}, ch.resolveFinishReason(finishReason)); err != nil {
+		return err
+	}
+	s.usage = s.usage.add(usage)
+	s.finished++
+
+	// 所有候选结束后写出用量与结束标记
+	if s.failed || s.finished < len(s.choices) {
+		return nil
+	}
+	if s.includeUsage {
+		if err := s.writeRawChunk([]map[string]interface{}{}, s.usage.openAI()); err != nil {
+			return err
+		}
+	}
+	return writeSSEDone(s.c)
+}
+
+func (s *chatStreamSink) failChoice(index int, status int, errType string, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fail(status, errType, message)
+}
+
+func (s *chatStreamSink) queueTimeoutChoice(index int, retryAfter int, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.failed {
+		writeQueueTimeout(s.c, retryAfter, message, s.fail)
+	}
+}
+
+// fail 返回错误，已开始输出时以 data 消息写入流中，调用方需持有 s.mu
+func (s *chatStreamSink) fail(status int, errType string, message string) {
+	if s.failed {
+		return
+	}
+	s.failed = true
+
+	// 尚未开始输出时仍可返回普通的错误响应
+	if !s.started {
+		s.started = true
+		writeError(s.c, status, errType, message)
+		return
+	}
+	_ = writeSSEError(s.c, status, errType, message)
+}
+
+// writeSSEError 在已开始的流中写出错误，与 OpenAI 一致以 data 消息的形式返回
+func writeSSEError(c *gin.Context, status int, errType string, message string) error {
+	return writeSSEData(c, gin.H{
+		"error": map[string]interface{}{
+			"message": message,
+			"type":    errType,
+			"code":    status,
+		},
+	})
+}
+
+// writeSSEData 以 "data: <json>" 的形式写出一条 SSE 消息
+func writeSSEData(c *gin.Context, v interface{}) error {
+	responseJSON, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if _, err := c.Writer.Write([]byte("data: " + string(responseJSON) + "\n\n")); err != nil {
+		return err
+	}
+	c.Writer.Flush()
+	return nil
+}
+
+// writeSSEDone 写出流结束标记
+func writeSSEDone(c *gin.Context) error {
+	if _, err := c.Writer.Write([]byte("data: [DONE]\n\n")); err != nil {
+		return err
+	}
+	c.Writer.Flush()
+	return nil
+}
@@ -0,0 +1,201 @@
+package api_test
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/trae2api/pkg/traefake"
+)
+
+var update = flag.Bool("update", false, "更新 testdata 中的 golden 文件")
+
+var (
+	chunkIDPattern      = regexp.MustCompile(`"id":"chatcmpl-[0-9a-f]{32}"`)
+	chunkCreatedPattern = regexp.MustCompile(`"created":\d+`)
+)
+
+// sseData 返回流式响应中所有 data 消息的内容
+func sseData(body string) []string {
+	var data []string
+	for _, line := range strings.Split(body, "\n") {
+		if strings.HasPrefix(line, "data: ") {
+			data = append(data, strings.TrimPrefix(line, "data: "))
+		}
+	}
+	return data
+}
+
+// chatChunks 解析流式响应中的 chat.completion.chunk，不包含结束标记
+func chatChunks(t *testing.T, body string) []map[string]interface{} {
+	t.Helper()
+	var chunks []map[string]interface{}
+	for _, data := range sseData(body) {
+		if data == "[DONE]" {
+			continue
+		}
+		var chunk map[string]interface{}
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			t.Fatalf("解析数据块失败: %v, data: %s", err, data)
+		}
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+// checkGolden 校验同一响应的所有数据块共用 id 与 created，替换为固定值后与 golden 文件比较
+func checkGolden(t *testing.T, name string, body string) {
+	t.Helper()
+
+	ids := chunkIDPattern.FindAllString(body, -1)
+	if len(ids) == 0 {
+		t.Fatalf("响应中没有 chatcmpl- 开头的 id: %s", body)
+	}
+	for _, id := range ids {
+		if id != ids[0] {
+			t.Fatalf("数据块的 id 不一致: %s 与 %s", ids[0], id)
+		}
+	}
+	created := chunkCreatedPattern.FindAllString(body, -1)
+	for _, c := range created {
+		if c != created[0] {
+			t.Fatalf("数据块的 created 不一致: %s 与 %s", created[0], c)
+		}
+	}
+
+	got := chunkIDPattern.ReplaceAllString(body, `"id":"chatcmpl-test"`)
+	got = chunkCreatedPattern.ReplaceAllString(got, `"created":0`)
+
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := os.MkdirAll("testdata", 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("读取 golden 文件失败: %v（使用 -update 生成）", err)
+	}
+	if got != string(want) {
+		t.Errorf("%s 与 golden 文件不一致\n got:\n%s\nwant:\n%s", name, got, want)
+	}
+}
+
+func TestChatChunksGolden(t *testing.T) {
+	tests := []struct {
+		name   string
+		script traefake.Script
+		extra  map[string]interface{}
+	}{
+		{
+			name:   "chat_chunks_basic",
+			script: traefake.Reply("", "Hello there, friend.", "stop"),
+		},
+		{
+			name:   "chat_chunks_reasoning",
+			script: traefake.Reply("Think first.", "Then answer.", "stop"),
+		},
+		{
+			name:   "chat_chunks_reasoning_content",
+			script: traefake.Reply("Think first.", "Then answer.", "stop"),
+			extra:  map[string]interface{}{"reasoning_format": "reasoning_content"},
+		},
+		{
+			name:   "chat_chunks_length",
+			script: traefake.Reply("", "Cut off", "length"),
+		},
+		{
+			name:   "chat_chunks_include_usage",
+			script: traefake.Reply("Think first.", "Then answer.", "stop"),
+			extra:  map[string]interface{}{"stream_options": map[string]interface{}{"include_usage": true}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, traefake.Options{})
+			env.fake.SetDefault(tt.script)
+
+			extra := map[string]interface{}{"stream": true}
+			for k, v := range tt.extra {
+				extra[k] = v
+			}
+			w := env.chat(t, "gpt-4o", "hi", extra)
+			if w.Code != http.StatusOK {
+				t.Fatalf("状态码 %d, body: %s", w.Code, w.Body.String())
+			}
+			checkGolden(t, tt.name, w.Body.String())
+		})
+	}
+}
+
+func TestChatChunksFinishReason(t *testing.T) {
+	// 上游结束原因 -> OpenAI finish_reason，覆盖 finishReasons 表的每一行
+	tests := []struct {
+		upstream string
+		want     string
+	}{
+		{upstream: "stop", want: "stop"},
+		{upstream: "end_turn", want: "stop"},
+		{upstream: "stop_sequence", want: "stop"},
+		{upstream: "eos", want: "stop"},
+		{upstream: "length", want: "length"},
+		{upstream: "max_tokens", want: "length"},
+		{upstream: "tool_calls", want: "tool_calls"},
+		{upstream: "tool_use", want: "tool_calls"},
+		{upstream: "function_call", want: "tool_calls"},
+		{upstream: "content_filter", want: "content_filter"},
+		{upstream: "sensitive", want: "content_filter"},
+		{upstream: "safety", want: "content_filter"},
+		{upstream: "MAX_TOKENS", want: "length"},
+		{upstream: "", want: "stop"},
+		{upstream: "something_new", want: "stop"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.upstream, func(t *testing.T) {
+			env := newTestEnv(t, traefake.Options{})
+			env.fake.SetDefault(traefake.Reply("", "Some text.", tt.upstream))
+
+			w := env.chat(t, "gpt-4o", "hi", map[string]interface{}{"stream": true})
+			chunks := chatChunks(t, w.Body.String())
+			if len(chunks) < 3 {
+				t.Fatalf("数据块过少: %s", w.Body.String())
+			}
+
+			// 只有最后一个数据块带有结束原因，且其 delta 为空
+			for i, chunk := range chunks {
+				choice := chunk["choices"].([]interface{})[0].(map[string]interface{})
+				reason := choice["finish_reason"]
+				if i < len(chunks)-1 {
+					if reason != nil {
+						t.Errorf("第 %d 个数据块的 finish_reason 为 %v", i, reason)
+					}
+					continue
+				}
+				if reason != tt.want {
+					t.Errorf("finish_reason = %v, want %s", reason, tt.want)
+				}
+				if delta := choice["delta"].(map[string]interface{}); len(delta) != 0 {
+					t.Errorf("最后一个数据块的 delta 不为空: %v", delta)
+				}
+			}
+
+			// 非流式响应使用相同的映射
+			w = env.chat(t, "gpt-4o", "hi", nil)
+			choice := decodeJSON(t, w)["choices"].([]interface{})[0].(map[string]interface{})
+			if choice["finish_reason"] != tt.want {
+				t.Errorf("非流式 finish_reason = %v, want %s", choice["finish_reason"], tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/trae2api/config"
+	"github.com/trae2api/pkg/traesse"
+)
+
+// multiChoiceSink 接收多个候选回复的输出，index 为候选序号
+//
+// 各候选的管道在不同的 goroutine 中运行，实现需要自行保证并发安全。
+type multiChoiceSink interface {
+	queueChoice(index int, ev *traesse.QueueEvent) error
+	deltaChoice(index int, d completionDelta) error
+	finishChoice(index int, finishReason string, usage tokenUsage) error
+	failChoice(index int, status int, errType string, message string)
+	queueTimeoutChoice(index int, retryAfter int, message string)
+}
+
+// choiceSink 将单个候选的管道输出转发到 multiChoiceSink 中对应的序号
+type choiceSink struct {
+	index  int
+	parent multiChoiceSink
+}
+
+func (s *choiceSink) Queue(ev *traesse.QueueEvent) error {
+	return s.parent.queueChoice(s.index, ev)
+}
+
+func (s *choiceSink) Delta(d completionDelta) error {
+	return s.parent.deltaChoice(s.index, d)
+}
+
+func (s *choiceSink) Finish(finishReason string, usage tokenUsage) error {
+	return s.parent.finishChoice(s.index, finishReason, usage)
+}
+
+func (s *choiceSink) Fail(status int, errType string, message string) {
+	s.parent.failChoice(s.index, status, errType, message)
+}
+
+func (s *choiceSink) QueueTimeout(retryAfter int, message string) {
+	s.parent.queueTimeoutChoice(s.index, retryAfter, message)
+}
+
+// maxChoices 返回单次请求允许的最大候选数
+func maxChoices() int {
+	n, err := strconv.Atoi(config.MaxChoices)
+	if err != nil || n < 1 {
+		return 4
+	}
+	return n
+}
+
+// runChoices 并发执行 n 个候选请求，每个候选使用独立的会话ID
+//
+// run 负责执行单个候选的管道，所有候选结束后返回。
+func runChoices(chatReq ChatRequest, n int, parent multiChoiceSink, run func(ChatRequest, completionSink)) {
+	if n <= 1 {
+		run(chatReq, &choiceSink{index: 0, parent: parent})
+		return
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		choiceReq := chatReq
+		choiceReq.SessionID = uuid.New().String()
+
+		wg.Add(1)
+		go func(index int, req ChatRequest) {
+			defer wg.Done()
+			run(req, &choiceSink{index: index, parent: parent})
+		}(i, choiceReq)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,84 @@
+package api_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/trae2api/pkg/traefake"
+)
+
+func TestChatCompletionMultipleChoicesStream(t *testing.T) {
+	env := newTestEnv(t, traefake.Options{})
+	env.fake.SetDefault(traefake.Reply("Think first.", "Then answer.", "stop"))
+	extra := map[string]interface{}{
+		"reasoning_format": "reasoning_content",
+		"stream_options":   map[string]interface{}{"include_usage": true},
+		"stream":           true,
+	}
+
+	w := env.chat(t, "deepseek-r1", "hi", extra)
+	if w.Code != http.StatusOK {
+		t.Fatalf("状态码 %d, body: %s", w.Code, w.Body.String())
+	}
+	single := streamUsage(t, w.Body.String())
+
+	extra["n"] = 2
+	w = env.chat(t, "deepseek-r1", "hi", extra)
+	if w.Code != http.StatusOK {
+		t.Fatalf("状态码 %d, body: %s", w.Code, w.Body.String())
+	}
+	if n := len(env.fake.Requests()); n != 3 {
+		t.Errorf("上游请求 %d 次, 期望 3", n)
+	}
+
+	// 按 index 汇总每个候选的内容与结束原因
+	content := map[float64]string{}
+	reasoning := map[float64]string{}
+	finishReasons := map[float64][]interface{}{}
+	chunks := chatChunks(t, w.Body.String())
+	for _, chunk := range chunks {
+		for _, raw := range chunk["choices"].([]interface{}) {
+			choice := raw.(map[string]interface{})
+			index, ok := choice["index"].(float64)
+			if !ok || (index != 0 && index != 1) {
+				t.Fatalf("index = %v", choice["index"])
+			}
+			delta := choice["delta"].(map[string]interface{})
+			if s, ok := delta["content"].(string); ok {
+				content[index] += s
+			}
+			if s, ok := delta["reasoning_content"].(string); ok {
+				reasoning[index] += s
+			}
+			if reason := choice["finish_reason"]; reason != nil {
+				finishReasons[index] = append(finishReasons[index], reason)
+			}
+		}
+	}
+	for _, index := range []float64{0, 1} {
+		if content[index] != "Then answer." || reasoning[index] != "Think first." {
+			t.Errorf("候选 %v: content %q, reasoning %q", index, content[index], reasoning[index])
+		}
+		// 每个候选各自只结束一次
+		if got := finishReasons[index]; len(got) != 1 || got[0] != "stop" {
+			t.Errorf("候选 %v 的 finish_reason = %v", index, got)
+		}
+	}
+
+	// 所有候选结束后才输出 usage，用量为各候选之和
+	last := chunks[len(chunks)-1]
+	if choices := last["choices"].([]interface{}); len(choices) != 0 {
+		t.Errorf("usage 数据块的 choices 不为空: %v", choices)
+	}
+	usage := streamUsage(t, w.Body.String())
+	for _, key := range []string{"prompt_tokens", "completion_tokens", "total_tokens"} {
+		if usage[key] != 2*single[key].(float64) {
+			t.Errorf("%s = %v, 单个候选为 %v", key, usage[key], single[key])
+		}
+	}
+	details := usage["completion_tokens_details"].(map[string]interface{})
+	singleDetails := single["completion_tokens_details"].(map[string]interface{})
+	if details["reasoning_tokens"] != 2*singleDetails["reasoning_tokens"].(float64) {
+		t.Errorf("reasoning_tokens = %v, 单个候选为 %v", details["reasoning_tokens"], singleDetails["reasoning_tokens"])
+	}
+}
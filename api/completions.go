@@ -0,0 +1,273 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/trae2api/config"
+	"github.com/trae2api/pkg/logger"
+	"github.com/trae2api/pkg/traesse"
+)
+
+// 文本补全模式下的系统提示词
+const completionSystemPrompt = "你是一个文本补全引擎。请直接输出紧接在给定文本之后的续写内容，不要重复给定文本，不要添加任何解释、前言或格式标记。"
+
+// CompletionRequest OpenAI 旧版文本补全请求体
+type CompletionRequest struct {
+	Model       string      `json:"model"`
+	Prompt      interface{} `json:"prompt"`
+	Suffix      string      `json:"suffix,omitempty"`
+	Echo        bool        `json:"echo,omitempty"`
+	Stop        interface{} `json:"stop,omitempty"`
+	Stream      bool        `json:"stream"`
+	Temperature float64     `json:"temperature,omitempty"`
+	MaxTokens   int         `json:"max_tokens,omitempty"`
+
+	StreamOptions *StreamOptions `json:"stream_options,omitempty"`
+}
+
+// CreateCompletion 处理 /v1/completions 请求，将 prompt 转换为单轮对话
+func CreateCompletion(c *gin.Context) {
+	// 检查 RefreshToken 是否过期
+	if config.IsRefreshTokenExpired() {
+		writeError(c, http.StatusUnauthorized, "token_expired", "RefreshToken 已过期，请更新环境变量中的 REFRESH_TOKEN")
+		return
+	}
+
+	var compReq CompletionRequest
+	if err := c.BindJSON(&compReq); err != nil {
+		logger.Log.Errorf("解析请求体失败: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 检查模型是否支持
+	if !isModelSupported(compReq.Model) {
+		errMsg := fmt.Sprintf("不支持的模型: %s", compReq.Model)
+		logger.Log.Errorf("%s", errMsg)
+		writeError(c, http.StatusBadRequest, "invalid_request_error", errMsg)
+		return
+	}
+
+	prompt, err := completionPromptText(compReq.Prompt)
+	if err != nil {
+		writeError(c, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+	if prompt == "" {
+		writeError(c, http.StatusBadRequest, "invalid_request_error", "prompt 不能为空")
+		return
+	}
+
+	stops, err := parseStopSequences("stop", compReq.Stop, maxStopSequences)
+	if err != nil {
+		writeError(c, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+
+	userInput := prompt
+	if compReq.Suffix != "" {
+		userInput = fmt.Sprintf("%s\n\n续写内容之后紧接着以下文本，请确保续写内容能与其自然衔接，且不要输出这段文本：\n%s", prompt, compReq.Suffix)
+	}
+
+	chatReq := ChatRequest{
+		Model: convertModelName(compReq.Model),
+		Messages: []ChatMessage{
+			{Role: "system", Content: completionSystemPrompt},
+			{Role: "user", Content: userInput},
+		},
+		Stream:        compReq.Stream,
+		Temperature:   compReq.Temperature,
+		MaxTokens:     compReq.MaxTokens,
+		StopSequences: stops,
+	}
+
+	base := completionBase{
+		c:       c,
+		id:      "cmpl-" + strings.ReplaceAll(uuid.New().String(), "-", ""),
+		created: time.Now().Unix(),
+		model:   compReq.Model,
+		// stop 序列可能出现在回复开头，此时内容为空属于正常结果
+		allowEmpty: len(stops) > 0,
+	}
+	if compReq.Echo {
+		base.echo = prompt
+	}
+
+	var sink completionSink
+	if compReq.Stream {
+		sink = &completionStreamSink{
+			completionBase: base,
+			includeUsage:   compReq.StreamOptions != nil && compReq.StreamOptions.IncludeUsage,
+		}
+	} else {
+		sink = &completionAggregateSink{completionBase: base}
+	}
+	runChatPipeline(c, chatReq, sink)
+}
+
+// completionPromptText 将字符串或字符串数组形式的 prompt 合并为一段文本
+func completionPromptText(prompt interface{}) (string, error) {
+	switch v := prompt.(type) {
+	case string:
+		return v, nil
+	case []interface{}:
+		parts := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return "", fmt.Errorf("prompt 仅支持字符串或字符串数组")
+			}
+			parts = append(parts, s)
+		}
+		return strings.Join(parts, "\n"), nil
+	case nil:
+		return "", nil
+	default:
+		return "", fmt.Errorf("prompt 仅支持字符串或字符串数组")
+	}
+}
+
+// completionBase 文本补全 sink 的公共部分
+type completionBase struct {
+	c       *gin.Context
+	id      string
+	created int64
+	model   string
+	echo    string
+	// 为 true 时补全内容为空也正常返回
+	allowEmpty bool
+}
+
+// object 构造 text_completion 对象
+func (b *completionBase) object(text string, finishReason interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"id":      b.id,
+		"object":  "text_completion",
+		"created": b.created,
+		"model":   b.model,
+		"choices": []map[string]interface{}{
+			{
+				"text":          text,
+				"index":         0,
+				"logprobs":      nil,
+				"finish_reason": finishReason,
+			},
+		},
+	}
+}
+
+// completionAggregateSink 收集全部内容后返回 text_completion 对象
+type completionAggregateSink struct {
+	completionBase
+	text strings.Builder
+}
+
+func (s *completionAggregateSink) Queue(ev *traesse.QueueEvent) error {
+	setQueueHeader(s.c, ev)
+	return nil
+}
+
+// Delta 文本补全不返回 reasoning_content
+func (s *completionAggregateSink) Delta(d completionDelta) error {
+	s.text.WriteString(d.Content)
+	return nil
+}
+
+func (s *completionAggregateSink) Finish(finishReason string, usage tokenUsage) error {
+	if s.text.Len() == 0 && !s.allowEmpty {
+		s.Fail(http.StatusInternalServerError, "api_error", "未收到远程服务的响应")
+		return nil
+	}
+
+	resp := s.object(s.echo+s.text.String(), finishReason)
+	resp["usage"] = usage.openAI()
+	s.c.JSON(http.StatusOK, resp)
+	return nil
+}
+
+func (s *completionAggregateSink) Fail(status int, errType string, message string) {
+	writeError(s.c, status, errType, message)
+}
+
+func (s *completionAggregateSink) QueueTimeout(retryAfter int, message string) {
+	writeQueueTimeout(s.c, retryAfter, message, s.Fail)
+}
+
+// completionStreamSink 逐条写出 text_completion 分片
+type completionStreamSink struct {
+	completionBase
+	started bool
+
+	// includeUsage 为 true 时在结束前额外发送携带 usage 的数据块
+	includeUsage bool
+}
+
+// start 设置流式响应头，开启 echo 时先输出原始 prompt
+func (s *completionStreamSink) start() error {
+	if s.started {
+		return nil
+	}
+	s.started = true
+	s.c.Header("Content-Type", "text/event-stream")
+	s.c.Header("Cache-Control", "no-cache")
+	s.c.Header("Connection", "keep-alive")
+
+	if s.echo != "" {
+		return writeSSEData(s.c, s.object(s.echo, nil))
+	}
+	return nil
+}
+
+// Queue 排队期间不开始输出，已开始输出时以 SSE 注释返回排队位置
+func (s *completionStreamSink) Queue(ev *traesse.QueueEvent) error {
+	setQueueHeader(s.c, ev)
+	if !s.started {
+		return nil
+	}
+	return writeQueueComment(s.c, ev)
+}
+
+func (s *completionStreamSink) Delta(d completionDelta) error {
+	if err := s.start(); err != nil {
+		return err
+	}
+	if d.Content == "" {
+		return nil
+	}
+	return writeSSEData(s.c, s.object(d.Content, nil))
+}
+
+func (s *completionStreamSink) Finish(finishReason string, usage tokenUsage) error {
+	if err := s.start(); err != nil {
+		return err
+	}
+	if err := writeSSEData(s.c, s.object("", finishReason)); err != nil {
+		return err
+	}
+	if s.includeUsage {
+		chunk := s.object("", nil)
+		chunk["choices"] = []map[string]interface{}{}
+		chunk["usage"] = usage.openAI()
+		if err := writeSSEData(s.c, chunk); err != nil {
+			return err
+		}
+	}
+	return writeSSEDone(s.c)
+}
+
+func (s *completionStreamSink) Fail(status int, errType string, message string) {
+	if !s.started {
+		writeError(s.c, status, errType, message)
+		return
+	}
+	_ = writeSSEError(s.c, status, errType, message)
+}
+
+func (s *completionStreamSink) QueueTimeout(retryAfter int, message string) {
+	writeQueueTimeout(s.c, retryAfter, message, s.Fail)
+}
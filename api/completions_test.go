@@ -0,0 +1,222 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/trae2api/pkg/traefake"
+)
+
+// completion 发送 /v1/completions 请求，extra 中的字段合并到请求体
+func (e *testEnv) completion(t *testing.T, prompt interface{}, extra map[string]interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	body := map[string]interface{}{"model": "gpt-4o", "prompt": prompt}
+	for k, v := range extra {
+		body[k] = v
+	}
+	return e.do(t, http.MethodPost, "/v1/completions", body)
+}
+
+// completionText 返回非流式文本补全的 text 与 finish_reason
+func completionText(t *testing.T, w *httptest.ResponseRecorder) (string, interface{}) {
+	t.Helper()
+	if w.Code != http.StatusOK {
+		t.Fatalf("状态码 %d, body: %s", w.Code, w.Body.String())
+	}
+	choice := decodeJSON(t, w)["choices"].([]interface{})[0].(map[string]interface{})
+	return choice["text"].(string), choice["finish_reason"]
+}
+
+// completionChunks 解析流式文本补全，返回每个分片的 text、结束原因与最后的 usage
+func completionChunks(t *testing.T, body string) (texts []string, finishReason interface{}, usage map[string]interface{}) {
+	t.Helper()
+	for _, data := range sseData(body) {
+		if data == "[DONE]" {
+			continue
+		}
+		var chunk map[string]interface{}
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			t.Fatalf("解析数据块失败: %v, data: %s", err, data)
+		}
+		if u, ok := chunk["usage"].(map[string]interface{}); ok {
+			usage = u
+		}
+		for _, raw := range chunk["choices"].([]interface{}) {
+			choice := raw.(map[string]interface{})
+			if text := choice["text"].(string); text != "" {
+				texts = append(texts, text)
+			}
+			if reason := choice["finish_reason"]; reason != nil {
+				finishReason = reason
+			}
+		}
+	}
+	return texts, finishReason, usage
+}
+
+func TestCompletionEcho(t *testing.T) {
+	env := newTestEnv(t, traefake.Options{})
+	env.fake.SetDefault(traefake.Reply("", " there was a fox.", "stop"))
+	extra := map[string]interface{}{"echo": true}
+
+	// 开启 echo 时回复以原始 prompt 开头
+	text, finishReason := completionText(t, env.completion(t, "Once upon a time", extra))
+	if text != "Once upon a time there was a fox." || finishReason != "stop" {
+		t.Errorf("text %q, finish_reason %v", text, finishReason)
+	}
+
+	// 流式响应先单独输出 prompt
+	extra["stream"] = true
+	texts, finishReason, _ := completionChunks(t, env.completion(t, "Once upon a time", extra).Body.String())
+	if len(texts) == 0 || texts[0] != "Once upon a time" || strings.Join(texts, "") != "Once upon a time there was a fox." {
+		t.Errorf("texts = %q", texts)
+	}
+	if finishReason != "stop" {
+		t.Errorf("finish_reason = %v", finishReason)
+	}
+}
+
+func TestCompletionSuffix(t *testing.T) {
+	env := newTestEnv(t, traefake.Options{})
+	env.fake.SetDefault(traefake.Reply("", " b = 2", "stop"))
+
+	text, _ := completionText(t, env.completion(t, "a = 1\n", map[string]interface{}{"suffix": "\nc = 3"}))
+	if text != " b = 2" {
+		t.Errorf("text = %q", text)
+	}
+	// suffix 作为需要衔接的文本发送给上游，不会出现在回复中
+	if prompt := lastPrompt(t, env); !containsAll(prompt, "a = 1", "\nc = 3", "衔接") {
+		t.Errorf("上游对话缺少 suffix: %s", prompt)
+	}
+}
+
+func TestCompletionStopAtStart(t *testing.T) {
+	env := newTestEnv(t, traefake.Options{})
+	env.fake.SetDefault(traefake.Reply("", "END of the story.", "stop"))
+	extra := map[string]interface{}{"stop": "END"}
+
+	// 回复开头即命中 stop 序列时返回空文本
+	text, finishReason := completionText(t, env.completion(t, "The", extra))
+	if text != "" || finishReason != "stop" {
+		t.Errorf("text %q, finish_reason %v", text, finishReason)
+	}
+
+	extra["stream"] = true
+	w := env.completion(t, "The", extra)
+	texts, finishReason, _ := completionChunks(t, w.Body.String())
+	if len(texts) != 0 || finishReason != "stop" || !strings.HasSuffix(w.Body.String(), "data: [DONE]\n\n") {
+		t.Errorf("texts %q, finish_reason %v, body: %s", texts, finishReason, w.Body.String())
+	}
+}
+
+func TestCompletionIncludeUsage(t *testing.T) {
+	env := newTestEnv(t, traefake.Options{})
+	env.fake.SetDefault(traefake.Reply("", " there was a fox.", "stop"))
+
+	w := env.completion(t, "Once upon a time", map[string]interface{}{"stream": true})
+	if _, _, usage := completionChunks(t, w.Body.String()); usage != nil {
+		t.Errorf("未设置 include_usage 时不应返回 usage: %v", usage)
+	}
+
+	w = env.completion(t, "Once upon a time", map[string]interface{}{
+		"stream":         true,
+		"stream_options": map[string]interface{}{"include_usage": true},
+	})
+	// usage 在结束标记前的最后一个数据块中返回，该数据块的 choices 为空
+	data := sseData(w.Body.String())
+	if len(data) < 2 || data[len(data)-1] != "[DONE]" || !strings.Contains(data[len(data)-2], `"choices":[]`) {
+		t.Fatalf("usage 数据块位置错误: %s", w.Body.String())
+	}
+	_, _, usage := completionChunks(t, w.Body.String())
+	prompt, _ := usage["prompt_tokens"].(float64)
+	completion, _ := usage["completion_tokens"].(float64)
+	if prompt <= 0 || completion <= 0 || usage["total_tokens"] != prompt+completion {
+		t.Errorf("usage = %v", usage)
+	}
+
+	// 与非流式响应的用量一致
+	aggregate := decodeJSON(t, env.completion(t, "Once upon a time", nil))["usage"].(map[string]interface{})
+	if aggregate["prompt_tokens"] != prompt || aggregate["completion_tokens"] != completion {
+		t.Errorf("非流式 usage %v, 流式 usage %v", aggregate, usage)
+	}
+}
+
+func TestCompletionPrompt(t *testing.T) {
+	env := newTestEnv(t, traefake.Options{})
+	env.fake.SetDefault(traefake.Reply("", "ok", "stop"))
+
+	tests := []struct {
+		name       string
+		prompt     interface{}
+		wantStatus int
+	}{
+		{name: "字符串数组", prompt: []interface{}{"first", "second"}, wantStatus: http.StatusOK},
+		{name: "数组中有数字", prompt: []interface{}{"first", 2}, wantStatus: http.StatusBadRequest},
+		{name: "token 数组", prompt: []interface{}{[]interface{}{1, 2, 3}}, wantStatus: http.StatusBadRequest},
+		{name: "数字", prompt: 42, wantStatus: http.StatusBadRequest},
+		{name: "空字符串", prompt: "", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := len(env.fake.Requests())
+			w := env.completion(t, tt.prompt, nil)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("状态码 %d, 期望 %d, body: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				if errObj := decodeJSON(t, w)["error"].(map[string]interface{}); errObj["type"] != "invalid_request_error" {
+					t.Errorf("error = %v", errObj)
+				}
+				if len(env.fake.Requests()) != before {
+					t.Errorf("不应请求上游")
+				}
+			}
+		})
+	}
+
+	// 字符串数组按行合并为一段 prompt
+	if prompt := lastPrompt(t, env); !strings.Contains(prompt, "first\nsecond") {
+		t.Errorf("上游对话: %s", prompt)
+	}
+}
+
+func TestCompletionStreamError(t *testing.T) {
+	env := newTestEnv(t, traefake.Options{})
+
+	// 已开始输出后上游中断，与对话补全相同以 data 消息返回错误，不写出 [DONE]
+	w := env.completion(t, "[fake:disconnect] hi", map[string]interface{}{"stream": true})
+	body := w.Body.String()
+	data := sseData(body)
+	if len(data) == 0 {
+		t.Fatalf("响应为空")
+	}
+	var last map[string]interface{}
+	if err := json.Unmarshal([]byte(data[len(data)-1]), &last); err != nil {
+		t.Fatalf("解析错误消息失败: %v, body: %s", err, body)
+	}
+	errObj, ok := last["error"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("流没有以错误结束: %s", body)
+	}
+	if keys := []string{"code", "message", "type"}; !reflect.DeepEqual(sortedKeys(errObj), keys) {
+		t.Errorf("error 字段 %v, want %v", sortedKeys(errObj), keys)
+	}
+	if strings.Contains(body, "event:") || strings.Contains(body, "[DONE]") {
+		t.Errorf("body: %s", body)
+	}
+}
+
+// sortedKeys 返回 map 的键，按字母顺序排列
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
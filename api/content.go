@@ -0,0 +1,108 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// flattenContent 将消息内容按顺序转换为纯文本
+//
+// 支持 OpenAI Chat、Responses 以及 Anthropic 三种格式的内容数组：
+// 所有文本类内容按原顺序以换行拼接，无法直接转换为文本的内容（文件、音频、
+// 未随请求上传的图片等）渲染为显式的占位符，避免静默丢弃。
+// omitImages 为 true 时表示图片已通过 multi_media 发送，不再输出占位符。
+func flattenContent(content interface{}, omitImages bool) string {
+	switch v := content.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []interface{}:
+		parts := make([]string, 0, len(v))
+		for _, item := range v {
+			if text := flattenContentPart(item, omitImages); text != "" {
+				parts = append(parts, text)
+			}
+		}
+		return strings.Join(parts, "\n")
+	case map[string]interface{}:
+		return flattenContentPart(v, omitImages)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// flattenContentPart 转换单个内容块
+func flattenContentPart(item interface{}, omitImages bool) string {
+	part, ok := item.(map[string]interface{})
+	if !ok {
+		if s, ok := item.(string); ok {
+			return s
+		}
+		return fmt.Sprintf("%v", item)
+	}
+
+	partType, _ := part["type"].(string)
+	switch partType {
+	case "text", "input_text", "output_text":
+		text, _ := part["text"].(string)
+		return text
+	case "refusal":
+		refusal, _ := part["refusal"].(string)
+		return refusal
+	case "image_url", "input_image", "image":
+		if omitImages {
+			return ""
+		}
+		return "[图片: " + imagePlaceholderSource(part) + "]"
+	case "file", "input_file", "document":
+		return "[文件: " + filePlaceholderName(part) + "]"
+	case "input_audio":
+		return "[音频]"
+	case "tool_use":
+		input, _ := json.Marshal(part["input"])
+		return fmt.Sprintf("[tool_use %v] %s", part["name"], input)
+	case "tool_result":
+		return "[tool_result] " + flattenContent(part["content"], omitImages)
+	case "thinking", "redacted_thinking":
+		// 历史中的思考内容不再发送给模型
+		return ""
+	default:
+		if text, ok := part["text"].(string); ok {
+			return text
+		}
+		return fmt.Sprintf("[不支持的内容类型: %s]", partType)
+	}
+}
+
+// imagePlaceholderSource 返回图片占位符中展示的来源，data URI 不展示具体内容
+func imagePlaceholderSource(part map[string]interface{}) string {
+	for _, src := range extractImageSources([]interface{}{part}) {
+		if strings.HasPrefix(src, "data:") {
+			return "内联图片"
+		}
+		return src
+	}
+	return "未知来源"
+}
+
+// filePlaceholderName 返回文件占位符中展示的文件名
+func filePlaceholderName(part map[string]interface{}) string {
+	if name, ok := part["filename"].(string); ok && name != "" {
+		return name
+	}
+	for _, key := range []string{"file", "source"} {
+		if nested, ok := part[key].(map[string]interface{}); ok {
+			for _, field := range []string{"filename", "file_id", "url"} {
+				if name, ok := nested[field].(string); ok && name != "" {
+					return name
+				}
+			}
+		}
+	}
+	if id, ok := part["file_id"].(string); ok && id != "" {
+		return id
+	}
+	return "未命名文件"
+}
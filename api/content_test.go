@@ -0,0 +1,168 @@
+package api
+
+import "testing"
+
+func TestFlattenContent(t *testing.T) {
+	parts := func(items ...map[string]interface{}) []interface{} {
+		content := make([]interface{}, len(items))
+		for i, item := range items {
+			content[i] = item
+		}
+		return content
+	}
+
+	tests := []struct {
+		name       string
+		content    interface{}
+		omitImages bool
+		want       string
+	}{
+		{
+			name:    "nil",
+			content: nil,
+			want:    "",
+		},
+		{
+			name:    "字符串",
+			content: "hello",
+			want:    "hello",
+		},
+		{
+			name: "多个 text 按顺序以换行拼接",
+			content: parts(
+				map[string]interface{}{"type": "text", "text": "first"},
+				map[string]interface{}{"type": "text", "text": "second"},
+				map[string]interface{}{"type": "text", "text": "third"},
+			),
+			want: "first\nsecond\nthird",
+		},
+		{
+			name: "空文本不产生空行",
+			content: parts(
+				map[string]interface{}{"type": "text", "text": "a"},
+				map[string]interface{}{"type": "text", "text": ""},
+				map[string]interface{}{"type": "text", "text": "b"},
+			),
+			want: "a\nb",
+		},
+		{
+			name: "Responses 的 input_text 与 output_text",
+			content: parts(
+				map[string]interface{}{"type": "input_text", "text": "question"},
+				map[string]interface{}{"type": "output_text", "text": "answer"},
+			),
+			want: "question\nanswer",
+		},
+		{
+			name: "refusal",
+			content: parts(
+				map[string]interface{}{"type": "text", "text": "before"},
+				map[string]interface{}{"type": "refusal", "refusal": "I can't help with that."},
+			),
+			want: "before\nI can't help with that.",
+		},
+		{
+			name: "image_url 地址占位符",
+			content: parts(
+				map[string]interface{}{"type": "text", "text": "look"},
+				map[string]interface{}{"type": "image_url", "image_url": map[string]interface{}{"url": "https://example.com/a.png"}},
+			),
+			want: "look\n[图片: https://example.com/a.png]",
+		},
+		{
+			name: "data URI 不展示内容",
+			content: parts(
+				map[string]interface{}{"type": "image_url", "image_url": "data:image/png;base64,AAAA"},
+			),
+			want: "[图片: 内联图片]",
+		},
+		{
+			name: "Responses 的 input_image",
+			content: parts(
+				map[string]interface{}{"type": "input_image", "image_url": "https://example.com/b.jpg"},
+			),
+			want: "[图片: https://example.com/b.jpg]",
+		},
+		{
+			name: "Anthropic 的 base64 图片",
+			content: parts(
+				map[string]interface{}{"type": "image", "source": map[string]interface{}{"type": "base64", "media_type": "image/png", "data": "AAAA"}},
+			),
+			want: "[图片: 内联图片]",
+		},
+		{
+			name: "缺少来源的图片",
+			content: parts(
+				map[string]interface{}{"type": "image_url"},
+			),
+			want: "[图片: 未知来源]",
+		},
+		{
+			name: "omitImages 时不输出图片占位符",
+			content: parts(
+				map[string]interface{}{"type": "text", "text": "look"},
+				map[string]interface{}{"type": "image_url", "image_url": map[string]interface{}{"url": "https://example.com/a.png"}},
+				map[string]interface{}{"type": "input_image", "image_url": "https://example.com/b.jpg"},
+				map[string]interface{}{"type": "text", "text": "again"},
+			),
+			omitImages: true,
+			want:       "look\nagain",
+		},
+		{
+			name: "omitImages 不影响文件与音频占位符",
+			content: parts(
+				map[string]interface{}{"type": "file", "file": map[string]interface{}{"filename": "a.pdf"}},
+				map[string]interface{}{"type": "input_audio", "input_audio": map[string]interface{}{"data": "AAAA", "format": "wav"}},
+			),
+			omitImages: true,
+			want:       "[文件: a.pdf]\n[音频]",
+		},
+		{
+			name: "文件占位符",
+			content: parts(
+				map[string]interface{}{"type": "file", "file": map[string]interface{}{"file_id": "file-123"}},
+				map[string]interface{}{"type": "input_file", "filename": "report.docx"},
+				map[string]interface{}{"type": "input_file", "file_id": "file-456"},
+				map[string]interface{}{"type": "document", "source": map[string]interface{}{"type": "url", "url": "https://example.com/c.pdf"}},
+				map[string]interface{}{"type": "document", "source": map[string]interface{}{"type": "base64"}},
+			),
+			want: "[文件: file-123]\n[文件: report.docx]\n[文件: file-456]\n[文件: https://example.com/c.pdf]\n[文件: 未命名文件]",
+		},
+		{
+			name: "音频占位符",
+			content: parts(
+				map[string]interface{}{"type": "text", "text": "listen"},
+				map[string]interface{}{"type": "input_audio", "input_audio": map[string]interface{}{"data": "AAAA", "format": "mp3"}},
+			),
+			want: "listen\n[音频]",
+		},
+		{
+			name: "思考内容被丢弃",
+			content: parts(
+				map[string]interface{}{"type": "thinking", "thinking": "hmm"},
+				map[string]interface{}{"type": "text", "text": "answer"},
+			),
+			want: "answer",
+		},
+		{
+			name: "未知类型",
+			content: parts(
+				map[string]interface{}{"type": "video"},
+			),
+			want: "[不支持的内容类型: video]",
+		},
+		{
+			name:    "单个内容块",
+			content: map[string]interface{}{"type": "text", "text": "only"},
+			want:    "only",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := flattenContent(tt.content, tt.omitImages); got != tt.want {
+				t.Errorf("flattenContent() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
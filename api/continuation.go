@@ -0,0 +1,121 @@
+package api
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/trae2api/config"
+)
+
+const (
+	// 检测衔接处重复内容时参考的上一轮输出长度（字符数）
+	seamWindowRunes = 256
+	// 视为重复的最短重叠长度（字符数），避免误删偶然相同的短文本
+	seamMinOverlapRunes = 8
+)
+
+// continuation 自动继续的配置
+type continuation struct {
+	prompt    string
+	maxRounds int
+	// 所有轮次正文的总长度上限（字符数），0 表示不限制
+	maxChars int
+}
+
+// newContinuation 返回模型对应的自动继续配置，未开启时返回 nil
+func newContinuation(model string) *continuation {
+	if config.AutoContinueEnabled != "true" || !autoContinueModel(model) {
+		return nil
+	}
+	return &continuation{
+		prompt:    config.AutoContinuePrompt,
+		maxRounds: atoiOr(config.AutoContinueMaxRounds, 3),
+		maxChars:  atoiOr(config.AutoContinueMaxChars, 0),
+	}
+}
+
+// allow 判断已完成 rounds 轮继续、正文累计 chars 个字符后是否还能继续
+func (ct *continuation) allow(rounds int, chars int) bool {
+	if ct == nil || rounds >= ct.maxRounds {
+		return false
+	}
+	return ct.maxChars <= 0 || chars < ct.maxChars
+}
+
+// autoContinueModel 判断 Trae 模型是否在 AUTO_CONTINUE_MODELS 中，配置中可以使用模型ID或别名
+func autoContinueModel(model string) bool {
+	for _, item := range strings.Split(config.AutoContinueModels, ",") {
+		item = strings.TrimSpace(item)
+		if item == "*" {
+			return true
+		}
+		if item != "" && strings.EqualFold(convertModelName(item), model) {
+			return true
+		}
+	}
+	return false
+}
+
+func atoiOr(value string, fallback int) int {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return fallback
+	}
+	return n
+}
+
+// seamTrimmer 去除继续输出开头与上一轮结尾重复的内容
+//
+// 继续输出的开头会先暂存，直到能够确定重叠的长度后再放行。
+type seamTrimmer struct {
+	prev    string
+	pending strings.Builder
+	decided bool
+}
+
+func newSeamTrimmer(prev string) *seamTrimmer {
+	runes := []rune(prev)
+	if len(runes) > seamWindowRunes {
+		runes = runes[len(runes)-seamWindowRunes:]
+	}
+	return &seamTrimmer{prev: string(runes), decided: len(runes) < seamMinOverlapRunes}
+}
+
+// push 返回可以输出的文本
+func (t *seamTrimmer) push(text string) string {
+	if t.decided {
+		return text
+	}
+	t.pending.WriteString(text)
+	buf := t.pending.String()
+
+	// 暂存内容仍可能是上一轮结尾的一部分时继续等待
+	if strings.Contains(t.prev, buf) && utf8.RuneCountInString(buf) < utf8.RuneCountInString(t.prev) {
+		return ""
+	}
+	return t.decide()
+}
+
+// flush 本轮结束时返回仍在暂存的文本
+func (t *seamTrimmer) flush() string {
+	if t.decided {
+		return ""
+	}
+	return t.decide()
+}
+
+// decide 去除暂存内容中与上一轮结尾重叠的最长前缀
+func (t *seamTrimmer) decide() string {
+	t.decided = true
+	buf := t.pending.String()
+	t.pending.Reset()
+
+	runes := []rune(buf)
+	for k := min(len(runes), utf8.RuneCountInString(t.prev)); k >= seamMinOverlapRunes; k-- {
+		if strings.HasSuffix(t.prev, string(runes[:k])) {
+			return string(runes[k:])
+		}
+	}
+	return buf
+}
@@ -0,0 +1,120 @@
+package api_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/trae2api/config"
+	"github.com/trae2api/pkg/traefake"
+)
+
+// 自动继续时发送的用户消息，同时作为继续轮次的触发词
+const continuePrompt = "[continue]"
+
+// enableContinuation 对所有模型开启自动继续
+func enableContinuation(t *testing.T, maxRounds string, maxChars string) {
+	t.Helper()
+	setConfig(t, &config.AutoContinueEnabled, "true")
+	setConfig(t, &config.AutoContinueModels, "*")
+	setConfig(t, &config.AutoContinuePrompt, continuePrompt)
+	setConfig(t, &config.AutoContinueMaxRounds, maxRounds)
+	setConfig(t, &config.AutoContinueMaxChars, maxChars)
+}
+
+// chatResult 返回非流式或流式响应的正文与结束原因
+func chatResult(t *testing.T, w *httptest.ResponseRecorder, stream bool) (string, interface{}) {
+	t.Helper()
+	if w.Code != http.StatusOK {
+		t.Fatalf("状态码 %d, body: %s", w.Code, w.Body.String())
+	}
+	if stream {
+		content, _, finishReason := streamToolCalls(t, w.Body.String())
+		return content, finishReason
+	}
+	choice := decodeJSON(t, w)["choices"].([]interface{})[0].(map[string]interface{})
+	return choice["message"].(map[string]interface{})["content"].(string), choice["finish_reason"]
+}
+
+func TestContinuationSeamOverlap(t *testing.T) {
+	env := newTestEnv(t, traefake.Options{})
+	enableContinuation(t, "3", "0")
+	env.fake.SetDefault(traefake.Reply("", "The quick brown fox jumps", "length"))
+	// 继续输出的开头重复了上一轮结尾的 "fox jumps"
+	env.fake.Handle(continuePrompt, traefake.Reply("", "fox jumps over the lazy dog.", "stop"))
+
+	for _, stream := range []bool{false, true} {
+		before := len(env.fake.Requests())
+		w := env.chat(t, "gpt-4o", "hi", map[string]interface{}{"stream": stream})
+		content, finishReason := chatResult(t, w, stream)
+		if content != "The quick brown fox jumps over the lazy dog." {
+			t.Errorf("stream=%v: content = %q", stream, content)
+		}
+		if finishReason != "stop" {
+			t.Errorf("stream=%v: finish_reason = %v", stream, finishReason)
+		}
+		if n := len(env.fake.Requests()) - before; n != 2 {
+			t.Errorf("stream=%v: 上游请求 %d 次, 期望 2", stream, n)
+		}
+	}
+
+	// 继续请求带上上一轮的输出
+	if prompt := lastPrompt(t, env); !containsAll(prompt, "The quick brown fox jumps", continuePrompt) {
+		t.Errorf("继续请求的对话: %s", prompt)
+	}
+}
+
+func TestContinuationLimits(t *testing.T) {
+	tests := []struct {
+		name      string
+		maxRounds string
+		maxChars  string
+		// 期望的上游请求次数
+		wantRequests int
+		wantContent  string
+	}{
+		// 每轮都被截断，继续 2 轮后停止
+		{name: "轮数上限", maxRounds: "2", maxChars: "0", wantRequests: 3, wantContent: "First part. next. next."},
+		// 第一轮后正文已达到字符上限，不再继续
+		{name: "字符上限", maxRounds: "3", maxChars: "10", wantRequests: 1, wantContent: "First part."},
+		// 第二轮后正文累计 17 个字符，超过上限
+		{name: "字符上限内继续", maxRounds: "3", maxChars: "15", wantRequests: 2, wantContent: "First part. next."},
+		{name: "关闭继续", maxRounds: "0", maxChars: "0", wantRequests: 1, wantContent: "First part."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, traefake.Options{})
+			enableContinuation(t, tt.maxRounds, tt.maxChars)
+			env.fake.SetDefault(traefake.Reply("", "First part.", "length"))
+			// 继续的内容短于判定重复的最短长度，不会被当作与上一轮重叠而去除
+			env.fake.Handle(continuePrompt, traefake.Reply("", " next.", "length"))
+
+			for _, stream := range []bool{false, true} {
+				before := len(env.fake.Requests())
+				w := env.chat(t, "gpt-4o", "hi", map[string]interface{}{"stream": stream})
+				content, finishReason := chatResult(t, w, stream)
+				if content != tt.wantContent {
+					t.Errorf("stream=%v: content = %q, want %q", stream, content, tt.wantContent)
+				}
+				// 最后一轮仍被截断时以 length 结束
+				if finishReason != "length" {
+					t.Errorf("stream=%v: finish_reason = %v, want length", stream, finishReason)
+				}
+				if n := len(env.fake.Requests()) - before; n != tt.wantRequests {
+					t.Errorf("stream=%v: 上游请求 %d 次, 期望 %d", stream, n, tt.wantRequests)
+				}
+			}
+		})
+	}
+}
+
+// containsAll 判断 s 是否包含所有子串
+func containsAll(s string, subs ...string) bool {
+	for _, sub := range subs {
+		if !strings.Contains(s, sub) {
+			return false
+		}
+	}
+	return true
+}
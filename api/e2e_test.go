@@ -0,0 +1,128 @@
+package api_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/trae2api/api"
+	"github.com/trae2api/config"
+	"github.com/trae2api/pkg/logger"
+	"github.com/trae2api/pkg/traefake"
+)
+
+func TestMain(m *testing.M) {
+	logger.Init()
+	logger.Log.SetOutput(io.Discard)
+	gin.SetMode(gin.TestMode)
+	os.Exit(m.Run())
+}
+
+// testEnv 将代理的处理函数连接到 Trae 模拟服务
+type testEnv struct {
+	fake   *traefake.Server
+	server *httptest.Server
+	router *gin.Engine
+}
+
+// newTestEnv 启动模拟服务，并让代理的所有上游地址指向它
+func newTestEnv(t *testing.T, opts traefake.Options) *testEnv {
+	t.Helper()
+
+	fake := traefake.New(opts)
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	saved := config.AppConfig
+	config.AppConfig = config.Config{
+		BaseURL:         server.URL,
+		RefreshTokenURL: server.URL,
+		GetFileIDURL:    server.URL,
+		UploadFileURL:   server.URL,
+	}
+	t.Cleanup(func() { config.AppConfig = saved })
+	api.SetUpstream(api.NewTraeUpstream())
+	t.Cleanup(api.ResetModelCatalog)
+
+	r := gin.New()
+	r.Use(api.AuthMiddleware())
+	r.GET("/v1/models", api.GetModels)
+	r.GET("/v1/models/:id", api.GetModel)
+	r.POST("/v1/chat/completions", api.CreateChatCompletion)
+	r.POST("/v1/completions", api.CreateCompletion)
+	r.POST("/v1/responses", api.CreateResponse)
+	r.POST("/v1/messages", api.CreateMessage)
+
+	return &testEnv{fake: fake, server: server, router: r}
+}
+
+// setConfig 在测试期间修改配置项，测试结束后恢复
+func setConfig(t *testing.T, target *string, value string) {
+	t.Helper()
+	saved := *target
+	*target = value
+	t.Cleanup(func() { *target = saved })
+}
+
+// do 向代理发送请求，body 不是字符串时编码为 JSON
+func (e *testEnv) do(t *testing.T, method string, path string, body interface{}, headers ...string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var reader io.Reader
+	switch v := body.(type) {
+	case nil:
+	case string:
+		reader = bytes.NewBufferString(v)
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatalf("编码请求体失败: %v", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	e.router.ServeHTTP(w, req)
+	return w
+}
+
+// chat 发送只有一条用户消息的对话请求，extra 中的字段合并到请求体
+func (e *testEnv) chat(t *testing.T, model string, content interface{}, extra map[string]interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	body := map[string]interface{}{
+		"model":    model,
+		"messages": []interface{}{map[string]interface{}{"role": "user", "content": content}},
+	}
+	for k, v := range extra {
+		body[k] = v
+	}
+	return e.do(t, http.MethodPost, "/v1/chat/completions", body)
+}
+
+// decodeJSON 解析响应体
+func decodeJSON(t *testing.T, w *httptest.ResponseRecorder) map[string]interface{} {
+	t.Helper()
+	var v map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &v); err != nil {
+		t.Fatalf("解析响应失败: %v, body: %s", err, w.Body.String())
+	}
+	return v
+}
+
+// errorMessage 返回 OpenAI 格式错误响应中的 message
+func errorMessage(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	errObj, _ := decodeJSON(t, w)["error"].(map[string]interface{})
+	message, _ := errObj["message"].(string)
+	return message
+}
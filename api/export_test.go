@@ -0,0 +1,19 @@
+package api
+
+import "testing"
+
+// SyncModelCatalog 立即同步一次 Trae 模型目录
+func SyncModelCatalog() {
+	catalog.sync()
+}
+
+// ResetModelCatalog 清空模型目录缓存，恢复为尚未同步的状态
+func ResetModelCatalog() {
+	catalog = &modelCatalog{}
+}
+
+// CatalogChanges 在测试期间记录模型目录变化日志，返回读取已记录变化的函数
+func CatalogChanges(t *testing.T) func() []string {
+	hook := captureLogs(t)
+	return func() []string { return catalogChanges(hook) }
+}
@@ -1 +1,166 @@
 package api
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/trae2api/config"
+	"github.com/trae2api/pkg/logger"
+	"github.com/trae2api/pkg/upstream"
+)
+
+// 单张图片的最大字节数
+const maxImageBytes = 20 << 20
+
+// imageInputError 表示客户端提供的图片本身有问题
+type imageInputError struct {
+	msg string
+}
+
+func (e *imageInputError) Error() string {
+	return e.msg
+}
+
+// extractImageSources 提取消息内容中的图片地址
+//
+// 支持 OpenAI 的 image_url、Responses 的 input_image 以及 Anthropic 的 image 内容块，
+// 返回 http(s) 地址或 data URI。
+func extractImageSources(content interface{}) []string {
+	parts, ok := content.([]interface{})
+	if !ok {
+		return nil
+	}
+
+	var sources []string
+	for _, item := range parts {
+		part, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		switch part["type"] {
+		case "image_url":
+			switch v := part["image_url"].(type) {
+			case string:
+				sources = append(sources, v)
+			case map[string]interface{}:
+				if u, ok := v["url"].(string); ok {
+					sources = append(sources, u)
+				}
+			}
+		case "input_image":
+			if u, ok := part["image_url"].(string); ok {
+				sources = append(sources, u)
+			}
+		case "image":
+			source, _ := part["source"].(map[string]interface{})
+			switch source["type"] {
+			case "base64":
+				mediaType, _ := source["media_type"].(string)
+				data, _ := source["data"].(string)
+				sources = append(sources, "data:"+mediaType+";base64,"+data)
+			case "url":
+				if u, ok := source["url"].(string); ok {
+					sources = append(sources, u)
+				}
+			}
+		}
+	}
+	return sources
+}
+
+// attachImages 上传消息中的图片并返回 multi_media 列表
+//
+// 返回的状态码用于调用方按各自的协议格式返回错误。
+func attachImages(ctx context.Context, model string, content interface{}) ([]interface{}, int, error) {
+	sources := extractImageSources(content)
+	if len(sources) == 0 {
+		return nil, http.StatusOK, nil
+	}
+
+	if !isModelMultimodal(model) {
+		return nil, http.StatusBadRequest, fmt.Errorf("模型 %s 不支持图片输入", model)
+	}
+
+	media := make([]interface{}, 0, len(sources))
+	for _, src := range sources {
+		image, err := uploadImage(ctx, src)
+		if err != nil {
+			logger.Log.Errorf("图片上传失败: %v", err)
+			if _, ok := err.(*imageInputError); ok {
+				return nil, http.StatusBadRequest, err
+			}
+			return nil, http.StatusBadGateway, fmt.Errorf("图片上传失败: %v", err)
+		}
+		media = append(media, image)
+	}
+	return media, http.StatusOK, nil
+}
+
+// uploadImage 读取图片并通过上游上传
+func uploadImage(ctx context.Context, src string) (*upstream.MultiMediaImage, error) {
+	uploader, ok := traeUpstream.(upstream.ImageUploader)
+	if !ok {
+		return nil, fmt.Errorf("上游不支持图片上传")
+	}
+
+	data, err := loadImage(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+	return uploader.UploadImage(ctx, data)
+}
+
+// loadImage 读取 data URI 或下载 http(s) 图片
+func loadImage(ctx context.Context, src string) ([]byte, error) {
+	if strings.HasPrefix(src, "data:") {
+		idx := strings.Index(src, ",")
+		if idx < 0 || !strings.HasSuffix(src[:idx], ";base64") {
+			return nil, &imageInputError{msg: "仅支持 base64 编码的 data URI 图片"}
+		}
+		data, err := base64.StdEncoding.DecodeString(src[idx+1:])
+		if err != nil {
+			return nil, &imageInputError{msg: fmt.Sprintf("图片 base64 解码失败: %v", err)}
+		}
+		if len(data) > maxImageBytes {
+			return nil, &imageInputError{msg: "图片大小超过限制"}
+		}
+		return data, nil
+	}
+
+	if !strings.HasPrefix(src, "http://") && !strings.HasPrefix(src, "https://") {
+		return nil, &imageInputError{msg: "不支持的图片地址，仅支持 data URI 或 http(s) 地址"}
+	}
+	if config.ImageURLFetchEnabled != "true" {
+		return nil, &imageInputError{msg: "未开启图片地址下载，请使用 base64 编码的 data URI"}
+	}
+
+	// 下载失败的原因只记录在日志中，避免通过错误信息探测内网
+	req, err := http.NewRequestWithContext(ctx, "GET", src, nil)
+	if err != nil {
+		return nil, &imageInputError{msg: "图片地址无效"}
+	}
+	resp, err := imageHTTP.Do(req)
+	if err != nil {
+		logger.Log.Warnf("下载图片失败: %v", err)
+		return nil, &imageInputError{msg: "下载图片失败"}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		logger.Log.Warnf("下载图片失败，状态码: %d, url: %s", resp.StatusCode, src)
+		return nil, &imageInputError{msg: "下载图片失败"}
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxImageBytes+1))
+	if err != nil {
+		logger.Log.Warnf("下载图片失败: %v", err)
+		return nil, &imageInputError{msg: "下载图片失败"}
+	}
+	if len(data) > maxImageBytes {
+		return nil, &imageInputError{msg: "图片大小超过限制"}
+	}
+	return data, nil
+}
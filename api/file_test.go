@@ -0,0 +1,155 @@
+package api_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/trae2api/api"
+	"github.com/trae2api/config"
+	"github.com/trae2api/pkg/traefake"
+	"github.com/trae2api/pkg/upstream"
+)
+
+// testPNG 生成一张 w x h 的 PNG 图片
+func testPNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	img.Set(0, 0, color.RGBA{R: 255, A: 255})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("生成图片失败: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// imageContent 返回包含一段文字与一张图片的消息内容
+func imageContent(url string) []interface{} {
+	return []interface{}{
+		map[string]interface{}{"type": "text", "text": "describe"},
+		map[string]interface{}{"type": "image_url", "image_url": map[string]interface{}{"url": url}},
+	}
+}
+
+func TestImageUpload(t *testing.T) {
+	env := newTestEnv(t, traefake.Options{})
+	data := testPNG(t, 3, 2)
+
+	w := env.chat(t, "gpt-4o", imageContent("data:image/png;base64,"+base64.StdEncoding.EncodeToString(data)), nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("状态码 %d, body: %s", w.Code, w.Body.String())
+	}
+
+	uploads := env.fake.Uploads()
+	if len(uploads) != 1 {
+		t.Fatalf("上传次数 %d, 期望 1", len(uploads))
+	}
+	upload := uploads[0]
+	if !upload.Committed {
+		t.Errorf("图片未提交")
+	}
+	if !bytes.Equal(upload.Data, data) {
+		t.Errorf("上传内容与原图不一致")
+	}
+	if !strings.HasSuffix(upload.Scope, "/ap-singapore-1/imagex/aws4_request") {
+		t.Errorf("签名凭证范围 %q", upload.Scope)
+	}
+
+	requests := env.fake.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("对话请求次数 %d, 期望 1", len(requests))
+	}
+	want := []interface{}{map[string]interface{}{
+		"type":      "image",
+		"image_uri": "fake-image/" + upload.StoreURI,
+		"width":     float64(3),
+		"height":    float64(2),
+		"format":    "png",
+		"size":      float64(len(data)),
+	}}
+	if got := requests[0].MultiMedia; !reflect.DeepEqual(got, want) {
+		t.Errorf("multi_media\n got: %#v\nwant: %#v", got, want)
+	}
+}
+
+func TestImageUploadRejectsUnsupportedModel(t *testing.T) {
+	env := newTestEnv(t, traefake.Options{})
+	data := testPNG(t, 1, 1)
+
+	w := env.chat(t, "deepseek-v3", imageContent("data:image/png;base64,"+base64.StdEncoding.EncodeToString(data)), nil)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("状态码 %d, 期望 400, body: %s", w.Code, w.Body.String())
+	}
+	if len(env.fake.Uploads()) != 0 || len(env.fake.Requests()) != 0 {
+		t.Errorf("不支持图片的模型不应上传图片或请求上游")
+	}
+}
+
+// chatOnlyUpstream 不支持图片上传的上游
+type chatOnlyUpstream struct {
+	upstream.Upstream
+}
+
+func TestImageUploadRequiresUploader(t *testing.T) {
+	env := newTestEnv(t, traefake.Options{})
+	api.SetUpstream(chatOnlyUpstream{Upstream: api.NewTraeUpstream()})
+	data := testPNG(t, 1, 1)
+
+	w := env.chat(t, "gpt-4o", imageContent("data:image/png;base64,"+base64.StdEncoding.EncodeToString(data)), nil)
+	if w.Code != http.StatusBadGateway {
+		t.Fatalf("状态码 %d, 期望 502, body: %s", w.Code, w.Body.String())
+	}
+	if len(env.fake.Uploads()) != 0 || len(env.fake.Requests()) != 0 {
+		t.Errorf("上游不支持图片上传时不应上传图片或发起对话")
+	}
+}
+
+func TestImageURLFetch(t *testing.T) {
+	// 本地图片服务，下载开启时也因为是回环地址而被拒绝
+	data := testPNG(t, 1, 1)
+	images := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(data)
+	}))
+	defer images.Close()
+
+	tests := []struct {
+		name    string
+		enabled string
+		url     string
+		message string
+	}{
+		{name: "默认不下载图片地址", enabled: "false", url: images.URL + "/a.png", message: "未开启图片地址下载"},
+		{name: "拒绝回环地址", enabled: "true", url: images.URL + "/a.png", message: "下载图片失败"},
+		{name: "拒绝 localhost", enabled: "true", url: "http://localhost:1/a.png", message: "下载图片失败"},
+		{name: "不支持的协议", enabled: "true", url: "file:///etc/passwd", message: "不支持的图片地址"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, traefake.Options{})
+			setConfig(t, &config.ImageURLFetchEnabled, tt.enabled)
+
+			w := env.chat(t, "gpt-4o", imageContent(tt.url), nil)
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("状态码 %d, 期望 400, body: %s", w.Code, w.Body.String())
+			}
+			message := errorMessage(t, w)
+			if !strings.Contains(message, tt.message) {
+				t.Errorf("错误信息 %q, 期望包含 %q", message, tt.message)
+			}
+			if strings.Contains(message, "127.0.0.1") {
+				t.Errorf("错误信息不应包含目标地址: %q", message)
+			}
+			if len(env.fake.Uploads()) != 0 {
+				t.Errorf("不应上传图片")
+			}
+		})
+	}
+}
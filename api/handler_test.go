@@ -0,0 +1,575 @@
+package api_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/trae2api/config"
+	"github.com/trae2api/pkg/traefake"
+)
+
+// firstMessage 返回非流式响应中第一个候选的 message
+func firstMessage(t *testing.T, w *httptest.ResponseRecorder) map[string]interface{} {
+	t.Helper()
+	choices, _ := decodeJSON(t, w)["choices"].([]interface{})
+	if len(choices) == 0 {
+		t.Fatalf("响应中没有 choices: %s", w.Body.String())
+	}
+	return choices[0].(map[string]interface{})["message"].(map[string]interface{})
+}
+
+// streamContent 拼接流式响应中第一个候选的正文与推理内容
+func streamContent(t *testing.T, body string) (content string, reasoning string) {
+	t.Helper()
+	var c, r strings.Builder
+	for _, chunk := range chatChunks(t, body) {
+		choices, _ := chunk["choices"].([]interface{})
+		if len(choices) == 0 {
+			continue
+		}
+		delta, _ := choices[0].(map[string]interface{})["delta"].(map[string]interface{})
+		if s, ok := delta["content"].(string); ok {
+			c.WriteString(s)
+		}
+		if s, ok := delta["reasoning_content"].(string); ok {
+			r.WriteString(s)
+		}
+	}
+	return c.String(), r.String()
+}
+
+func TestChatCompletionReasoning(t *testing.T) {
+	env := newTestEnv(t, traefake.Options{})
+	env.fake.SetDefault(traefake.Reply("Think first.", "Then answer.", "stop"))
+
+	// 推理内容以 reasoning_content 字段返回
+	message := firstMessage(t, env.chat(t, "deepseek-r1", "hi", map[string]interface{}{"reasoning_format": "reasoning_content"}))
+	if message["reasoning_content"] != "Think first." || message["content"] != "Then answer." {
+		t.Errorf("reasoning_content 模式: reasoning %q, content %q", message["reasoning_content"], message["content"])
+	}
+
+	// 推理内容以 <think> 标签放在正文之前
+	message = firstMessage(t, env.chat(t, "deepseek-r1", "hi", map[string]interface{}{"reasoning_format": "think"}))
+	content, _ := message["content"].(string)
+	if !strings.HasPrefix(content, "<think>") || !strings.Contains(content, "Think first.") || !strings.HasSuffix(content, "Then answer.") {
+		t.Errorf("think 模式 content %q", content)
+	}
+	if _, ok := message["reasoning_content"]; ok {
+		t.Errorf("think 模式不应返回 reasoning_content")
+	}
+
+	// 流式响应中推理内容先于正文
+	w := env.chat(t, "deepseek-r1", "hi", map[string]interface{}{"stream": true, "reasoning_format": "reasoning_content"})
+	content, reasoning := streamContent(t, w.Body.String())
+	if reasoning != "Think first." || content != "Then answer." {
+		t.Errorf("流式: reasoning %q, content %q", reasoning, content)
+	}
+}
+
+func TestChatCompletionReasoningOnlyThink(t *testing.T) {
+	env := newTestEnv(t, traefake.Options{})
+	env.fake.SetDefault(traefake.Reply("Think first.", "", "length"))
+	const want = "<think>\n\nThink first.</think>"
+
+	// 只有推理内容时结束前关闭 <think> 标签
+	message := firstMessage(t, env.chat(t, "deepseek-r1", "hi", map[string]interface{}{"reasoning_format": "think"}))
+	if message["content"] != want {
+		t.Errorf("content %q, want %q", message["content"], want)
+	}
+
+	w := env.chat(t, "deepseek-r1", "hi", map[string]interface{}{"stream": true, "reasoning_format": "think"})
+	if content, _ := streamContent(t, w.Body.String()); content != want {
+		t.Errorf("流式 content %q, want %q", content, want)
+	}
+}
+
+func TestChatCompletionQueue(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		t.Run(fmt.Sprintf("stream=%v", stream), func(t *testing.T) {
+			env := newTestEnv(t, traefake.Options{})
+			setConfig(t, &config.QueueRetryInterval, "0")
+
+			// [fake:queue] 前两次请求只返回排队事件
+			w := env.chat(t, "gpt-4o", "[fake:queue] hi", map[string]interface{}{"stream": stream})
+			if w.Code != http.StatusOK {
+				t.Fatalf("状态码 %d, body: %s", w.Code, w.Body.String())
+			}
+			if got := w.Header().Get("X-Queue-Position"); got != "1" {
+				t.Errorf("X-Queue-Position = %q, want 1", got)
+			}
+			if n := len(env.fake.Requests()); n != 3 {
+				t.Errorf("上游请求次数 %d, 期望 3", n)
+			}
+
+			var content string
+			if stream {
+				// 排队期间不开始输出，排队位置只通过响应头返回
+				if strings.Contains(w.Body.String(), ": queue position=") {
+					t.Errorf("开始输出前不应写出排队注释: %s", w.Body.String())
+				}
+				if !strings.HasSuffix(w.Body.String(), "data: [DONE]\n\n") {
+					t.Errorf("流没有以 [DONE] 结束: %s", w.Body.String())
+				}
+				content, _ = streamContent(t, w.Body.String())
+			} else {
+				content, _ = firstMessage(t, w)["content"].(string)
+			}
+			if content != "Finally your turn." {
+				t.Errorf("content = %q", content)
+			}
+		})
+	}
+}
+
+func TestChatCompletionQueueTimeout(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval string
+		maxWait  string
+		n        int
+		// 期望的 Retry-After 与 X-Queue-Position 响应头
+		wantRetryAfter string
+		wantPosition   string
+	}{
+		// 重试次数用尽后上游只返回排队事件便结束
+		{name: "排队事件流结束", interval: "0", maxWait: "120", wantRetryAfter: "1", wantPosition: "1"},
+		// 第一次重试前就会超过最长等待时间，放弃时不再返回排队位置
+		{name: "超过最长等待时间", interval: "2", maxWait: "1", wantRetryAfter: "2"},
+		{name: "多个候选", interval: "0", maxWait: "120", n: 2, wantRetryAfter: "1", wantPosition: "1"},
+	}
+
+	for _, tt := range tests {
+		for _, stream := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s/stream=%v", tt.name, stream), func(t *testing.T) {
+				env := newTestEnv(t, traefake.Options{})
+				setConfig(t, &config.QueueRetryInterval, tt.interval)
+				setConfig(t, &config.QueueMaxWait, tt.maxWait)
+
+				extra := map[string]interface{}{"stream": stream}
+				if tt.n > 0 {
+					extra["n"] = tt.n
+				}
+				w := env.chat(t, "gpt-4o", "[fake:queue-forever] hi", extra)
+				if w.Code != http.StatusTooManyRequests {
+					t.Fatalf("状态码 %d, 期望 429, body: %s", w.Code, w.Body.String())
+				}
+				if got := w.Header().Get("Retry-After"); got != tt.wantRetryAfter {
+					t.Errorf("Retry-After = %q, want %s", got, tt.wantRetryAfter)
+				}
+				if got := w.Header().Get("X-Queue-Position"); got != tt.wantPosition {
+					t.Errorf("X-Queue-Position = %q, want %q", got, tt.wantPosition)
+				}
+				errObj, _ := decodeJSON(t, w)["error"].(map[string]interface{})
+				if errObj["type"] != "rate_limit_exceeded" {
+					t.Errorf("type = %v, want rate_limit_exceeded", errObj["type"])
+				}
+			})
+		}
+	}
+}
+
+func TestChatCompletionQueueMidStream(t *testing.T) {
+	env := newTestEnv(t, traefake.Options{})
+	setConfig(t, &config.QueueMaxWait, "1")
+
+	// [fake:queue-mid-stream] 输出部分正文后排队 10 秒
+	start := time.Now()
+	w := env.chat(t, "gpt-4o", "[fake:queue-mid-stream] hi", map[string]interface{}{"stream": true})
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("耗时 %v, 未按最长等待时间放弃", elapsed)
+	}
+	// 已开始输出，状态码不变，以错误消息结束
+	if w.Code != http.StatusOK {
+		t.Fatalf("状态码 %d, body: %s", w.Code, w.Body.String())
+	}
+	body := w.Body.String()
+	if !containsAll(body, `"Partial"`, `" answer"`, ": queue position=1") {
+		t.Errorf("缺少已输出的正文或排队注释: %s", body)
+	}
+	data := sseData(body)
+	if len(data) == 0 || !strings.Contains(data[len(data)-1], `"rate_limit_exceeded"`) || strings.Contains(body, "[DONE]") {
+		t.Errorf("流没有以排队超时错误结束: %s", body)
+	}
+	// 回复中途排队时不重新发起请求
+	if n := len(env.fake.Requests()); n != 1 {
+		t.Errorf("上游请求 %d 次, 期望 1", n)
+	}
+}
+
+func TestChatCompletionQueueMidStreamResumes(t *testing.T) {
+	env := newTestEnv(t, traefake.Options{})
+	setConfig(t, &config.QueueRetryInterval, "0")
+	env.fake.SetDefault(traefake.Script{Steps: []traefake.Step{
+		traefake.Output("Partial", "", ""),
+		traefake.Queue(2),
+		traefake.Queue(1),
+		traefake.Output(" answer.", "", ""),
+		traefake.Done("stop"),
+	}})
+
+	// 排队结束后继续输出，内容不重复
+	w := env.chat(t, "gpt-4o", "hi", map[string]interface{}{"stream": true})
+	content, _ := streamContent(t, w.Body.String())
+	if content != "Partial answer." || !strings.HasSuffix(w.Body.String(), "data: [DONE]\n\n") {
+		t.Errorf("content %q, body: %s", content, w.Body.String())
+	}
+	if n := len(env.fake.Requests()); n != 1 {
+		t.Errorf("上游请求 %d 次, 期望 1", n)
+	}
+}
+
+func TestChatCompletionQueueStalled(t *testing.T) {
+	env := newTestEnv(t, traefake.Options{})
+	setConfig(t, &config.QueueMaxRetries, "0")
+	setConfig(t, &config.QueueMaxWait, "1")
+	// 返回排队事件后长时间不再发送事件
+	late := traefake.Output("Too late.", "", "")
+	late.Delay = 5 * time.Second
+	env.fake.SetDefault(traefake.Script{Steps: []traefake.Step{traefake.Queue(1), late, traefake.Done("stop")}})
+
+	start := time.Now()
+	w := env.chat(t, "gpt-4o", "hi", nil)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("状态码 %d, 期望 429, body: %s", w.Code, w.Body.String())
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("耗时 %v, 未按最长等待时间放弃", elapsed)
+	}
+}
+
+func TestQueueTimeoutEndpoints(t *testing.T) {
+	messages := []interface{}{map[string]interface{}{"role": "user", "content": "[fake:queue-forever] hi"}}
+	tests := []struct {
+		path string
+		body map[string]interface{}
+	}{
+		{path: "/v1/completions", body: map[string]interface{}{"model": "gpt-4o", "prompt": "[fake:queue-forever] hi"}},
+		{path: "/v1/responses", body: map[string]interface{}{"model": "gpt-4o", "input": "[fake:queue-forever] hi"}},
+		{path: "/v1/messages", body: map[string]interface{}{"model": "gpt-4o", "max_tokens": 100, "messages": messages}},
+		{path: "/v1/chat/completions", body: map[string]interface{}{
+			"model": "gpt-4o", "messages": messages,
+			"response_format": map[string]interface{}{"type": "json_object"},
+		}},
+	}
+
+	for _, tt := range tests {
+		for _, stream := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s/stream=%v", tt.path, stream), func(t *testing.T) {
+				env := newTestEnv(t, traefake.Options{})
+				setConfig(t, &config.QueueRetryInterval, "0")
+
+				body := map[string]interface{}{"stream": stream}
+				for k, v := range tt.body {
+					body[k] = v
+				}
+				w := env.do(t, http.MethodPost, tt.path, body)
+				if w.Code != http.StatusTooManyRequests {
+					t.Fatalf("状态码 %d, 期望 429, body: %s", w.Code, w.Body.String())
+				}
+				if got := w.Header().Get("Retry-After"); got != "1" {
+					t.Errorf("Retry-After = %q, want 1", got)
+				}
+			})
+		}
+	}
+}
+
+func TestChatCompletionUpstreamError(t *testing.T) {
+	tests := []struct {
+		trigger    string
+		wantStatus int
+		wantType   string
+	}{
+		{trigger: "[fake:error-400]", wantStatus: http.StatusBadRequest, wantType: "invalid_request"},
+		{trigger: "[fake:error-401]", wantStatus: http.StatusUnauthorized, wantType: "unauthorized"},
+		{trigger: "[fake:error-429]", wantStatus: http.StatusTooManyRequests, wantType: "rate_limit_exceeded"},
+		{trigger: "[fake:error-500]", wantStatus: http.StatusInternalServerError, wantType: "internal_server_error"},
+		{trigger: "[fake:error-503]", wantStatus: http.StatusServiceUnavailable, wantType: "internal_server_error"},
+	}
+
+	for _, tt := range tests {
+		for _, stream := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s/stream=%v", tt.trigger, stream), func(t *testing.T) {
+				env := newTestEnv(t, traefake.Options{})
+
+				// 尚未开始输出，流式请求同样返回普通的错误响应
+				w := env.chat(t, "gpt-4o", tt.trigger+" hi", map[string]interface{}{"stream": stream})
+				if w.Code != tt.wantStatus {
+					t.Fatalf("状态码 %d, 期望 %d, body: %s", w.Code, tt.wantStatus, w.Body.String())
+				}
+				errObj, _ := decodeJSON(t, w)["error"].(map[string]interface{})
+				if errObj["type"] != tt.wantType {
+					t.Errorf("type = %v, want %s", errObj["type"], tt.wantType)
+				}
+				if message, _ := errObj["message"].(string); !strings.Contains(message, "远程服务返回错误") {
+					t.Errorf("message = %q", message)
+				}
+			})
+		}
+	}
+}
+
+func TestChatCompletionUpstreamDisconnect(t *testing.T) {
+	env := newTestEnv(t, traefake.Options{})
+
+	// 非流式：上游中断时返回错误，不返回不完整的回复
+	w := env.chat(t, "gpt-4o", "[fake:disconnect] hi", nil)
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("状态码 %d, 期望 500, body: %s", w.Code, w.Body.String())
+	}
+	if message := errorMessage(t, w); !strings.Contains(message, "读取响应出错") {
+		t.Errorf("错误信息 %q", message)
+	}
+
+	// 流式：已输出的内容保留，随后以 data 消息返回错误，不写出 [DONE]
+	w = env.chat(t, "gpt-4o", "[fake:disconnect] hi", map[string]interface{}{"stream": true})
+	body := w.Body.String()
+	if content, _ := streamContent(t, body); content != "This reply is cut off" {
+		t.Errorf("content = %q", content)
+	}
+	data := sseData(body)
+	if len(data) == 0 || !strings.HasPrefix(data[len(data)-1], `{"error":`) {
+		t.Errorf("流没有以错误结束: %s", body)
+	}
+	if strings.Contains(body, "[DONE]") {
+		t.Errorf("上游中断时不应写出 [DONE]: %s", body)
+	}
+
+	// 上游没有发送 done 事件但正常关闭连接时视为正常结束
+	w = env.chat(t, "gpt-4o", "[fake:no-done] hi", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("状态码 %d, body: %s", w.Code, w.Body.String())
+	}
+	choice := decodeJSON(t, w)["choices"].([]interface{})[0].(map[string]interface{})
+	if choice["finish_reason"] != "stop" || choice["message"].(map[string]interface{})["content"] != "The stream ends without done." {
+		t.Errorf("choice = %v", choice)
+	}
+}
+
+func TestChatCompletionCancel(t *testing.T) {
+	// 每个事件间隔 100ms，完整回复需要约 2 秒
+	slow := traefake.Reply("", strings.Repeat("word ", 20), "stop")
+
+	t.Run("客户端断开", func(t *testing.T) {
+		for _, stream := range []bool{false, true} {
+			env := newTestEnv(t, traefake.Options{StepInterval: 100 * time.Millisecond})
+			env.fake.SetDefault(slow)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+			defer cancel()
+			body := fmt.Sprintf(`{"model":"gpt-4o","stream":%v,"messages":[{"role":"user","content":"hi"}]}`, stream)
+			req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)).WithContext(ctx)
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			start := time.Now()
+			env.router.ServeHTTP(w, req)
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("stream=%v: 客户端断开后 %v 才返回", stream, elapsed)
+			}
+			if strings.Contains(w.Body.String(), "[DONE]") || strings.Contains(w.Body.String(), `"finish_reason":"stop"`) {
+				t.Errorf("stream=%v: 客户端断开后不应正常结束: %s", stream, w.Body.String())
+			}
+		}
+	})
+
+	t.Run("超过处理时间上限", func(t *testing.T) {
+		env := newTestEnv(t, traefake.Options{StepInterval: 100 * time.Millisecond})
+		env.fake.SetDefault(slow)
+		setConfig(t, &config.UpstreamRequestTimeout, "1")
+
+		w := env.chat(t, "gpt-4o", "hi", nil)
+		if w.Code != http.StatusGatewayTimeout {
+			t.Fatalf("状态码 %d, 期望 504, body: %s", w.Code, w.Body.String())
+		}
+
+		w = env.chat(t, "gpt-4o", "hi", map[string]interface{}{"stream": true})
+		data := sseData(w.Body.String())
+		if len(data) == 0 || !strings.Contains(data[len(data)-1], `"code":504`) {
+			t.Errorf("流没有以 504 错误结束: %s", w.Body.String())
+		}
+	})
+}
+
+// sseEvent 流式响应中带事件名的一条消息
+type sseEvent struct {
+	name string
+	data map[string]interface{}
+}
+
+// sseEvents 解析流式响应中带事件名的消息，忽略注释与没有事件名的消息
+func sseEvents(t *testing.T, body string) []sseEvent {
+	t.Helper()
+	var events []sseEvent
+	for _, block := range strings.Split(body, "\n\n") {
+		var ev sseEvent
+		var data string
+		for _, line := range strings.Split(block, "\n") {
+			if strings.HasPrefix(line, "event: ") {
+				ev.name = strings.TrimPrefix(line, "event: ")
+			} else if strings.HasPrefix(line, "data: ") {
+				data = strings.TrimPrefix(line, "data: ")
+			}
+		}
+		if ev.name == "" {
+			continue
+		}
+		if err := json.Unmarshal([]byte(data), &ev.data); err != nil {
+			t.Fatalf("解析事件 %s 失败: %v, data: %s", ev.name, err, data)
+		}
+		events = append(events, ev)
+	}
+	return events
+}
+
+// eventNames 返回事件名序列，连续重复的事件只保留一个
+func eventNames(events []sseEvent) []string {
+	var names []string
+	for _, ev := range events {
+		if len(names) == 0 || names[len(names)-1] != ev.name {
+			names = append(names, ev.name)
+		}
+	}
+	return names
+}
+
+// message 发送只有一条用户消息的 /v1/messages 请求，extra 中的字段合并到请求体
+func (e *testEnv) message(t *testing.T, content string, extra map[string]interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	body := map[string]interface{}{
+		"model":      "deepseek-r1",
+		"max_tokens": 1024,
+		"messages":   []interface{}{map[string]interface{}{"role": "user", "content": content}},
+	}
+	for k, v := range extra {
+		body[k] = v
+	}
+	return e.do(t, http.MethodPost, "/v1/messages", body)
+}
+
+func TestCreateMessage(t *testing.T) {
+	env := newTestEnv(t, traefake.Options{})
+	env.fake.SetDefault(traefake.Reply("Think first.", "Then answer.", "stop"))
+
+	// 非流式：推理内容以 thinking 块返回，位于 text 块之前
+	w := env.message(t, "hi", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("状态码 %d, body: %s", w.Code, w.Body.String())
+	}
+	resp := decodeJSON(t, w)
+	want := []interface{}{
+		map[string]interface{}{"type": "thinking", "thinking": "Think first.", "signature": ""},
+		map[string]interface{}{"type": "text", "text": "Then answer."},
+	}
+	if !reflect.DeepEqual(resp["content"], want) {
+		t.Errorf("content\n got: %#v\nwant: %#v", resp["content"], want)
+	}
+	if resp["stop_reason"] != "end_turn" || resp["stop_sequence"] != nil {
+		t.Errorf("stop_reason %v, stop_sequence %v", resp["stop_reason"], resp["stop_sequence"])
+	}
+
+	// 流式：事件顺序与 Anthropic 一致，每个内容块依次开始、增量输出、结束
+	w = env.message(t, "hi", map[string]interface{}{"stream": true})
+	events := sseEvents(t, w.Body.String())
+	wantNames := []string{
+		"message_start",
+		"content_block_start", "content_block_delta", "content_block_stop",
+		"content_block_start", "content_block_delta", "content_block_stop",
+		"message_delta", "message_stop",
+	}
+	if got := eventNames(events); !reflect.DeepEqual(got, wantNames) {
+		t.Fatalf("事件顺序\n got: %v\nwant: %v", got, wantNames)
+	}
+
+	var thinking, text strings.Builder
+	blockTypes := map[float64]string{}
+	for _, ev := range events {
+		switch ev.name {
+		case "content_block_start":
+			blockTypes[ev.data["index"].(float64)] = ev.data["content_block"].(map[string]interface{})["type"].(string)
+		case "content_block_delta":
+			delta := ev.data["delta"].(map[string]interface{})
+			if blockTypes[ev.data["index"].(float64)] != strings.TrimSuffix(delta["type"].(string), "_delta") {
+				t.Errorf("增量类型 %v 与内容块 %v 不一致", delta["type"], ev.data["index"])
+			}
+			if s, ok := delta["thinking"].(string); ok {
+				thinking.WriteString(s)
+			}
+			if s, ok := delta["text"].(string); ok {
+				text.WriteString(s)
+			}
+		case "message_delta":
+			if reason := ev.data["delta"].(map[string]interface{})["stop_reason"]; reason != "end_turn" {
+				t.Errorf("stop_reason = %v", reason)
+			}
+		}
+	}
+	if want := map[float64]string{0: "thinking", 1: "text"}; !reflect.DeepEqual(blockTypes, want) {
+		t.Errorf("内容块 %v, want %v", blockTypes, want)
+	}
+	if thinking.String() != "Think first." || text.String() != "Then answer." {
+		t.Errorf("thinking %q, text %q", thinking.String(), text.String())
+	}
+}
+
+func TestCreateMessageStopSequence(t *testing.T) {
+	env := newTestEnv(t, traefake.Options{})
+	// 回复开头即命中 stop 序列
+	env.fake.SetDefault(traefake.Reply("", "STOP and the rest.", "stop"))
+	extra := map[string]interface{}{"stop_sequences": []string{"STOP"}}
+
+	w := env.message(t, "hi", extra)
+	if w.Code != http.StatusOK {
+		t.Fatalf("状态码 %d, body: %s", w.Code, w.Body.String())
+	}
+	resp := decodeJSON(t, w)
+	if resp["stop_reason"] != "stop_sequence" || resp["stop_sequence"] != "STOP" {
+		t.Errorf("stop_reason %v, stop_sequence %v", resp["stop_reason"], resp["stop_sequence"])
+	}
+	if want := []interface{}{map[string]interface{}{"type": "text", "text": ""}}; !reflect.DeepEqual(resp["content"], want) {
+		t.Errorf("content = %#v", resp["content"])
+	}
+
+	extra["stream"] = true
+	w = env.message(t, "hi", extra)
+	events := sseEvents(t, w.Body.String())
+	if len(events) < 2 || events[len(events)-2].name != "message_delta" {
+		t.Fatalf("流没有以 message_delta 结束: %s", w.Body.String())
+	}
+	delta := events[len(events)-2].data["delta"].(map[string]interface{})
+	if delta["stop_reason"] != "stop_sequence" || delta["stop_sequence"] != "STOP" {
+		t.Errorf("delta = %v", delta)
+	}
+}
+
+func TestCreateMessageMaxTokens(t *testing.T) {
+	env := newTestEnv(t, traefake.Options{})
+
+	for _, maxTokens := range []interface{}{nil, 0, -1} {
+		body := map[string]interface{}{
+			"model":    "deepseek-r1",
+			"messages": []interface{}{map[string]interface{}{"role": "user", "content": "hi"}},
+		}
+		if maxTokens != nil {
+			body["max_tokens"] = maxTokens
+		}
+		w := env.do(t, http.MethodPost, "/v1/messages", body)
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("max_tokens=%v: 状态码 %d, 期望 400, body: %s", maxTokens, w.Code, w.Body.String())
+		}
+		errObj, _ := decodeJSON(t, w)["error"].(map[string]interface{})
+		if errObj["type"] != "invalid_request_error" {
+			t.Errorf("max_tokens=%v: type = %v", maxTokens, errObj["type"])
+		}
+	}
+	if len(env.fake.Requests()) != 0 {
+		t.Errorf("max_tokens 无效时不应请求上游")
+	}
+}
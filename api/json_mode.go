@@ -0,0 +1,226 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/trae2api/config"
+	"github.com/trae2api/pkg/jsonschema"
+	"github.com/trae2api/pkg/logger"
+	"github.com/trae2api/pkg/traesse"
+)
+
+// ResponseFormat OpenAI 的 response_format 参数
+type ResponseFormat struct {
+	Type       string            `json:"type"`
+	JSONSchema *JSONSchemaFormat `json:"json_schema,omitempty"`
+}
+
+// JSONSchemaFormat response_format 为 json_schema 时的结构定义
+type JSONSchemaFormat struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	Schema      interface{} `json:"schema,omitempty"`
+	Strict      bool        `json:"strict,omitempty"`
+}
+
+var (
+	thinkBlockPattern = regexp.MustCompile(`(?s)<think>.*?</think>`)
+	codeFencePattern  = regexp.MustCompile("(?s)```(?:json|JSON)?\\s*\\n?(.*?)```")
+)
+
+// enabled 是否需要输出 JSON
+func (f *ResponseFormat) enabled() bool {
+	return f != nil && (f.Type == "json_object" || f.Type == "json_schema")
+}
+
+// prompt 返回注入系统提示词的格式要求
+func (f *ResponseFormat) prompt() string {
+	var sb strings.Builder
+	sb.WriteString("# 输出格式\n只输出一个合法的 JSON")
+	if f.Type == "json_schema" && f.JSONSchema != nil && f.JSONSchema.Schema != nil {
+		schema, _ := json.Marshal(f.JSONSchema.Schema)
+		sb.WriteString("，且必须符合以下 JSON Schema")
+		if f.JSONSchema.Description != "" {
+			sb.WriteString("（" + f.JSONSchema.Description + "）")
+		}
+		sb.WriteString("：\n")
+		sb.Write(schema)
+		sb.WriteString("\n")
+	} else {
+		sb.WriteString(" 对象。")
+	}
+	sb.WriteString("不要输出任何解释说明、Markdown 代码块或 JSON 之外的内容。")
+	return sb.String()
+}
+
+// validate 从模型回复中提取 JSON 并校验，返回提取出的 JSON 文本
+func (f *ResponseFormat) validate(reply string) (string, error) {
+	raw, value, err := extractJSON(reply)
+	if err != nil {
+		return "", err
+	}
+
+	if f.Type == "json_schema" && f.JSONSchema != nil && f.JSONSchema.Schema != nil {
+		if err := jsonschema.Validate(f.JSONSchema.Schema, value); err != nil {
+			return "", fmt.Errorf("JSON 不符合 Schema: %v", err)
+		}
+	} else if _, ok := value.(map[string]interface{}); !ok {
+		return "", errors.New("输出必须是 JSON 对象")
+	}
+	return raw, nil
+}
+
+// extractJSON 去除 <think> 块与代码块标记后，提取回复中的第一个 JSON 值
+func extractJSON(reply string) (string, interface{}, error) {
+	text := thinkBlockPattern.ReplaceAllString(reply, "")
+	if m := codeFencePattern.FindStringSubmatch(text); m != nil {
+		text = m[1]
+	}
+
+	start := strings.IndexAny(text, "{[")
+	if start < 0 {
+		return "", nil, errors.New("回复中没有找到 JSON")
+	}
+
+	dec := json.NewDecoder(strings.NewReader(text[start:]))
+	var value interface{}
+	if err := dec.Decode(&value); err != nil {
+		return "", nil, fmt.Errorf("JSON 解析失败: %v", err)
+	}
+
+	raw := strings.TrimSpace(text[start : start+int(dec.InputOffset())])
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, []byte(raw)); err == nil {
+		raw = compact.String()
+	}
+	return raw, value, nil
+}
+
+// jsonModeMaxRetries 返回校验失败后重新请求的最大次数
+func jsonModeMaxRetries() int {
+	n, err := strconv.Atoi(config.JSONModeMaxRetries)
+	if err != nil || n < 0 {
+		return 2
+	}
+	return n
+}
+
+// bufferSink 收集一次完整回复，供结构化输出校验使用
+type bufferSink struct {
+	// queue 转发排队状态的 sink，排队状态不属于回复内容
+	queue        completionSink
+	content      strings.Builder
+	reasoning    strings.Builder
+	finishReason string
+	usage        tokenUsage
+	failed       bool
+	status       int
+	errType      string
+	message      string
+	// retryAfter 大于 0 表示因排队超时失败
+	retryAfter int
+}
+
+// Queue 结构化输出只返回校验后的结果，排队状态直接转发
+func (s *bufferSink) Queue(ev *traesse.QueueEvent) error {
+	if s.queue == nil {
+		return nil
+	}
+	return s.queue.Queue(ev)
+}
+
+func (s *bufferSink) Delta(d completionDelta) error {
+	s.content.WriteString(d.Content)
+	s.reasoning.WriteString(d.Reasoning)
+	return nil
+}
+
+func (s *bufferSink) Finish(finishReason string, usage tokenUsage) error {
+	s.finishReason = finishReason
+	s.usage = usage
+	return nil
+}
+
+func (s *bufferSink) Fail(status int, errType string, message string) {
+	s.failed = true
+	s.status = status
+	s.errType = errType
+	s.message = message
+}
+
+func (s *bufferSink) QueueTimeout(retryAfter int, message string) {
+	s.Fail(http.StatusTooManyRequests, "rate_limit_exceeded", message)
+	s.retryAfter = retryAfter
+}
+
+// runJSONPipeline 执行结构化输出请求
+//
+// 完整回复通过校验后才交给 sink，校验失败时携带错误原因重新请求上游，
+// 超过重试次数后返回错误。推理内容不会输出，避免破坏 JSON 结果。
+// 返回的用量包含所有重试请求，处理时间上限同样覆盖所有重试请求。
+func runJSONPipeline(c *gin.Context, chatReq ChatRequest, format *ResponseFormat, sink completionSink) {
+	ctx, cancel := withRequestDeadline(c.Request.Context())
+	defer cancel()
+
+	messages := append([]ChatMessage(nil), chatReq.Messages...)
+	maxRetries := jsonModeMaxRetries()
+	var usage tokenUsage
+
+	for attempt := 0; ; attempt++ {
+		roundReq := chatReq
+		roundReq.Messages = messages
+
+		buf := &bufferSink{queue: sink}
+		runChatPipelineContext(ctx, roundReq, buf)
+		if buf.failed && buf.retryAfter > 0 {
+			sink.QueueTimeout(buf.retryAfter, buf.message)
+			return
+		}
+		if buf.failed {
+			sink.Fail(buf.status, buf.errType, buf.message)
+			return
+		}
+		if buf.finishReason == "" {
+			// 管道未正常结束（如客户端已断开）
+			return
+		}
+		usage = usage.add(buf.usage)
+
+		reply := buf.content.String()
+		result, err := format.validate(reply)
+		if err == nil {
+			if err := sink.Delta(completionDelta{Content: result}); err != nil {
+				logger.Log.Errorf("写入增量内容失败: %v", err)
+				return
+			}
+			if err := sink.Finish(buf.finishReason, usage); err != nil {
+				logger.Log.Errorf("写入响应失败: %v", err)
+			}
+			return
+		}
+
+		if attempt >= maxRetries {
+			logger.Log.Errorf("结构化输出校验失败，已达到最大重试次数: %v", err)
+			sink.Fail(http.StatusBadGateway, "invalid_json",
+				fmt.Sprintf("模型输出未通过 JSON 校验: %v", err))
+			return
+		}
+
+		logger.Log.Infof("结构化输出校验失败，准备第 %d 次重试: %v", attempt+1, err)
+		messages = append(messages, ChatMessage{
+			Role:    "assistant",
+			Content: reply,
+		}, ChatMessage{
+			Role:    "user",
+			Content: fmt.Sprintf("上一次的输出不符合要求：%v。请重新输出，只输出符合要求的 JSON。", err),
+		})
+	}
+}
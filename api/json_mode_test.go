@@ -0,0 +1,117 @@
+package api_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/trae2api/config"
+	"github.com/trae2api/pkg/traefake"
+)
+
+// 校验失败后重新请求时用户消息的开头，用作重试请求的触发词
+const jsonRetryTrigger = "上一次的输出不符合要求"
+
+var countSchema = map[string]interface{}{
+	"type": "json_schema",
+	"json_schema": map[string]interface{}{
+		"name": "count",
+		"schema": map[string]interface{}{
+			"type":       "object",
+			"properties": map[string]interface{}{"count": map[string]interface{}{"type": "integer"}},
+			"required":   []string{"count"},
+		},
+	},
+}
+
+// jsonContent 返回非流式响应的正文
+func jsonContent(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	if w.Code != http.StatusOK {
+		t.Fatalf("状态码 %d, body: %s", w.Code, w.Body.String())
+	}
+	choice := decodeJSON(t, w)["choices"].([]interface{})[0].(map[string]interface{})
+	return choice["message"].(map[string]interface{})["content"].(string)
+}
+
+func TestJSONModeExtract(t *testing.T) {
+	env := newTestEnv(t, traefake.Options{})
+	env.fake.SetDefault(traefake.Reply("", "Sure! Here it is:\n```json\n{\n  \"count\": 3\n}\n```\nAnything else?", "stop"))
+
+	// 从代码块中提取 JSON 并压缩输出
+	for _, stream := range []bool{false, true} {
+		w := env.chat(t, "gpt-4o", "count", map[string]interface{}{"response_format": countSchema, "stream": stream})
+		content, finishReason := chatResult(t, w, stream)
+		if content != `{"count":3}` || finishReason != "stop" {
+			t.Errorf("stream=%v: content %q, finish_reason %v", stream, content, finishReason)
+		}
+	}
+	if n := len(env.fake.Requests()); n != 2 {
+		t.Errorf("上游请求 %d 次, 期望 2", n)
+	}
+}
+
+func TestJSONModeRetry(t *testing.T) {
+	env := newTestEnv(t, traefake.Options{})
+	// 第一次输出不符合 Schema，重试时输出正确的结果
+	env.fake.SetDefault(traefake.Reply("", `{"count": "three"}`, "stop"))
+	env.fake.Handle(jsonRetryTrigger, traefake.Reply("", `{"count": 3}`, "stop"))
+
+	w := env.chat(t, "gpt-4o", "count", map[string]interface{}{"response_format": countSchema})
+	if content := jsonContent(t, w); content != `{"count":3}` {
+		t.Errorf("content = %q", content)
+	}
+	requests := env.fake.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("上游请求 %d 次, 期望 2", len(requests))
+	}
+	// 重试请求带上上一次的输出与校验失败的原因
+	if prompt := lastPrompt(t, env); !containsAll(prompt, `{"count": "three"}`, jsonRetryTrigger, "Schema") {
+		t.Errorf("重试请求的对话: %s", prompt)
+	}
+}
+
+func TestJSONModeRetriesExhausted(t *testing.T) {
+	env := newTestEnv(t, traefake.Options{})
+	setConfig(t, &config.JSONModeMaxRetries, "1")
+	env.fake.SetDefault(traefake.Reply("", "I cannot answer in JSON.", "stop"))
+
+	w := env.chat(t, "gpt-4o", "count", map[string]interface{}{"response_format": countSchema})
+	if w.Code != http.StatusBadGateway {
+		t.Fatalf("状态码 %d, 期望 502, body: %s", w.Code, w.Body.String())
+	}
+	errObj := decodeJSON(t, w)["error"].(map[string]interface{})
+	if errObj["type"] != "invalid_json" {
+		t.Errorf("error.type = %v", errObj["type"])
+	}
+	// 第一次请求与 1 次重试
+	if n := len(env.fake.Requests()); n != 2 {
+		t.Errorf("上游请求 %d 次, 期望 2", n)
+	}
+
+	// 流式请求在校验通过前不开始输出，同样直接返回 502
+	w = env.chat(t, "gpt-4o", "count", map[string]interface{}{"response_format": countSchema, "stream": true})
+	if w.Code != http.StatusBadGateway || !strings.Contains(w.Body.String(), `"invalid_json"`) {
+		t.Errorf("流式请求: 状态码 %d, body: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestJSONModeSharedDeadline(t *testing.T) {
+	env := newTestEnv(t, traefake.Options{})
+	setConfig(t, &config.UpstreamRequestTimeout, "1")
+	setConfig(t, &config.JSONModeMaxRetries, "2")
+	// 每次请求耗时 0.6 秒，单次不超过处理时间上限，但第二次重试前总时间已超过
+	slow := traefake.Reply("", "not json", "stop")
+	slow.Steps[0].Delay = 600 * time.Millisecond
+	env.fake.SetDefault(slow)
+
+	w := env.chat(t, "gpt-4o", "count", map[string]interface{}{"response_format": countSchema})
+	if w.Code != http.StatusGatewayTimeout {
+		t.Fatalf("状态码 %d, 期望 504, body: %s", w.Code, w.Body.String())
+	}
+	if n := len(env.fake.Requests()); n != 2 {
+		t.Errorf("上游请求 %d 次, 期望 2", n)
+	}
+}
@@ -0,0 +1,430 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/trae2api/config"
+	"github.com/trae2api/pkg/logger"
+	"github.com/trae2api/pkg/traesse"
+)
+
+// AnthropicMessage Anthropic Messages API 的单条消息
+type AnthropicMessage struct {
+	Role    string      `json:"role"`
+	Content interface{} `json:"content"`
+}
+
+// AnthropicRequest Anthropic Messages API 请求体
+type AnthropicRequest struct {
+	Model         string             `json:"model"`
+	System        interface{}        `json:"system,omitempty"`
+	Messages      []AnthropicMessage `json:"messages"`
+	MaxTokens     int                `json:"max_tokens"`
+	StopSequences []string           `json:"stop_sequences,omitempty"`
+	Stream        bool               `json:"stream"`
+	Temperature   float64            `json:"temperature,omitempty"`
+}
+
+// CreateMessage 处理 Anthropic 格式的 /v1/messages 请求
+func CreateMessage(c *gin.Context) {
+	// 检查 RefreshToken 是否过期
+	if config.IsRefreshTokenExpired() {
+		writeAnthropicError(c, http.StatusUnauthorized, "authentication_error", "RefreshToken 已过期，请更新环境变量中的 REFRESH_TOKEN")
+		return
+	}
+
+	var anthropicReq AnthropicRequest
+	if err := c.BindJSON(&anthropicReq); err != nil {
+		logger.Log.Errorf("解析请求体失败: %v", err)
+		writeAnthropicError(c, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+
+	// 检查模型是否支持
+	if !isModelSupported(anthropicReq.Model) {
+		errMsg := fmt.Sprintf("不支持的模型: %s", anthropicReq.Model)
+		logger.Log.Errorf("%s", errMsg)
+		writeAnthropicError(c, http.StatusBadRequest, "invalid_request_error", errMsg)
+		return
+	}
+
+	if len(anthropicReq.Messages) == 0 {
+		writeAnthropicError(c, http.StatusBadRequest, "invalid_request_error", "messages 不能为空")
+		return
+	}
+
+	// Anthropic API 要求 max_tokens 为正整数
+	if anthropicReq.MaxTokens <= 0 {
+		writeAnthropicError(c, http.StatusBadRequest, "invalid_request_error", "max_tokens 必须为正整数")
+		return
+	}
+
+	// 上传最后一条消息中的图片
+	multiMedia, status, err := attachImages(c.Request.Context(), convertModelName(anthropicReq.Model),
+		anthropicReq.Messages[len(anthropicReq.Messages)-1].Content)
+	if err != nil {
+		writeAnthropicError(c, status, anthropicErrorType(status), err.Error())
+		return
+	}
+
+	stops, err := parseStopSequences("stop_sequences", anthropicReq.StopSequences, maxAnthropicStopSequences)
+	if err != nil {
+		writeAnthropicError(c, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+
+	chatReq := anthropicToChatRequest(anthropicReq, len(multiMedia) > 0)
+	chatReq.MultiMedia = multiMedia
+	chatReq.StopSequences = stops
+
+	var sink completionSink
+	if anthropicReq.Stream {
+		streamSink := newAnthropicStreamSink(c, anthropicReq.Model)
+		streamSink.inputTokens = countPromptTokens(chatReq.Model, chatReq.Messages)
+		sink = streamSink
+	} else {
+		sink = newAnthropicAggregateSink(c, anthropicReq.Model)
+	}
+	runChatPipeline(c, chatReq, sink)
+}
+
+// anthropicToChatRequest 将 Anthropic 请求转换为内部使用的 OpenAI 格式请求
+//
+// imagesAttached 表示最后一条消息中的图片已通过 multi_media 发送。
+func anthropicToChatRequest(req AnthropicRequest, imagesAttached bool) ChatRequest {
+	messages := make([]ChatMessage, 0, len(req.Messages)+1)
+
+	// 顶层 system 转换为第一条 system 消息
+	if system := flattenContent(req.System, false); system != "" {
+		messages = append(messages, ChatMessage{Role: "system", Content: system})
+	}
+
+	for i, msg := range req.Messages {
+		messages = append(messages, ChatMessage{
+			Role:    msg.Role,
+			Content: flattenContent(msg.Content, i == len(req.Messages)-1 && imagesAttached),
+		})
+	}
+
+	return ChatRequest{
+		Model:       convertModelName(req.Model),
+		Messages:    messages,
+		Stream:      req.Stream,
+		Temperature: req.Temperature,
+		MaxTokens:   req.MaxTokens,
+	}
+}
+
+// anthropicStopReason 将结束原因映射为 Anthropic 的 stop_reason，stopSequence 为命中的 stop 序列
+func anthropicStopReason(finishReason string, stopSequence string) string {
+	switch {
+	case stopSequence != "":
+		return "stop_sequence"
+	case finishReason == "length":
+		return "max_tokens"
+	default:
+		return "end_turn"
+	}
+}
+
+// anthropicStopSequence 返回 stop_sequence 字段的值，未命中时为 null
+func anthropicStopSequence(stopSequence string) interface{} {
+	if stopSequence == "" {
+		return nil
+	}
+	return stopSequence
+}
+
+// newAnthropicMessageID 生成 msg_ 开头的消息ID
+func newAnthropicMessageID() string {
+	return "msg_" + strings.ReplaceAll(uuid.New().String(), "-", "")
+}
+
+// writeAnthropicError 返回 Anthropic 风格的错误响应
+func writeAnthropicError(c *gin.Context, status int, errType string, message string) {
+	c.JSON(status, gin.H{
+		"type": "error",
+		"error": map[string]interface{}{
+			"type":    errType,
+			"message": message,
+		},
+	})
+}
+
+// anthropicErrorType 将内部错误类型映射为 Anthropic 错误类型
+func anthropicErrorType(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return "invalid_request_error"
+	case http.StatusUnauthorized:
+		return "authentication_error"
+	case http.StatusForbidden:
+		return "permission_error"
+	case http.StatusNotFound:
+		return "not_found_error"
+	case http.StatusTooManyRequests:
+		return "rate_limit_error"
+	case http.StatusServiceUnavailable:
+		return "overloaded_error"
+	default:
+		return "api_error"
+	}
+}
+
+// anthropicAggregateSink 收集全部内容后返回 message 对象
+type anthropicAggregateSink struct {
+	c            *gin.Context
+	model        string
+	thinking     strings.Builder
+	text         strings.Builder
+	stopSequence string
+}
+
+func newAnthropicAggregateSink(c *gin.Context, model string) *anthropicAggregateSink {
+	return &anthropicAggregateSink{c: c, model: model}
+}
+
+func (s *anthropicAggregateSink) Queue(ev *traesse.QueueEvent) error {
+	setQueueHeader(s.c, ev)
+	return nil
+}
+
+func (s *anthropicAggregateSink) StopSequence(sequence string) {
+	s.stopSequence = sequence
+}
+
+func (s *anthropicAggregateSink) Delta(d completionDelta) error {
+	s.thinking.WriteString(d.Reasoning)
+	s.text.WriteString(d.Content)
+	return nil
+}
+
+func (s *anthropicAggregateSink) Finish(finishReason string, usage tokenUsage) error {
+	// 回复开头即命中 stop 序列时内容为空，仍正常返回
+	if s.thinking.Len() == 0 && s.text.Len() == 0 && s.stopSequence == "" {
+		s.Fail(http.StatusInternalServerError, "api_error", "未收到远程服务的响应")
+		return nil
+	}
+
+	content := make([]map[string]interface{}, 0, 2)
+	if s.thinking.Len() > 0 {
+		content = append(content, map[string]interface{}{
+			"type":      "thinking",
+			"thinking":  s.thinking.String(),
+			"signature": "",
+		})
+	}
+	content = append(content, map[string]interface{}{
+		"type": "text",
+		"text": s.text.String(),
+	})
+
+	s.c.JSON(http.StatusOK, map[string]interface{}{
+		"id":            newAnthropicMessageID(),
+		"type":          "message",
+		"role":          "assistant",
+		"model":         s.model,
+		"content":       content,
+		"stop_reason":   anthropicStopReason(finishReason, s.stopSequence),
+		"stop_sequence": anthropicStopSequence(s.stopSequence),
+		"usage":         usage.anthropic(),
+	})
+	return nil
+}
+
+func (s *anthropicAggregateSink) Fail(status int, errType string, message string) {
+	writeAnthropicError(s.c, status, anthropicErrorType(status), message)
+}
+
+func (s *anthropicAggregateSink) QueueTimeout(retryAfter int, message string) {
+	writeQueueTimeout(s.c, retryAfter, message, s.Fail)
+}
+
+// anthropicStreamSink 以 Anthropic 流式事件的形式逐条写出内容
+type anthropicStreamSink struct {
+	c       *gin.Context
+	model   string
+	started bool
+
+	// 当前打开的内容块类型及序号
+	blockType  string
+	blockIndex int
+
+	// inputTokens message_start 中预先返回的输入 token 数
+	inputTokens int
+	// stopSequence 命中的 stop 序列
+	stopSequence string
+}
+
+func newAnthropicStreamSink(c *gin.Context, model string) *anthropicStreamSink {
+	return &anthropicStreamSink{c: c, model: model, blockIndex: -1}
+}
+
+// writeEvent 写出一条带事件名的 SSE 消息
+func (s *anthropicStreamSink) writeEvent(event string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if _, err := s.c.Writer.Write([]byte("event: " + event + "\ndata: " + string(data) + "\n\n")); err != nil {
+		return err
+	}
+	s.c.Writer.Flush()
+	return nil
+}
+
+// start 写出响应头与 message_start 事件
+func (s *anthropicStreamSink) start() error {
+	if s.started {
+		return nil
+	}
+	s.started = true
+	s.c.Header("Content-Type", "text/event-stream")
+	s.c.Header("Cache-Control", "no-cache")
+	s.c.Header("Connection", "keep-alive")
+
+	return s.writeEvent("message_start", map[string]interface{}{
+		"type": "message_start",
+		"message": map[string]interface{}{
+			"id":            newAnthropicMessageID(),
+			"type":          "message",
+			"role":          "assistant",
+			"model":         s.model,
+			"content":       []interface{}{},
+			"stop_reason":   nil,
+			"stop_sequence": nil,
+			"usage": map[string]interface{}{
+				"input_tokens":  s.inputTokens,
+				"output_tokens": 0,
+			},
+		},
+	})
+}
+
+// switchBlock 切换到指定类型的内容块，必要时关闭上一个块
+func (s *anthropicStreamSink) switchBlock(blockType string) error {
+	if s.blockType == blockType {
+		return nil
+	}
+	if err := s.closeBlock(); err != nil {
+		return err
+	}
+
+	s.blockIndex++
+	s.blockType = blockType
+	block := map[string]interface{}{"type": blockType}
+	if blockType == "thinking" {
+		block["thinking"] = ""
+	} else {
+		block["text"] = ""
+	}
+	return s.writeEvent("content_block_start", map[string]interface{}{
+		"type":          "content_block_start",
+		"index":         s.blockIndex,
+		"content_block": block,
+	})
+}
+
+// closeBlock 关闭当前内容块
+func (s *anthropicStreamSink) closeBlock() error {
+	if s.blockType == "" {
+		return nil
+	}
+	s.blockType = ""
+	return s.writeEvent("content_block_stop", map[string]interface{}{
+		"type":  "content_block_stop",
+		"index": s.blockIndex,
+	})
+}
+
+// Queue 排队期间不开始输出，已开始输出时以 SSE 注释返回排队位置，并发送 ping 事件保持连接
+func (s *anthropicStreamSink) Queue(ev *traesse.QueueEvent) error {
+	setQueueHeader(s.c, ev)
+	if !s.started {
+		return nil
+	}
+	if err := writeQueueComment(s.c, ev); err != nil {
+		return err
+	}
+	return s.writeEvent("ping", map[string]interface{}{"type": "ping"})
+}
+
+func (s *anthropicStreamSink) StopSequence(sequence string) {
+	s.stopSequence = sequence
+}
+
+func (s *anthropicStreamSink) Delta(d completionDelta) error {
+	if err := s.start(); err != nil {
+		return err
+	}
+
+	if d.Reasoning != "" {
+		if err := s.switchBlock("thinking"); err != nil {
+			return err
+		}
+		if err := s.writeEvent("content_block_delta", map[string]interface{}{
+			"type":  "content_block_delta",
+			"index": s.blockIndex,
+			"delta": map[string]interface{}{"type": "thinking_delta", "thinking": d.Reasoning},
+		}); err != nil {
+			return err
+		}
+	}
+
+	if d.Content != "" {
+		if err := s.switchBlock("text"); err != nil {
+			return err
+		}
+		if err := s.writeEvent("content_block_delta", map[string]interface{}{
+			"type":  "content_block_delta",
+			"index": s.blockIndex,
+			"delta": map[string]interface{}{"type": "text_delta", "text": d.Content},
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *anthropicStreamSink) Finish(finishReason string, usage tokenUsage) error {
+	if err := s.start(); err != nil {
+		return err
+	}
+	if err := s.closeBlock(); err != nil {
+		return err
+	}
+	if err := s.writeEvent("message_delta", map[string]interface{}{
+		"type": "message_delta",
+		"delta": map[string]interface{}{
+			"stop_reason":   anthropicStopReason(finishReason, s.stopSequence),
+			"stop_sequence": anthropicStopSequence(s.stopSequence),
+		},
+		"usage": usage.anthropic(),
+	}); err != nil {
+		return err
+	}
+	return s.writeEvent("message_stop", map[string]interface{}{"type": "message_stop"})
+}
+
+func (s *anthropicStreamSink) Fail(status int, errType string, message string) {
+	if !s.started {
+		writeAnthropicError(s.c, status, anthropicErrorType(status), message)
+		return
+	}
+	_ = s.writeEvent("error", map[string]interface{}{
+		"type": "error",
+		"error": map[string]interface{}{
+			"type":    anthropicErrorType(status),
+			"message": message,
+		},
+	})
+}
+
+func (s *anthropicStreamSink) QueueTimeout(retryAfter int, message string) {
+	writeQueueTimeout(s.c, retryAfter, message, s.Fail)
+}
@@ -13,13 +13,24 @@ import (
 // AuthMiddleware 验证请求的Authorization header
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// 如果未设置 AuthToken，则不启用鉴权
-		if config.AppConfig.AuthToken == "" {
+		authHeader := c.GetHeader("Authorization")
+		// Anthropic 客户端使用 x-api-key 传递密钥
+		if authHeader == "" {
+			authHeader = c.GetHeader("x-api-key")
+		}
+
+		// 支持 "Bearer <token>" 格式
+		token := strings.TrimPrefix(authHeader, "Bearer ")
+		token = strings.TrimSpace(token)
+
+		// 如果未设置 AuthToken 与 AuthTokens，则不启用鉴权
+		keys := apiKeys()
+		if len(keys) == 0 {
+			c.Set(apiKeyContextKey, token)
 			c.Next()
 			return
 		}
 
-		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
 			logger.Log.Error("Authorization is empty")
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header is required"})
@@ -27,17 +38,31 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		// 支持 "Bearer <token>" 格式
-		token := strings.TrimPrefix(authHeader, "Bearer ")
-		token = strings.TrimSpace(token)
-
-		if token != config.AppConfig.AuthToken {
+		if !keys[token] {
 			logger.Log.Error(fmt.Sprintf("Invalid authorization token:%s", token))
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid authorization token"})
 			c.Abort()
 			return
 		}
 
+		// 只有通过鉴权的 Key 才会用于查找按 Key 配置的选项
+		c.Set(apiKeyContextKey, token)
 		c.Next()
 	}
 }
+
+// DebugVarsEnabled 是否提供 /debug/vars：配置了鉴权（访问需要 API Key）或设置了 DEBUG_VARS=1
+func DebugVarsEnabled() bool {
+	return len(apiKeys()) > 0 || config.DebugVars == "1"
+}
+
+// apiKeys 返回允许访问的 API Key，包括 AUTH_TOKEN 与 AUTH_TOKENS 中配置的 Key
+func apiKeys() map[string]bool {
+	keys := make(map[string]bool)
+	for _, key := range append([]string{config.AppConfig.AuthToken}, strings.Split(config.AuthTokens, ",")...) {
+		if key = strings.TrimSpace(key); key != "" {
+			keys[key] = true
+		}
+	}
+	return keys
+}
@@ -0,0 +1,98 @@
+package api_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/trae2api/api"
+	"github.com/trae2api/config"
+	"github.com/trae2api/pkg/traefake"
+)
+
+func TestAuthMiddleware(t *testing.T) {
+	tests := []struct {
+		name       string
+		authToken  string
+		authTokens string
+		key        string
+		wantStatus int
+		// 鉴权通过时回复正文是否包含 <think> 标签
+		wantThink bool
+	}{
+		{name: "AUTH_TOKEN", authToken: "main", key: "main", wantStatus: http.StatusOK, wantThink: true},
+		{name: "缺少 Key", authToken: "main", key: "", wantStatus: http.StatusUnauthorized},
+		{name: "错误的 Key", authToken: "main", key: "wrong", wantStatus: http.StatusUnauthorized},
+		{name: "只在 REASONING_FORMAT_KEYS 中配置的 Key", authToken: "main", key: "other", wantStatus: http.StatusUnauthorized},
+		{name: "AUTH_TOKENS 中的 Key 使用按 Key 配置的输出方式", authToken: "main", authTokens: "extra, other", key: "other", wantStatus: http.StatusOK},
+		{name: "只配置 AUTH_TOKENS 时同样启用鉴权", authTokens: "other", key: "wrong", wantStatus: http.StatusUnauthorized},
+		{name: "未配置鉴权", key: "anything", wantStatus: http.StatusOK, wantThink: true},
+		// 未启用鉴权时任何 Key 都视为通过鉴权
+		{name: "未配置鉴权时按 Key 配置的输出方式", key: "other", wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, traefake.Options{})
+			env.fake.SetDefault(traefake.Reply("Thinking.", "Answer.", "stop"))
+			config.AppConfig.AuthToken = tt.authToken
+			setConfig(t, &config.AuthTokens, tt.authTokens)
+			setConfig(t, &config.ReasoningFormatKeys, "other:reasoning_content")
+			setConfig(t, &config.ReasoningFormat, "think")
+
+			body := map[string]interface{}{
+				"model":    "deepseek-r1",
+				"messages": []interface{}{map[string]interface{}{"role": "user", "content": "hi"}},
+			}
+			var headers []string
+			if tt.key != "" {
+				headers = []string{"Authorization", "Bearer " + tt.key}
+			}
+			w := env.do(t, http.MethodPost, "/v1/chat/completions", body, headers...)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("状态码 %d, 期望 %d, body: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if w.Code != http.StatusOK {
+				if len(env.fake.Requests()) != 0 {
+					t.Errorf("鉴权失败时不应请求上游")
+				}
+				return
+			}
+
+			message := decodeJSON(t, w)["choices"].([]interface{})[0].(map[string]interface{})["message"].(map[string]interface{})
+			content, _ := message["content"].(string)
+			if got := strings.Contains(content, "<think>"); got != tt.wantThink {
+				t.Errorf("正文 %q 包含 <think>: %v, 期望 %v", content, got, tt.wantThink)
+			}
+			if _, hasField := message["reasoning_content"]; hasField == tt.wantThink {
+				t.Errorf("reasoning_content 字段存在: %v, 期望 %v", hasField, !tt.wantThink)
+			}
+		})
+	}
+}
+
+func TestDebugVarsEnabled(t *testing.T) {
+	tests := []struct {
+		name       string
+		authToken  string
+		authTokens string
+		debugVars  string
+		want       bool
+	}{
+		{name: "未配置鉴权", want: false},
+		{name: "未配置鉴权时设置 DEBUG_VARS=1", debugVars: "1", want: true},
+		{name: "配置了 AUTH_TOKEN", authToken: "main", want: true},
+		{name: "只配置 AUTH_TOKENS", authTokens: "extra", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestEnv(t, traefake.Options{})
+			config.AppConfig.AuthToken = tt.authToken
+			setConfig(t, &config.AuthTokens, tt.authTokens)
+			setConfig(t, &config.DebugVars, tt.debugVars)
+			if got := api.DebugVarsEnabled(); got != tt.want {
+				t.Errorf("DebugVarsEnabled() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
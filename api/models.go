@@ -0,0 +1,79 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/trae2api/config"
+)
+
+// isModelSupported 检查模型是否在注册表中启用，且 Trae 模型目录中仍然提供
+func isModelSupported(model string) bool {
+	m, ok := config.Models().Lookup(model)
+	return ok && catalog.available(m.Upstream)
+}
+
+// convertModelName 将请求中的模型ID或别名转换为 Trae 模型名
+func convertModelName(model string) string {
+	if m, ok := config.Models().Lookup(model); ok {
+		return m.Upstream
+	}
+	return model
+}
+
+// isModelMultimodal 判断模型是否支持图片输入
+//
+// 优先使用 Trae 模型目录中的多模态标记，目录中没有该模型时使用注册表中的配置。
+func isModelMultimodal(model string) bool {
+	if traeModel, found, _ := catalog.lookup(model); found {
+		return traeModel.Multimodal
+	}
+	m, ok := config.Models().Lookup(model)
+	return ok && m.Capabilities.Multimodal
+}
+
+// newModelObject 根据注册表配置与 Trae 模型目录生成 OpenAI 格式的模型对象
+func newModelObject(m config.ModelEntry) Model {
+	model := Model{
+		ID:            m.ID,
+		Object:        "model",
+		Created:       m.Created,
+		OwnedBy:       "trae",
+		DisplayName:   m.DisplayName,
+		Multimodal:    m.Capabilities.Multimodal,
+		Reasoning:     m.Capabilities.Reasoning,
+		ContextLength: m.Capabilities.ContextLength,
+	}
+
+	traeModel, found, _ := catalog.lookup(m.Upstream)
+	if !found {
+		return model
+	}
+	if traeModel.DisplayName != "" {
+		model.DisplayName = traeModel.DisplayName
+	}
+	model.Multimodal = traeModel.Multimodal
+	model.IsDefault = traeModel.IsDefault
+	if traeModel.CustomConfig != "" {
+		var customConfig interface{}
+		if err := json.Unmarshal([]byte(traeModel.CustomConfig), &customConfig); err == nil {
+			model.CustomConfig = customConfig
+		} else {
+			model.CustomConfig = traeModel.CustomConfig
+		}
+	}
+	return model
+}
+
+// GetModel 获取单个模型的信息，支持使用别名查询
+func GetModel(c *gin.Context) {
+	id := c.Param("id")
+	m, ok := config.Models().Lookup(convertModelName(id))
+	if !ok || !catalog.available(m.Upstream) {
+		writeError(c, http.StatusNotFound, "invalid_request_error", fmt.Sprintf("模型不存在: %s", id))
+		return
+	}
+	c.JSON(http.StatusOK, newModelObject(*m))
+}
@@ -0,0 +1,139 @@
+package api_test
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/trae2api/api"
+	"github.com/trae2api/pkg/traefake"
+	"github.com/trae2api/pkg/upstream"
+)
+
+// modelIDs 返回模型列表响应中的模型ID，及 ID -> 模型对象
+func modelIDs(t *testing.T, body map[string]interface{}) ([]string, map[string]map[string]interface{}) {
+	t.Helper()
+	var ids []string
+	models := make(map[string]map[string]interface{})
+	for _, item := range body["data"].([]interface{}) {
+		model := item.(map[string]interface{})
+		id := model["id"].(string)
+		ids = append(ids, id)
+		models[id] = model
+	}
+	return ids, models
+}
+
+func TestGetModels(t *testing.T) {
+	env := newTestEnv(t, traefake.Options{})
+
+	// 模型目录尚未同步时列出注册表中全部启用的模型
+	w := env.do(t, http.MethodGet, "/v1/models", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("状态码 %d, body: %s", w.Code, w.Body.String())
+	}
+	ids, _ := modelIDs(t, decodeJSON(t, w))
+	want := []string{
+		"claude-3-5-sonnet", "claude-3-7-sonnet", "gpt-4o", "gpt-4.1", "deepseek-v3",
+		"deepseek-v3-0324", "deepseek-r1", "gemini-2.5-pro", "gemini-2.5-flash",
+	}
+	if !reflect.DeepEqual(ids, want) {
+		t.Errorf("未同步时的模型列表\n got: %v\nwant: %v", ids, want)
+	}
+
+	// 同步后只列出 Trae 仍然提供的模型，并带上 Trae 的模型配置
+	env.fake.SetModels([]upstream.ModelConfig{
+		{Name: "gpt-4o", DisplayName: "GPT-4o (Trae)", Multimodal: true, CustomConfig: `{"max_tokens":4096}`},
+		{Name: "deepseek-R1", DisplayName: "DeepSeek-R1", IsDefault: true},
+	})
+	api.SyncModelCatalog()
+
+	w = env.do(t, http.MethodGet, "/v1/models", nil)
+	ids, models := modelIDs(t, decodeJSON(t, w))
+	if want := []string{"gpt-4o", "deepseek-r1"}; !reflect.DeepEqual(ids, want) {
+		t.Fatalf("同步后的模型列表\n got: %v\nwant: %v", ids, want)
+	}
+	gpt := models["gpt-4o"]
+	if gpt["display_name"] != "GPT-4o (Trae)" {
+		t.Errorf("display_name = %v", gpt["display_name"])
+	}
+	if want := map[string]interface{}{"max_tokens": float64(4096)}; !reflect.DeepEqual(gpt["custom_config"], want) {
+		t.Errorf("custom_config = %#v, want %#v", gpt["custom_config"], want)
+	}
+	if gpt["is_default"] != false || models["deepseek-r1"]["is_default"] != true {
+		t.Errorf("is_default: gpt-4o %v, deepseek-r1 %v", gpt["is_default"], models["deepseek-r1"]["is_default"])
+	}
+}
+
+func TestGetModel(t *testing.T) {
+	env := newTestEnv(t, traefake.Options{})
+	env.fake.SetModels([]upstream.ModelConfig{{Name: "deepseek-R1", DisplayName: "DeepSeek-R1"}})
+	api.SyncModelCatalog()
+
+	tests := []struct {
+		name       string
+		id         string
+		wantStatus int
+		wantID     string
+	}{
+		{name: "模型ID", id: "deepseek-r1", wantStatus: http.StatusOK, wantID: "deepseek-r1"},
+		{name: "别名", id: "deepseek-reasoner", wantStatus: http.StatusOK, wantID: "deepseek-r1"},
+		{name: "未知模型", id: "no-such-model", wantStatus: http.StatusNotFound},
+		{name: "Trae 不再提供的模型", id: "gpt-4o", wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := env.do(t, http.MethodGet, "/v1/models/"+tt.id, nil)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("状态码 %d, 期望 %d, body: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if tt.wantStatus == http.StatusOK {
+				if id := decodeJSON(t, w)["id"]; id != tt.wantID {
+					t.Errorf("id = %v, want %s", id, tt.wantID)
+				}
+			}
+		})
+	}
+
+	// Trae 不再提供的模型同样不能用于对话
+	w := env.chat(t, "gpt-4o", "hi", nil)
+	if w.Code == http.StatusOK {
+		t.Errorf("Trae 不再提供的模型对话请求成功: %s", w.Body.String())
+	}
+	if len(env.fake.Requests()) != 0 {
+		t.Errorf("不应请求上游")
+	}
+}
+
+func TestModelCatalogSyncChanges(t *testing.T) {
+	env := newTestEnv(t, traefake.Options{})
+	changes := api.CatalogChanges(t)
+
+	// 首次同步只记录模型数量，不记录变化
+	env.fake.SetModels([]upstream.ModelConfig{
+		{Name: "gpt-4o", Multimodal: true},
+		{Name: "deepseek-R1"},
+		{Name: "deepseek-V3"},
+	})
+	api.SyncModelCatalog()
+	if got := changes(); len(got) != 0 {
+		t.Fatalf("首次同步记录了变化: %v", got)
+	}
+
+	env.fake.SetModels([]upstream.ModelConfig{
+		{Name: "gpt-4o"},
+		{Name: "deepseek-R1"},
+		{Name: "gemini_2.5_flash", Multimodal: true},
+	})
+	api.SyncModelCatalog()
+	want := []string{"added gemini_2.5_flash", "multimodal_changed gpt-4o", "removed deepseek-V3"}
+	if got := changes(); !reflect.DeepEqual(got, want) {
+		t.Errorf("变化\n got: %v\nwant: %v", got, want)
+	}
+
+	// 同步后的目录立即生效
+	w := env.do(t, http.MethodGet, "/v1/models", nil)
+	if ids, _ := modelIDs(t, decodeJSON(t, w)); !reflect.DeepEqual(ids, []string{"gpt-4o", "deepseek-r1", "gemini-2.5-flash"}) {
+		t.Errorf("模型列表 %v", ids)
+	}
+}
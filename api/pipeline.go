@@ -0,0 +1,443 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/trae2api/pkg/logger"
+	"github.com/trae2api/pkg/traesse"
+	"github.com/trae2api/pkg/upstream"
+)
+
+// completionDelta 上游 output 事件归一化后的增量内容
+type completionDelta struct {
+	Content   string
+	Reasoning string
+}
+
+// completionSink 接收对话管道输出的事件并写回客户端
+//
+// 非流式响应由聚合型 sink 收集后一次性返回，流式响应由流式 sink 逐条写出。
+type completionSink interface {
+	// Queue 请求在上游排队，排队位置不属于回复内容
+	Queue(ev *traesse.QueueEvent) error
+	// Delta 收到一段增量内容
+	Delta(d completionDelta) error
+	// Finish 对话结束，usage 为本次响应的 token 用量
+	Finish(finishReason string, usage tokenUsage) error
+	// Fail 对话出错
+	Fail(status int, errType string, message string)
+	// QueueTimeout 排队超时，以 429 结束响应，retryAfter 为建议的重试等待秒数
+	QueueTimeout(retryAfter int, message string)
+}
+
+// stopSequenceSink 需要知道命中了哪个 stop 序列的 sink，在 Finish 之前调用
+type stopSequenceSink interface {
+	StopSequence(sequence string)
+}
+
+// roundResult 单次上游请求的结果
+type roundResult struct {
+	// content 本轮上游输出的正文，自动继续时作为 assistant 消息发送
+	content string
+	// emitted 经过 stop 检测后实际交给 sink 的正文，不含 stop 序列之后与仍在暂存的文本
+	emitted      string
+	reasoning    string
+	finishReason string
+	// 是否因命中 stop 序列或达到 token 上限而提前结束
+	stopped bool
+	// 是否因客户端断开或超过处理时间上限而取消，此时 content 与 reasoning 为已生成的部分
+	cancelled bool
+}
+
+// runChatPipeline 执行一次完整的对话请求，并将结果交给 sink
+//
+// 排队重试与自动继续均在管道内部完成，对 sink 而言始终只有一次响应。
+// 自动继续产生的每轮请求都计入用量。stop 序列的检测与 token 上限跨越所有轮次。
+// 所有上游请求都绑定客户端请求，客户端断开或超过处理时间上限时立即关闭上游连接。
+func runChatPipeline(c *gin.Context, chatReq ChatRequest, sink completionSink) {
+	ctx, cancel := withRequestDeadline(c.Request.Context())
+	defer cancel()
+	runChatPipelineContext(ctx, chatReq, sink)
+}
+
+// runChatPipelineContext 与 runChatPipeline 相同，ctx 已带有处理时间上限，
+// 供需要多次执行管道的调用方共用同一个上限
+func runChatPipelineContext(ctx context.Context, chatReq ChatRequest, sink completionSink) {
+	started := time.Now()
+
+	messages := append([]ChatMessage(nil), chatReq.Messages...)
+	stop := newStopScanner(chatReq.StopSequences)
+	budget := newTokenBudget(chatReq.Model, chatReq.completionTokenLimit())
+	cont := newContinuation(chatReq.Model)
+	var usage tokenUsage
+	var seam *seamTrimmer
+	var output strings.Builder
+
+	for round := 0; ; round++ {
+		roundReq := chatReq
+		roundReq.Messages = messages
+		traeReq, err := buildTraeRequest(roundReq)
+		if err != nil {
+			sink.Fail(http.StatusInternalServerError, "api_error", fmt.Sprintf("JSON编码失败: %v", err))
+			return
+		}
+
+		result, ok := runChatRound(ctx, traeReq, sink, stop, budget, seam)
+		usage = usage.add(countCompletionTokens(chatReq.Model, result.emitted, result.reasoning))
+		if result.cancelled {
+			abortChat(ctx, sink, chatReq.Model, round+1, time.Since(started), usage)
+			return
+		}
+		if !ok {
+			return
+		}
+
+		usage.PromptTokens += countPromptTokens(chatReq.Model, messages)
+		output.WriteString(result.content)
+
+		logger.Log.WithFields(logrus.Fields{
+			"lastFinishReason": result.finishReason,
+			"model":            chatReq.Model,
+			"round":            round,
+			"fullResponse":     len(result.content),
+			"hasFinishReason":  result.finishReason != "",
+		}).Info("本轮请求结束")
+		result.finishReason = normalizeFinishReason(result.finishReason)
+
+		// 因上游长度限制截断时自动继续
+		if !result.stopped && result.finishReason == "length" && cont.allow(round, utf8.RuneCountInString(output.String())) {
+			logger.Log.Infof("触发自动继续条件，准备发起第 %d 轮继续请求", round+1)
+
+			messages = append(messages, ChatMessage{
+				Role:    "assistant",
+				Content: result.content,
+			}, ChatMessage{
+				Role:    "user",
+				Content: cont.prompt,
+			})
+			seam = newSeamTrimmer(output.String())
+			continue
+		}
+
+		// 输出因可能构成 stop 序列而暂存的文本
+		if pending := stop.flush(); pending != "" {
+			usage = usage.add(countCompletionTokens(chatReq.Model, pending, ""))
+			if err := sink.Delta(completionDelta{Content: pending}); err != nil {
+				logger.Log.Errorf("写入增量内容失败: %v", err)
+				return
+			}
+		}
+		if s, ok := sink.(stopSequenceSink); ok && stop.matched {
+			s.StopSequence(stop.sequence)
+		}
+		if err := sink.Finish(result.finishReason, usage); err != nil {
+			logger.Log.Errorf("写入响应失败: %v", err)
+		}
+		return
+	}
+}
+
+// runChatRound 发送一次上游请求并消费其事件流，返回 false 表示已通过 sink 结束响应
+//
+// 自动继续的轮次先去除与上一轮结尾重复的正文，输出再按 token 上限截断，
+// 正文经过 stop 检测后交给 sink。命中 stop 序列或达到上限时立即返回并关闭上游连接。
+// ctx 结束时不写回 sink，返回 cancelled 为 true 的结果，由调用方结束响应。
+// 是否放弃排队在把排队事件交给 sink 之前判断，事件流只返回排队事件便结束时视为排队超时。
+func runChatRound(ctx context.Context, traeReq *upstream.ChatRequest, sink completionSink, stop *stopScanner, budget *tokenBudget, seam *seamTrimmer) (roundResult, bool) {
+	var result roundResult
+	var content, emitted, reasoning strings.Builder
+
+	// collect 记录本轮已收到的内容
+	collect := func() {
+		result.content = content.String()
+		result.emitted = emitted.String()
+		result.reasoning = reasoning.String()
+	}
+
+	// cancelled 客户端断开或超过处理时间上限
+	cancelled := func() (roundResult, bool) {
+		collect()
+		result.cancelled = true
+		return result, false
+	}
+
+	reader, err := openStream(ctx, traeReq)
+	if err != nil {
+		if ctx.Err() != nil {
+			return cancelled()
+		}
+		failWithUpstreamError(sink, err)
+		return result, false
+	}
+	defer func() {
+		reader.Close()
+	}()
+
+	queue := newQueuePolicy()
+	queueRetries := 0
+	var queuedSince time.Time
+	// 当前事件流是否只返回了排队事件
+	onlyQueued := false
+	// 本轮是否已收到上游输出，之后再排队时重新发起请求会重复输出
+	outputStarted := false
+	// 排队超过最长等待时间时触发，上游在排队期间不再发送事件时同样生效
+	var queueTimer *time.Timer
+	var queueDeadline <-chan time.Time
+	stopQueueTimer := func() {
+		if queueTimer != nil {
+			queueTimer.Stop()
+		}
+		queueTimer, queueDeadline = nil, nil
+	}
+	defer stopQueueTimer()
+
+	// emit 处理一段增量输出，ended 为 true 表示因命中 stop 序列或达到上限而提前结束
+	emit := func(response string, reasoningContent string) (ended bool, ok bool) {
+		// 按 token 上限截断，推理内容先于正文计入
+		reasoningText, reasoningExceeded := budget.take(reasoningContent)
+		response, responseExceeded := budget.take(response)
+		if reasoningExceeded {
+			response, responseExceeded = "", true
+		}
+		content.WriteString(response)
+		reasoning.WriteString(reasoningText)
+
+		// 只有通过 stop 检测的文本才会输出并计入用量
+		text, matched := stop.push(response)
+		emitted.WriteString(text)
+		if text != "" || reasoningText != "" {
+			if err := sink.Delta(completionDelta{
+				Content:   text,
+				Reasoning: reasoningText,
+			}); err != nil {
+				logger.Log.Errorf("写入增量内容失败: %v", err)
+				return true, false
+			}
+		}
+
+		if responseExceeded && !matched {
+			logger.Log.Infof("输出达到 %d token 上限，提前关闭上游连接", budget.limit)
+			result.finishReason = "length"
+		} else if matched {
+			logger.Log.Info("命中 stop 序列，提前关闭上游连接")
+			result.finishReason = "stop"
+		} else {
+			return false, true
+		}
+		collect()
+		result.stopped = true
+		return true, true
+	}
+
+	// finish 输出衔接处暂存的文本后结束本轮
+	finish := func() (roundResult, bool) {
+		if onlyQueued {
+			logger.Log.Warn("上游事件流只返回了排队状态便结束，放弃请求")
+			failQueueTimeout(sink, time.Since(queuedSince), queue.delay(queueRetries))
+			return result, false
+		}
+		if seam != nil {
+			if ended, ok := emit(seam.flush(), ""); ended || !ok {
+				return result, ok
+			}
+		}
+		collect()
+		return result, true
+	}
+
+	for {
+		// 等待上游事件的同时响应取消
+		var item streamItem
+		var open bool
+		select {
+		case <-ctx.Done():
+			return cancelled()
+		case <-queueDeadline:
+			logger.Log.Warnf("上游排队超过 %v，放弃请求", queue.maxWait)
+			failQueueTimeout(sink, time.Since(queuedSince), queue.delay(queueRetries))
+			return result, false
+		case item, open = <-reader.items:
+		}
+
+		ev, msg, err := item.ev, item.msg, item.err
+		if !open || err == io.EOF {
+			break
+		}
+		if err != nil && msg == nil {
+			if ctx.Err() != nil {
+				return cancelled()
+			}
+			sink.Fail(http.StatusInternalServerError, "api_error", fmt.Sprintf("读取响应出错: %v", err))
+			return result, false
+		}
+		if err != nil {
+			logger.Log.Errorf("解析事件数据失败: %v, data: %s", err, msg.Data)
+			continue
+		}
+
+		switch data := ev.(type) {
+		case *traesse.QueueEvent:
+			if queuedSince.IsZero() {
+				queuedSince = time.Now()
+				if queue.maxWait > 0 {
+					queueTimer = time.NewTimer(queue.maxWait)
+					queueDeadline = queueTimer.C
+				}
+			}
+			onlyQueued = true
+
+			// 先判断是否放弃，再把排队事件交给 sink
+			waited := time.Since(queuedSince)
+			if outputStarted || queueRetries >= queue.maxRetries {
+				// 已开始输出或重试次数用尽后继续等待当前请求，超过最长等待时间则放弃
+				if queue.exceeded(waited, 0) {
+					logger.Log.Warnf("上游排队超过 %v，放弃请求", queue.maxWait)
+					failQueueTimeout(sink, waited, queue.delay(queueRetries))
+					return result, false
+				}
+				if err := sink.Queue(data); err != nil {
+					logger.Log.Errorf("写入排队信息失败: %v", err)
+					return result, false
+				}
+				continue
+			}
+
+			delay := queue.delay(queueRetries)
+			if queue.exceeded(waited, delay) {
+				logger.Log.Warnf("上游排队超过 %v，放弃请求", queue.maxWait)
+				failQueueTimeout(sink, waited, delay)
+				return result, false
+			}
+			if err := sink.Queue(data); err != nil {
+				logger.Log.Errorf("写入排队信息失败: %v", err)
+				return result, false
+			}
+
+			// 未达到最大重试次数，等待后重新发送请求
+			queueRetries++
+			logger.Log.Infof("检测到排队状态（位置 %d），%v 后进行第 %d 次重试", data.Position, delay, queueRetries)
+			reader.Close()
+			select {
+			case <-ctx.Done():
+				return cancelled()
+			case <-time.After(delay):
+			}
+
+			newReader, err := openStream(ctx, traeReq)
+			if err != nil {
+				if ctx.Err() != nil {
+					return cancelled()
+				}
+				failWithUpstreamError(sink, err)
+				return result, false
+			}
+
+			// 替换当前事件流
+			reader = newReader
+			onlyQueued = false
+
+		case *traesse.OutputEvent:
+			onlyQueued = false
+			outputStarted = true
+			// 排队结束，之后再排队时重新计算等待时间
+			queuedSince = time.Time{}
+			stopQueueTimer()
+			// 记录最后的结束原因
+			if data.FinishReason != "" {
+				result.finishReason = data.FinishReason
+				logger.Log.WithFields(logrus.Fields{
+					"finishReason": result.finishReason,
+					"event":        "finish_reason_update",
+				}).Debug("更新结束原因")
+			}
+
+			if data.Response == "" && data.ReasoningContent == "" {
+				continue
+			}
+
+			response := data.Response
+			if seam != nil {
+				response = seam.push(response)
+			}
+			if ended, ok := emit(response, data.ReasoningContent); ended || !ok {
+				return result, ok
+			}
+
+		case *traesse.DoneEvent:
+			if data.FinishReason != "" {
+				result.finishReason = data.FinishReason
+				logger.Log.WithFields(logrus.Fields{
+					"finishReason": result.finishReason,
+					"event":        "done",
+				}).Info("从done事件更新finish_reason")
+			}
+			return finish()
+		}
+	}
+
+	// 上游未发送 done 事件便关闭了连接
+	return finish()
+}
+
+// finishReasons Trae 结束原因与 OpenAI finish_reason 的对应关系
+var finishReasons = map[string]string{
+	"stop":           "stop",
+	"end_turn":       "stop",
+	"stop_sequence":  "stop",
+	"eos":            "stop",
+	"length":         "length",
+	"max_tokens":     "length",
+	"tool_calls":     "tool_calls",
+	"tool_use":       "tool_calls",
+	"function_call":  "tool_calls",
+	"content_filter": "content_filter",
+	"sensitive":      "content_filter",
+	"safety":         "content_filter",
+}
+
+// normalizeFinishReason 将上游结束原因映射为 OpenAI 取值，缺失或无法识别时视为 stop
+func normalizeFinishReason(reason string) string {
+	if mapped, ok := finishReasons[strings.ToLower(reason)]; ok {
+		return mapped
+	}
+	if reason != "" {
+		logger.Log.Warnf("未知的结束原因: %s", reason)
+	}
+	return "stop"
+}
+
+// upstreamErrorType 将上游状态码映射为错误类型
+func upstreamErrorType(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return "invalid_request"
+	case http.StatusUnauthorized:
+		return "unauthorized"
+	case http.StatusForbidden:
+		return "permission_denied"
+	case http.StatusNotFound:
+		return "not_found"
+	case http.StatusTooManyRequests:
+		return "rate_limit_exceeded"
+	default:
+		return "internal_server_error"
+	}
+}
+
+// failWithUpstreamError 将上游请求错误交给 sink
+func failWithUpstreamError(sink completionSink, err error) {
+	if ue, ok := err.(*upstream.Error); ok {
+		sink.Fail(ue.Status, upstreamErrorType(ue.Status), ue.Message)
+		return
+	}
+	logger.Log.Errorf("%v", err)
+	sink.Fail(http.StatusServiceUnavailable, "service_unavailable", err.Error())
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/trae2api/config"
+	"github.com/trae2api/pkg/traesse"
+)
+
+// queuePolicy 上游排队时的重试策略
+type queuePolicy struct {
+	// 收到排队事件后重新发起请求的最大次数
+	maxRetries int
+	// 第一次重试前的等待时间
+	interval time.Duration
+	// 每次重试后等待时间的倍数
+	backoff float64
+	// 排队的最长等待时间，0 表示不限制
+	maxWait time.Duration
+}
+
+func newQueuePolicy() queuePolicy {
+	backoff, err := strconv.ParseFloat(config.QueueRetryBackoff, 64)
+	if err != nil || backoff < 1 {
+		backoff = 2
+	}
+	return queuePolicy{
+		maxRetries: atoiOr(config.QueueMaxRetries, 3),
+		interval:   time.Duration(atoiOr(config.QueueRetryInterval, 3)) * time.Second,
+		backoff:    backoff,
+		maxWait:    time.Duration(atoiOr(config.QueueMaxWait, 120)) * time.Second,
+	}
+}
+
+// delay 返回第 retry 次重试（从 0 开始）前的等待时间
+func (p queuePolicy) delay(retry int) time.Duration {
+	return time.Duration(float64(p.interval) * math.Pow(p.backoff, float64(retry)))
+}
+
+// exceeded 判断已排队 waited 后再等待 next 是否超过最长等待时间
+func (p queuePolicy) exceeded(waited time.Duration, next time.Duration) bool {
+	return p.maxWait > 0 && waited+next > p.maxWait
+}
+
+// failQueueTimeout 排队超时，以 429 结束响应并通过 Retry-After 提示客户端稍后重试
+func failQueueTimeout(sink completionSink, waited time.Duration, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	sink.QueueTimeout(seconds, fmt.Sprintf("上游排队已等待 %d 秒仍未开始处理，请 %d 秒后重试", int(waited.Seconds()), seconds))
+}
+
+// writeQueueTimeout 写出排队超时错误，尚未写出响应时通过 Retry-After 响应头返回重试时间
+//
+// 多个候选共用响应时，调用方需持有 sink 的锁。
+func writeQueueTimeout(c *gin.Context, retryAfter int, message string, fail func(status int, errType string, message string)) {
+	if !c.Writer.Written() {
+		c.Header("Retry-After", strconv.Itoa(retryAfter))
+	}
+	fail(http.StatusTooManyRequests, "rate_limit_exceeded", message)
+}
+
+// setQueueHeader 在尚未写出响应时通过响应头返回排队位置
+func setQueueHeader(c *gin.Context, ev *traesse.QueueEvent) {
+	if !c.Writer.Written() {
+		c.Header("X-Queue-Position", strconv.Itoa(ev.Position))
+	}
+}
+
+// writeQueueComment 以 SSE 注释的形式返回排队状态，客户端会忽略注释，不影响回复内容
+//
+// 流式 sink 只在响应已经开始输出后写出注释。排队期间不开始输出，
+// 排队超时时仍可返回 429 与 Retry-After。
+func writeQueueComment(c *gin.Context, ev *traesse.QueueEvent) error {
+	if _, err := fmt.Fprintf(c.Writer, ": queue position=%d\n\n", ev.Position); err != nil {
+		return err
+	}
+	c.Writer.Flush()
+	return nil
+}
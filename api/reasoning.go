@@ -0,0 +1,122 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/trae2api/config"
+)
+
+// 推理内容的输出方式
+const (
+	// 以 reasoning_content 字段单独返回
+	reasoningFormatField = "reasoning_content"
+	// 包裹在 <think> 标签中并入正文
+	reasoningFormatThink = "think"
+	// 不返回推理内容
+	reasoningFormatNone = "none"
+)
+
+// apiKeyContextKey 鉴权通过的 API Key 在 gin.Context 中的键名
+const apiKeyContextKey = "apiKey"
+
+// parseReasoningFormat 解析推理输出方式，支持常见别名
+func parseReasoningFormat(value string) (string, bool) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "reasoning_content", "reasoning", "field":
+		return reasoningFormatField, true
+	case "think", "think_tags", "tags":
+		return reasoningFormatThink, true
+	case "none", "drop", "hidden":
+		return reasoningFormatNone, true
+	}
+	return "", false
+}
+
+// keyReasoningFormats 解析 REASONING_FORMAT_KEYS 中 key:format 形式的配置
+func keyReasoningFormats() map[string]string {
+	formats := make(map[string]string)
+	for _, item := range strings.Split(config.ReasoningFormatKeys, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(item), ":")
+		if !ok || key == "" {
+			continue
+		}
+		if format, ok := parseReasoningFormat(value); ok {
+			formats[key] = format
+		}
+	}
+	return formats
+}
+
+// resolveReasoningFormat 确定本次请求的推理输出方式
+//
+// 优先级：请求体 reasoning_format > 请求头 X-Reasoning-Format > API Key 配置 > 全局配置。
+func resolveReasoningFormat(c *gin.Context, requested string) (string, error) {
+	for _, value := range []string{requested, c.GetHeader("X-Reasoning-Format")} {
+		if value == "" {
+			continue
+		}
+		format, ok := parseReasoningFormat(value)
+		if !ok {
+			return "", fmt.Errorf("不支持的 reasoning_format: %s，可选值为 reasoning_content、think、none", value)
+		}
+		return format, nil
+	}
+
+	if format, ok := keyReasoningFormats()[c.GetString(apiKeyContextKey)]; ok {
+		return format, nil
+	}
+	if format, ok := parseReasoningFormat(config.ReasoningFormat); ok {
+		return format, nil
+	}
+	return reasoningFormatThink, nil
+}
+
+// reasoningFormatter 按输出方式拆分一次增量中的正文与推理内容
+type reasoningFormatter struct {
+	format string
+	// <think> 标签是否已打开
+	open bool
+}
+
+// apply 返回本次增量需要输出的正文与 reasoning_content
+func (f *reasoningFormatter) apply(d completionDelta) (string, string) {
+	switch f.format {
+	case reasoningFormatField:
+		return d.Content, d.Reasoning
+	case reasoningFormatNone:
+		return d.Content, ""
+	}
+
+	var sb strings.Builder
+
+	// thinking start
+	if d.Reasoning != "" {
+		if !f.open {
+			sb.WriteString("<think>\n\n")
+			f.open = true
+		}
+		sb.WriteString(d.Reasoning)
+	}
+
+	// thinking end
+	if d.Content != "" {
+		if f.open {
+			sb.WriteString("</think>\n\n")
+			f.open = false
+		}
+		sb.WriteString(d.Content)
+	}
+
+	return sb.String(), ""
+}
+
+// close 回复结束时关闭仍未关闭的 <think> 标签，只有推理内容没有正文的回复需要
+func (f *reasoningFormatter) close() string {
+	if !f.open {
+		return ""
+	}
+	f.open = false
+	return "</think>"
+}
@@ -0,0 +1,592 @@
+package api
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/trae2api/config"
+	"github.com/trae2api/pkg/logger"
+	"github.com/trae2api/pkg/traesse"
+)
+
+// 已完成响应的保留时间，用于 previous_response_id 续接对话
+const responseStoreTTL = 2 * time.Hour
+
+// ResponsesRequest OpenAI Responses API 请求体
+type ResponsesRequest struct {
+	Model              string      `json:"model"`
+	Input              interface{} `json:"input"`
+	Instructions       string      `json:"instructions,omitempty"`
+	PreviousResponseID string      `json:"previous_response_id,omitempty"`
+	Stream             bool        `json:"stream"`
+	Temperature        float64     `json:"temperature,omitempty"`
+	MaxOutputTokens    int         `json:"max_output_tokens,omitempty"`
+}
+
+// storedResponse 已完成的响应对应的完整对话
+type storedResponse struct {
+	id        string
+	messages  []ChatMessage
+	createdAt time.Time
+}
+
+// responseStore 按最近使用顺序保存已完成的响应，超过条数上限时淘汰最久未使用的记录
+var responseStore = struct {
+	sync.Mutex
+	items map[string]*list.Element
+	order *list.List
+}{items: make(map[string]*list.Element), order: list.New()}
+
+// saveResponse 保存响应对应的对话
+func saveResponse(id string, messages []ChatMessage) {
+	responseStore.Lock()
+	defer responseStore.Unlock()
+
+	if el, ok := responseStore.items[id]; ok {
+		responseStore.order.Remove(el)
+	}
+	responseStore.items[id] = responseStore.order.PushFront(&storedResponse{id: id, messages: messages, createdAt: time.Now()})
+
+	maxEntries := atoiOr(config.ResponseStoreMaxEntries, 1000)
+	for maxEntries > 0 && responseStore.order.Len() > maxEntries {
+		removeResponse(responseStore.order.Back())
+	}
+}
+
+// loadResponse 读取 previous_response_id 对应的对话，过期的记录在读取时删除
+func loadResponse(id string) ([]ChatMessage, bool) {
+	responseStore.Lock()
+	defer responseStore.Unlock()
+
+	el, ok := responseStore.items[id]
+	if !ok {
+		return nil, false
+	}
+	stored := el.Value.(*storedResponse)
+	if time.Since(stored.createdAt) > responseStoreTTL {
+		removeResponse(el)
+		return nil, false
+	}
+	responseStore.order.MoveToFront(el)
+	return append([]ChatMessage(nil), stored.messages...), true
+}
+
+// removeResponse 删除一条记录，调用方需持有 responseStore 的锁
+func removeResponse(el *list.Element) {
+	responseStore.order.Remove(el)
+	delete(responseStore.items, el.Value.(*storedResponse).id)
+}
+
+// CreateResponse 处理 OpenAI Responses 格式的 /v1/responses 请求
+func CreateResponse(c *gin.Context) {
+	// 检查 RefreshToken 是否过期
+	if config.IsRefreshTokenExpired() {
+		writeError(c, http.StatusUnauthorized, "token_expired", "RefreshToken 已过期，请更新环境变量中的 REFRESH_TOKEN")
+		return
+	}
+
+	var respReq ResponsesRequest
+	if err := c.BindJSON(&respReq); err != nil {
+		logger.Log.Errorf("解析请求体失败: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 检查模型是否支持
+	if !isModelSupported(respReq.Model) {
+		errMsg := fmt.Sprintf("不支持的模型: %s", respReq.Model)
+		logger.Log.Errorf("%s", errMsg)
+		writeError(c, http.StatusBadRequest, "invalid_request_error", errMsg)
+		return
+	}
+
+	// 续接之前的对话
+	var history []ChatMessage
+	var err error
+	if respReq.PreviousResponseID != "" {
+		var ok bool
+		history, ok = loadResponse(respReq.PreviousResponseID)
+		if !ok {
+			writeError(c, http.StatusNotFound, "invalid_request_error",
+				fmt.Sprintf("未找到 previous_response_id 对应的响应: %s", respReq.PreviousResponseID))
+			return
+		}
+	}
+
+	// 上传最后一个输入项中的图片
+	var multiMedia []interface{}
+	if items, ok := respReq.Input.([]interface{}); ok && len(items) > 0 {
+		if item, ok := items[len(items)-1].(map[string]interface{}); ok {
+			var status int
+			multiMedia, status, err = attachImages(c.Request.Context(), convertModelName(respReq.Model), item["content"])
+			if err != nil {
+				writeError(c, status, "invalid_request_error", err.Error())
+				return
+			}
+		}
+	}
+
+	input, err := responsesInputMessages(respReq.Input, len(multiMedia) > 0)
+	if err != nil {
+		writeError(c, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+	history = append(history, input...)
+	if len(history) == 0 {
+		writeError(c, http.StatusBadRequest, "invalid_request_error", "input 不能为空")
+		return
+	}
+
+	// instructions 不会被带入后续的响应
+	messages := history
+	if respReq.Instructions != "" {
+		messages = append([]ChatMessage{{Role: "system", Content: respReq.Instructions}}, history...)
+	}
+
+	chatReq := ChatRequest{
+		Model:       convertModelName(respReq.Model),
+		Messages:    messages,
+		Stream:      respReq.Stream,
+		Temperature: respReq.Temperature,
+		MaxTokens:   respReq.MaxOutputTokens,
+		MultiMedia:  multiMedia,
+	}
+
+	builder := newResponseBuilder(respReq, history)
+	var sink completionSink
+	if respReq.Stream {
+		sink = &responsesStreamSink{c: c, b: builder}
+	} else {
+		sink = &responsesAggregateSink{c: c, b: builder}
+	}
+	runChatPipeline(c, chatReq, sink)
+}
+
+// responsesInputMessages 将 input 字段转换为消息列表
+//
+// imagesAttached 表示最后一个输入项中的图片已通过 multi_media 发送。
+func responsesInputMessages(input interface{}, imagesAttached bool) ([]ChatMessage, error) {
+	switch v := input.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []ChatMessage{{Role: "user", Content: v}}, nil
+	case []interface{}:
+		messages := make([]ChatMessage, 0, len(v))
+		for i, raw := range v {
+			item, ok := raw.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("无法解析的 input 项: %v", raw)
+			}
+
+			switch item["type"] {
+			case "function_call":
+				messages = append(messages, ChatMessage{
+					Role:    "assistant",
+					Content: fmt.Sprintf("[function_call %v] %v", item["name"], item["arguments"]),
+				})
+			case "function_call_output":
+				messages = append(messages, ChatMessage{
+					Role:    "user",
+					Content: fmt.Sprintf("[function_call_output %v] %v", item["call_id"], item["output"]),
+				})
+			case nil, "message":
+				role, _ := item["role"].(string)
+				if role == "" {
+					role = "user"
+				}
+				messages = append(messages, ChatMessage{
+					Role:    role,
+					Content: flattenContent(item["content"], i == len(v)-1 && imagesAttached),
+				})
+			default:
+				// reasoning 等其他类型的输入项不参与对话
+				continue
+			}
+		}
+		return messages, nil
+	default:
+		return nil, fmt.Errorf("input 必须是字符串或数组")
+	}
+}
+
+// responseBuilder 累积输出并构造 response 对象，流式与非流式共用
+type responseBuilder struct {
+	req       ResponsesRequest
+	history   []ChatMessage
+	id        string
+	createdAt int64
+
+	reasoningID string
+	messageID   string
+	reasoning   strings.Builder
+	text        strings.Builder
+	usage       tokenUsage
+}
+
+func newResponseBuilder(req ResponsesRequest, history []ChatMessage) *responseBuilder {
+	return &responseBuilder{
+		req:         req,
+		history:     history,
+		id:          "resp_" + strings.ReplaceAll(uuid.New().String(), "-", ""),
+		createdAt:   time.Now().Unix(),
+		reasoningID: "rs_" + strings.ReplaceAll(uuid.New().String(), "-", ""),
+		messageID:   "msg_" + strings.ReplaceAll(uuid.New().String(), "-", ""),
+	}
+}
+
+// reasoningItem 构造 reasoning 输出项
+func (b *responseBuilder) reasoningItem() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "reasoning",
+		"id":   b.reasoningID,
+		"summary": []map[string]interface{}{
+			{"type": "summary_text", "text": b.reasoning.String()},
+		},
+	}
+}
+
+// messageItem 构造 message 输出项
+func (b *responseBuilder) messageItem(status string) map[string]interface{} {
+	content := []map[string]interface{}{}
+	if status == "completed" {
+		content = append(content, b.textPart())
+	}
+	return map[string]interface{}{
+		"type":    "message",
+		"id":      b.messageID,
+		"status":  status,
+		"role":    "assistant",
+		"content": content,
+	}
+}
+
+// textPart 构造 output_text 内容
+func (b *responseBuilder) textPart() map[string]interface{} {
+	return map[string]interface{}{
+		"type":        "output_text",
+		"text":        b.text.String(),
+		"annotations": []interface{}{},
+	}
+}
+
+// response 构造 response 对象，finishReason 为空表示仍在进行中
+func (b *responseBuilder) response(finishReason string) map[string]interface{} {
+	status := "in_progress"
+	output := []map[string]interface{}{}
+	var incomplete, usage interface{}
+
+	if finishReason != "" {
+		status = "completed"
+		usage = b.usage.responses()
+		if finishReason == "length" {
+			status = "incomplete"
+			incomplete = map[string]interface{}{"reason": "max_output_tokens"}
+		}
+		if b.reasoning.Len() > 0 {
+			output = append(output, b.reasoningItem())
+		}
+		output = append(output, b.messageItem("completed"))
+	}
+
+	var previous interface{}
+	if b.req.PreviousResponseID != "" {
+		previous = b.req.PreviousResponseID
+	}
+	var instructions interface{}
+	if b.req.Instructions != "" {
+		instructions = b.req.Instructions
+	}
+
+	resp := map[string]interface{}{
+		"id":                   b.id,
+		"object":               "response",
+		"created_at":           b.createdAt,
+		"status":               status,
+		"model":                b.req.Model,
+		"output":               output,
+		"instructions":         instructions,
+		"previous_response_id": previous,
+		"incomplete_details":   incomplete,
+		"error":                nil,
+		"usage":                usage,
+	}
+	if finishReason != "" {
+		resp["output_text"] = b.text.String()
+	}
+	return resp
+}
+
+// save 保存本次对话，供后续请求通过 previous_response_id 续接
+func (b *responseBuilder) save() {
+	messages := append(append([]ChatMessage(nil), b.history...), ChatMessage{
+		Role:    "assistant",
+		Content: b.text.String(),
+	})
+	saveResponse(b.id, messages)
+}
+
+// responsesAggregateSink 收集全部内容后返回 response 对象
+type responsesAggregateSink struct {
+	c *gin.Context
+	b *responseBuilder
+}
+
+func (s *responsesAggregateSink) Queue(ev *traesse.QueueEvent) error {
+	setQueueHeader(s.c, ev)
+	return nil
+}
+
+func (s *responsesAggregateSink) Delta(d completionDelta) error {
+	s.b.reasoning.WriteString(d.Reasoning)
+	s.b.text.WriteString(d.Content)
+	return nil
+}
+
+func (s *responsesAggregateSink) Finish(finishReason string, usage tokenUsage) error {
+	if s.b.text.Len() == 0 && s.b.reasoning.Len() == 0 {
+		s.Fail(http.StatusInternalServerError, "api_error", "未收到远程服务的响应")
+		return nil
+	}
+	s.b.usage = usage
+	s.b.save()
+	s.c.JSON(http.StatusOK, s.b.response(finishReason))
+	return nil
+}
+
+func (s *responsesAggregateSink) Fail(status int, errType string, message string) {
+	writeError(s.c, status, errType, message)
+}
+
+func (s *responsesAggregateSink) QueueTimeout(retryAfter int, message string) {
+	writeQueueTimeout(s.c, retryAfter, message, s.Fail)
+}
+
+// responsesStreamSink 以 Responses 流式事件的形式逐条写出内容
+type responsesStreamSink struct {
+	c       *gin.Context
+	b       *responseBuilder
+	started bool
+	seq     int
+
+	// 当前打开的输出项类型及序号
+	itemType    string
+	outputIndex int
+}
+
+// writeEvent 写出一条带事件名的 SSE 消息
+func (s *responsesStreamSink) writeEvent(event string, payload map[string]interface{}) error {
+	payload["type"] = event
+	payload["sequence_number"] = s.seq
+	s.seq++
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if _, err := s.c.Writer.Write([]byte("event: " + event + "\ndata: " + string(data) + "\n\n")); err != nil {
+		return err
+	}
+	s.c.Writer.Flush()
+	return nil
+}
+
+// start 写出响应头与 response.created 事件
+func (s *responsesStreamSink) start() error {
+	if s.started {
+		return nil
+	}
+	s.started = true
+	s.outputIndex = -1
+	s.c.Header("Content-Type", "text/event-stream")
+	s.c.Header("Cache-Control", "no-cache")
+	s.c.Header("Connection", "keep-alive")
+
+	if err := s.writeEvent("response.created", map[string]interface{}{"response": s.b.response("")}); err != nil {
+		return err
+	}
+	return s.writeEvent("response.in_progress", map[string]interface{}{"response": s.b.response("")})
+}
+
+// openItem 切换到指定类型的输出项，必要时关闭上一个输出项
+func (s *responsesStreamSink) openItem(itemType string) error {
+	if s.itemType == itemType {
+		return nil
+	}
+	if err := s.closeItem(); err != nil {
+		return err
+	}
+	s.itemType = itemType
+	s.outputIndex++
+
+	if itemType == "reasoning" {
+		item := s.b.reasoningItem()
+		item["summary"] = []interface{}{}
+		if err := s.writeEvent("response.output_item.added", map[string]interface{}{
+			"output_index": s.outputIndex,
+			"item":         item,
+		}); err != nil {
+			return err
+		}
+		return s.writeEvent("response.reasoning_summary_part.added", map[string]interface{}{
+			"item_id":       s.b.reasoningID,
+			"output_index":  s.outputIndex,
+			"summary_index": 0,
+			"part":          map[string]interface{}{"type": "summary_text", "text": ""},
+		})
+	}
+
+	if err := s.writeEvent("response.output_item.added", map[string]interface{}{
+		"output_index": s.outputIndex,
+		"item":         s.b.messageItem("in_progress"),
+	}); err != nil {
+		return err
+	}
+	return s.writeEvent("response.content_part.added", map[string]interface{}{
+		"item_id":       s.b.messageID,
+		"output_index":  s.outputIndex,
+		"content_index": 0,
+		"part":          map[string]interface{}{"type": "output_text", "text": "", "annotations": []interface{}{}},
+	})
+}
+
+// closeItem 关闭当前输出项
+func (s *responsesStreamSink) closeItem() error {
+	switch s.itemType {
+	case "reasoning":
+		s.itemType = ""
+		if err := s.writeEvent("response.reasoning_summary_text.done", map[string]interface{}{
+			"item_id":       s.b.reasoningID,
+			"output_index":  s.outputIndex,
+			"summary_index": 0,
+			"text":          s.b.reasoning.String(),
+		}); err != nil {
+			return err
+		}
+		if err := s.writeEvent("response.reasoning_summary_part.done", map[string]interface{}{
+			"item_id":       s.b.reasoningID,
+			"output_index":  s.outputIndex,
+			"summary_index": 0,
+			"part":          map[string]interface{}{"type": "summary_text", "text": s.b.reasoning.String()},
+		}); err != nil {
+			return err
+		}
+		return s.writeEvent("response.output_item.done", map[string]interface{}{
+			"output_index": s.outputIndex,
+			"item":         s.b.reasoningItem(),
+		})
+	case "message":
+		s.itemType = ""
+		if err := s.writeEvent("response.output_text.done", map[string]interface{}{
+			"item_id":       s.b.messageID,
+			"output_index":  s.outputIndex,
+			"content_index": 0,
+			"text":          s.b.text.String(),
+		}); err != nil {
+			return err
+		}
+		if err := s.writeEvent("response.content_part.done", map[string]interface{}{
+			"item_id":       s.b.messageID,
+			"output_index":  s.outputIndex,
+			"content_index": 0,
+			"part":          s.b.textPart(),
+		}); err != nil {
+			return err
+		}
+		return s.writeEvent("response.output_item.done", map[string]interface{}{
+			"output_index": s.outputIndex,
+			"item":         s.b.messageItem("completed"),
+		})
+	}
+	return nil
+}
+
+// Queue 排队期间不开始输出，已开始输出时以 SSE 注释返回排队位置
+func (s *responsesStreamSink) Queue(ev *traesse.QueueEvent) error {
+	setQueueHeader(s.c, ev)
+	if !s.started {
+		return nil
+	}
+	return writeQueueComment(s.c, ev)
+}
+
+func (s *responsesStreamSink) Delta(d completionDelta) error {
+	if err := s.start(); err != nil {
+		return err
+	}
+
+	if d.Reasoning != "" {
+		if err := s.openItem("reasoning"); err != nil {
+			return err
+		}
+		s.b.reasoning.WriteString(d.Reasoning)
+		if err := s.writeEvent("response.reasoning_summary_text.delta", map[string]interface{}{
+			"item_id":       s.b.reasoningID,
+			"output_index":  s.outputIndex,
+			"summary_index": 0,
+			"delta":         d.Reasoning,
+		}); err != nil {
+			return err
+		}
+	}
+
+	if d.Content != "" {
+		if err := s.openItem("message"); err != nil {
+			return err
+		}
+		s.b.text.WriteString(d.Content)
+		if err := s.writeEvent("response.output_text.delta", map[string]interface{}{
+			"item_id":       s.b.messageID,
+			"output_index":  s.outputIndex,
+			"content_index": 0,
+			"delta":         d.Content,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *responsesStreamSink) Finish(finishReason string, usage tokenUsage) error {
+	if err := s.start(); err != nil {
+		return err
+	}
+	// 确保至少输出一个 message 项
+	if err := s.openItem("message"); err != nil {
+		return err
+	}
+	if err := s.closeItem(); err != nil {
+		return err
+	}
+
+	s.b.usage = usage
+	s.b.save()
+	event := "response.completed"
+	if finishReason == "length" {
+		event = "response.incomplete"
+	}
+	return s.writeEvent(event, map[string]interface{}{"response": s.b.response(finishReason)})
+}
+
+func (s *responsesStreamSink) Fail(status int, errType string, message string) {
+	if !s.started {
+		writeError(s.c, status, errType, message)
+		return
+	}
+	_ = s.writeEvent("error", map[string]interface{}{
+		"code":    errType,
+		"message": message,
+		"param":   nil,
+	})
+}
+
+func (s *responsesStreamSink) QueueTimeout(retryAfter int, message string) {
+	writeQueueTimeout(s.c, retryAfter, message, s.Fail)
+}
@@ -0,0 +1,176 @@
+package api_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/trae2api/config"
+	"github.com/trae2api/pkg/traefake"
+)
+
+// response 发送 /v1/responses 请求，extra 中的字段合并到请求体
+func (e *testEnv) response(t *testing.T, input interface{}, extra map[string]interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	body := map[string]interface{}{"model": "deepseek-r1", "input": input}
+	for k, v := range extra {
+		body[k] = v
+	}
+	return e.do(t, http.MethodPost, "/v1/responses", body)
+}
+
+// lastPrompt 返回最近一次上游请求中的全部对话内容
+func lastPrompt(t *testing.T, env *testEnv) string {
+	t.Helper()
+	requests := env.fake.Requests()
+	if len(requests) == 0 {
+		t.Fatalf("没有上游请求")
+	}
+	req := requests[len(requests)-1]
+	var parts []string
+	for _, h := range req.ChatHistory {
+		parts = append(parts, h.Role+": "+h.Content)
+	}
+	return strings.Join(append(parts, req.UserInput), "\n")
+}
+
+func TestCreateResponsePreviousResponseID(t *testing.T) {
+	env := newTestEnv(t, traefake.Options{})
+	env.fake.Handle("first question", traefake.Reply("", "first answer", "stop"))
+	env.fake.SetDefault(traefake.Reply("", "second answer", "stop"))
+
+	w := env.response(t, "first question", map[string]interface{}{"instructions": "Answer in pirate speak."})
+	if w.Code != http.StatusOK {
+		t.Fatalf("状态码 %d, body: %s", w.Code, w.Body.String())
+	}
+	first := decodeJSON(t, w)
+	if first["output_text"] != "first answer" || first["instructions"] != "Answer in pirate speak." {
+		t.Errorf("output_text %v, instructions %v", first["output_text"], first["instructions"])
+	}
+	if prompt := lastPrompt(t, env); !strings.Contains(prompt, "Answer in pirate speak.") {
+		t.Errorf("instructions 没有发送给上游: %s", prompt)
+	}
+
+	// 续接时带上之前的问答，但不带上一次的 instructions
+	w = env.response(t, "second question", map[string]interface{}{"previous_response_id": first["id"]})
+	if w.Code != http.StatusOK {
+		t.Fatalf("状态码 %d, body: %s", w.Code, w.Body.String())
+	}
+	second := decodeJSON(t, w)
+	if second["previous_response_id"] != first["id"] || second["instructions"] != nil {
+		t.Errorf("previous_response_id %v, instructions %v", second["previous_response_id"], second["instructions"])
+	}
+	prompt := lastPrompt(t, env)
+	for _, want := range []string{"first question", "first answer", "second question"} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("续接的对话缺少 %q: %s", want, prompt)
+		}
+	}
+	if strings.Contains(prompt, "pirate") {
+		t.Errorf("instructions 不应带入续接的对话: %s", prompt)
+	}
+
+	// 续接可以继续链式进行
+	w = env.response(t, "third question", map[string]interface{}{"previous_response_id": second["id"]})
+	if w.Code != http.StatusOK {
+		t.Fatalf("状态码 %d, body: %s", w.Code, w.Body.String())
+	}
+	prompt = lastPrompt(t, env)
+	for _, want := range []string{"first question", "first answer", "second question", "second answer", "third question"} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("链式续接的对话缺少 %q: %s", want, prompt)
+		}
+	}
+}
+
+func TestCreateResponseUnknownPreviousResponseID(t *testing.T) {
+	env := newTestEnv(t, traefake.Options{})
+
+	w := env.response(t, "hi", map[string]interface{}{"previous_response_id": "resp_unknown"})
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("状态码 %d, 期望 404, body: %s", w.Code, w.Body.String())
+	}
+	if msg := errorMessage(t, w); !strings.Contains(msg, "resp_unknown") {
+		t.Errorf("错误信息 %q", msg)
+	}
+	if len(env.fake.Requests()) != 0 {
+		t.Errorf("不应请求上游")
+	}
+}
+
+func TestCreateResponseStoreEviction(t *testing.T) {
+	env := newTestEnv(t, traefake.Options{})
+	env.fake.SetDefault(traefake.Reply("", "ok", "stop"))
+	setConfig(t, &config.ResponseStoreMaxEntries, "2")
+
+	create := func(extra map[string]interface{}) string {
+		t.Helper()
+		w := env.response(t, "hi", extra)
+		if w.Code != http.StatusOK {
+			t.Fatalf("状态码 %d, body: %s", w.Code, w.Body.String())
+		}
+		return decodeJSON(t, w)["id"].(string)
+	}
+
+	a := create(nil)
+	b := create(nil)
+	// 续接 a 使其成为最近使用的响应，新保存的响应淘汰最久未使用的 b
+	create(map[string]interface{}{"previous_response_id": a})
+
+	if w := env.response(t, "hi", map[string]interface{}{"previous_response_id": b}); w.Code != http.StatusNotFound {
+		t.Errorf("已淘汰的响应: 状态码 %d, 期望 404", w.Code)
+	}
+	if w := env.response(t, "hi", map[string]interface{}{"previous_response_id": a}); w.Code != http.StatusOK {
+		t.Errorf("最近使用的响应: 状态码 %d, body: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestCreateResponseStream(t *testing.T) {
+	env := newTestEnv(t, traefake.Options{})
+	env.fake.SetDefault(traefake.Reply("Think first.", "Then answer.", "stop"))
+
+	w := env.response(t, "hi", map[string]interface{}{"stream": true})
+	if w.Code != http.StatusOK {
+		t.Fatalf("状态码 %d, body: %s", w.Code, w.Body.String())
+	}
+	events := sseEvents(t, w.Body.String())
+	want := []string{
+		"response.created", "response.in_progress",
+		"response.output_item.added", "response.reasoning_summary_part.added",
+		"response.reasoning_summary_text.delta",
+		"response.reasoning_summary_text.done", "response.reasoning_summary_part.done", "response.output_item.done",
+		"response.output_item.added", "response.content_part.added",
+		"response.output_text.delta",
+		"response.output_text.done", "response.content_part.done", "response.output_item.done",
+		"response.completed",
+	}
+	if got := eventNames(events); !reflect.DeepEqual(got, want) {
+		t.Fatalf("事件顺序\n got: %v\nwant: %v", got, want)
+	}
+
+	// sequence_number 从 0 开始连续递增
+	for i, ev := range events {
+		if ev.data["type"] != ev.name {
+			t.Errorf("事件 %s 的 type 为 %v", ev.name, ev.data["type"])
+		}
+		if seq := ev.data["sequence_number"]; seq != float64(i) {
+			t.Errorf("第 %d 个事件 %s 的 sequence_number 为 %v", i, ev.name, seq)
+		}
+	}
+
+	completed := events[len(events)-1].data["response"].(map[string]interface{})
+	if completed["status"] != "completed" || completed["output_text"] != "Then answer." {
+		t.Errorf("status %v, output_text %v", completed["status"], completed["output_text"])
+	}
+
+	// 流式响应同样可以续接
+	w = env.response(t, "next", map[string]interface{}{"previous_response_id": completed["id"]})
+	if w.Code != http.StatusOK {
+		t.Fatalf("续接流式响应: 状态码 %d, body: %s", w.Code, w.Body.String())
+	}
+	if prompt := lastPrompt(t, env); !strings.Contains(prompt, "Then answer.") {
+		t.Errorf("续接的对话缺少上一次的回复: %s", prompt)
+	}
+}
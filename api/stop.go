@@ -0,0 +1,109 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// 最多支持的 stop 序列数量，与 OpenAI 保持一致
+	maxStopSequences = 4
+	// Anthropic 的 stop_sequences 没有数量限制，这里限制数量以免检测过慢
+	maxAnthropicStopSequences = 64
+)
+
+// parseStopSequences 解析名为 field 的 stop 字段，支持字符串或字符串数组，最多 limit 个
+func parseStopSequences(field string, stop interface{}, limit int) ([]string, error) {
+	var stops []string
+	switch v := stop.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		stops = []string{v}
+	case []interface{}:
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s 必须是字符串或字符串数组", field)
+			}
+			stops = append(stops, s)
+		}
+	case []string:
+		stops = v
+	default:
+		return nil, fmt.Errorf("%s 必须是字符串或字符串数组", field)
+	}
+
+	result := make([]string, 0, len(stops))
+	for _, s := range stops {
+		if s != "" {
+			result = append(result, s)
+		}
+	}
+	if len(result) > limit {
+		return nil, fmt.Errorf("%s 最多支持 %d 个序列", field, limit)
+	}
+	return result, nil
+}
+
+// stopScanner 在增量文本中查找 stop 序列
+//
+// 可能构成 stop 序列前缀的尾部文本会被暂存，直到能确定是否命中，
+// 因此跨越两次增量的 stop 序列同样可以被识别。
+type stopScanner struct {
+	stops   []string
+	pending string
+	matched bool
+	// sequence 命中的 stop 序列
+	sequence string
+}
+
+func newStopScanner(stops []string) *stopScanner {
+	return &stopScanner{stops: stops}
+}
+
+// push 写入一段增量文本，返回可以安全输出的文本以及是否命中 stop 序列
+//
+// 命中后返回的文本截止到 stop 序列之前，后续写入的内容全部丢弃。
+func (s *stopScanner) push(text string) (string, bool) {
+	if s.matched {
+		return "", true
+	}
+	if len(s.stops) == 0 {
+		return text, false
+	}
+
+	buf := s.pending + text
+	cut := -1
+	for _, stop := range s.stops {
+		if idx := strings.Index(buf, stop); idx >= 0 && (cut < 0 || idx < cut) {
+			cut = idx
+			s.sequence = stop
+		}
+	}
+	if cut >= 0 {
+		s.matched = true
+		s.pending = ""
+		return buf[:cut], true
+	}
+
+	// 暂存可能是 stop 序列开头的尾部
+	hold := 0
+	for _, stop := range s.stops {
+		for k := len(stop) - 1; k > hold; k-- {
+			if strings.HasSuffix(buf, stop[:k]) {
+				hold = k
+				break
+			}
+		}
+	}
+	s.pending = buf[len(buf)-hold:]
+	return buf[:len(buf)-hold], false
+}
+
+// flush 返回暂存的文本，在上游结束时调用
+func (s *stopScanner) flush() string {
+	pending := s.pending
+	s.pending = ""
+	return pending
+}
@@ -0,0 +1,78 @@
+package api_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/trae2api/pkg/tokenizer"
+	"github.com/trae2api/pkg/traefake"
+)
+
+func TestStopSequenceUsage(t *testing.T) {
+	count := tokenizer.ForModel("gpt-4o").Count
+
+	tests := []struct {
+		name     string
+		response string
+		stop     []string
+		want     string
+		// 期望的 completion_tokens，stop 检测暂存的文本在上游结束时单独计数
+		wantTokens int
+	}{
+		{
+			name:       "无 stop 序列",
+			response:   "Hello world. STOP and a lot more text after it.",
+			want:       "Hello world. STOP and a lot more text after it.",
+			wantTokens: count("Hello world. STOP and a lot more text after it."),
+		},
+		{
+			name:       "stop 序列之后的文本不计入用量",
+			response:   "Hello world.STOP,then-a-rather-long-tail-in-the-same-chunk and more",
+			stop:       []string{"STOP"},
+			want:       "Hello world.",
+			wantTokens: count("Hello world."),
+		},
+		{
+			name:       "暂存的 stop 序列前缀在结束时输出并计入用量",
+			response:   "Hello world ST",
+			stop:       []string{"STOP"},
+			want:       "Hello world ST",
+			wantTokens: count("Hello world ") + count("ST"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, traefake.Options{})
+			env.fake.SetDefault(traefake.Reply("", tt.response, "stop"))
+
+			extra := map[string]interface{}{}
+			if tt.stop != nil {
+				extra["stop"] = tt.stop
+			}
+			w := env.chat(t, "gpt-4o", "hi", extra)
+			if w.Code != http.StatusOK {
+				t.Fatalf("状态码 %d, body: %s", w.Code, w.Body.String())
+			}
+			resp := decodeJSON(t, w)
+			message := resp["choices"].([]interface{})[0].(map[string]interface{})["message"].(map[string]interface{})
+			if message["content"] != tt.want {
+				t.Errorf("content = %q, want %q", message["content"], tt.want)
+			}
+			usage := resp["usage"].(map[string]interface{})
+			if got := int(usage["completion_tokens"].(float64)); got != tt.wantTokens {
+				t.Errorf("completion_tokens = %d, want %d", got, tt.wantTokens)
+			}
+
+			// 流式响应的用量与非流式一致
+			extra["stream"] = true
+			extra["stream_options"] = map[string]interface{}{"include_usage": true}
+			w = env.chat(t, "gpt-4o", "hi", extra)
+			chunks := chatChunks(t, w.Body.String())
+			last := chunks[len(chunks)-1]["usage"].(map[string]interface{})
+			if got := int(last["completion_tokens"].(float64)); got != tt.wantTokens {
+				t.Errorf("流式 completion_tokens = %d, want %d", got, tt.wantTokens)
+			}
+		})
+	}
+}
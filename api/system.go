@@ -0,0 +1,52 @@
+package api
+
+import (
+	"strings"
+
+	"github.com/trae2api/config"
+)
+
+// 系统提示词的放置方式
+//
+// Trae 对话接口的 variables 中没有承载系统提示词的字段，写入其中的内容会被忽略，
+// 因此不提供 variables 放置方式。
+const (
+	// 作为 chat_history 的第一条 system 消息发送
+	systemPromptModeHistory = "history"
+	// 拼接在本轮用户输入之前发送
+	systemPromptModeUser = "user"
+)
+
+// splitSystemMessages 提取并合并所有 system 与 developer 消息
+//
+// 返回合并后的系统提示词以及剩余的对话消息，多条系统消息按出现顺序以空行拼接。
+func splitSystemMessages(messages []ChatMessage) (string, []ChatMessage) {
+	var prompts []string
+	rest := make([]ChatMessage, 0, len(messages))
+	for _, msg := range messages {
+		if msg.Role == "system" || msg.Role == "developer" {
+			if text := strings.TrimSpace(flattenContent(msg.Content, false)); text != "" {
+				prompts = append(prompts, text)
+			}
+			continue
+		}
+		rest = append(rest, msg)
+	}
+	return strings.Join(prompts, "\n\n"), rest
+}
+
+// systemPromptMode 返回 Trae 模型使用的系统提示词放置方式
+//
+// SYSTEM_PROMPT_USER_MODELS 中可以使用模型ID、别名或 Trae 模型名。
+func systemPromptMode(model string) string {
+	for _, item := range strings.Split(config.SystemPromptUserModels, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" && strings.EqualFold(convertModelName(item), model) {
+			return systemPromptModeUser
+		}
+	}
+	if config.SystemPromptMode == systemPromptModeUser {
+		return systemPromptModeUser
+	}
+	return systemPromptModeHistory
+}
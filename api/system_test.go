@@ -0,0 +1,40 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/trae2api/config"
+)
+
+func TestSystemPromptMode(t *testing.T) {
+	tests := []struct {
+		name       string
+		mode       string
+		userModels string
+		model      string
+		want       string
+	}{
+		{name: "默认", mode: "history", model: "deepseek-R1", want: systemPromptModeHistory},
+		{name: "全局 user", mode: "user", model: "deepseek-R1", want: systemPromptModeUser},
+		{name: "未知取值按 history 处理", mode: "variables", model: "deepseek-R1", want: systemPromptModeHistory},
+		{name: "Trae 模型名", mode: "history", userModels: "deepseek-R1", model: "deepseek-R1", want: systemPromptModeUser},
+		{name: "模型ID", mode: "history", userModels: "deepseek-r1", model: "deepseek-R1", want: systemPromptModeUser},
+		{name: "别名", mode: "history", userModels: "deepseek-reasoner", model: "deepseek-R1", want: systemPromptModeUser},
+		{name: "不区分大小写", mode: "history", userModels: "DEEPSEEK-R1", model: "deepseek-R1", want: systemPromptModeUser},
+		{name: "多个模型与空白", mode: "history", userModels: " gpt-4o , deepseek-reasoner ,", model: "deepseek-R1", want: systemPromptModeUser},
+		{name: "未列出的模型", mode: "history", userModels: "deepseek-reasoner", model: "deepseek-V3", want: systemPromptModeHistory},
+		{name: "注册表外的模型名", mode: "history", userModels: "custom-model", model: "custom-model", want: systemPromptModeUser},
+	}
+
+	savedMode, savedModels := config.SystemPromptMode, config.SystemPromptUserModels
+	defer func() { config.SystemPromptMode, config.SystemPromptUserModels = savedMode, savedModels }()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.SystemPromptMode, config.SystemPromptUserModels = tt.mode, tt.userModels
+			if got := systemPromptMode(tt.model); got != tt.want {
+				t.Errorf("systemPromptMode(%q) = %q, want %q", tt.model, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,249 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// 模型输出中工具调用块的标签
+const (
+	toolCallOpenTag  = "<tool_call>"
+	toolCallCloseTag = "</tool_call>"
+)
+
+// Tool OpenAI 格式的工具定义
+type Tool struct {
+	Type     string       `json:"type"`
+	Function ToolFunction `json:"function"`
+}
+
+// ToolFunction 工具的函数定义
+type ToolFunction struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	Parameters  interface{} `json:"parameters,omitempty"`
+}
+
+// ToolCall 助手消息中的工具调用
+type ToolCall struct {
+	Index    *int             `json:"index,omitempty"`
+	ID       string           `json:"id"`
+	Type     string           `json:"type"`
+	Function ToolCallFunction `json:"function"`
+}
+
+// ToolCallFunction 工具调用的函数名与参数
+type ToolCallFunction struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// toolChoiceMode 解析 tool_choice，返回模式以及指定的函数名
+//
+// 模式为 none、auto、required 之一，指定函数时模式为 required。
+func toolChoiceMode(choice interface{}) (string, string) {
+	switch v := choice.(type) {
+	case string:
+		switch v {
+		case "none", "required":
+			return v, ""
+		}
+	case map[string]interface{}:
+		if fn, ok := v["function"].(map[string]interface{}); ok {
+			if name, ok := fn["name"].(string); ok && name != "" {
+				return "required", name
+			}
+		}
+	}
+	return "auto", ""
+}
+
+// buildToolPrompt 将工具定义渲染为注入系统提示词的说明
+func buildToolPrompt(tools []Tool, choice interface{}) string {
+	var sb strings.Builder
+	sb.WriteString("你可以调用以下工具来完成用户的请求。\n\n# 可用工具\n<tools>\n")
+	for _, tool := range tools {
+		def, _ := json.Marshal(tool.Function)
+		sb.Write(def)
+		sb.WriteString("\n")
+	}
+	sb.WriteString("</tools>\n\n# 调用方式\n")
+	sb.WriteString("需要调用工具时，按如下格式输出调用块，每个调用块只包含一次调用，需要多次调用时连续输出多个调用块：\n")
+	sb.WriteString(toolCallOpenTag + "\n{\"name\": \"工具名称\", \"arguments\": {参数对象}}\n" + toolCallCloseTag + "\n")
+	sb.WriteString("arguments 必须是符合工具参数定义的 JSON 对象。输出调用块后立即结束回复，等待工具返回结果，不要自行编造结果。")
+	sb.WriteString("工具的执行结果会以 <tool_result> 块的形式提供给你。")
+
+	switch mode, name := toolChoiceMode(choice); {
+	case name != "":
+		sb.WriteString(fmt.Sprintf("\n\n本轮回复必须调用工具 %s。", name))
+	case mode == "required":
+		sb.WriteString("\n\n本轮回复必须至少调用一个工具。")
+	default:
+		sb.WriteString("\n\n不需要调用工具时，直接回答用户。")
+	}
+	return sb.String()
+}
+
+// renderToolMessages 将 tool 角色消息以及带 tool_calls 的助手消息转换为纯文本对话
+//
+// 连续的工具结果会合并为一条用户消息。
+func renderToolMessages(messages []ChatMessage) []ChatMessage {
+	result := make([]ChatMessage, 0, len(messages))
+	for _, msg := range messages {
+		switch {
+		case msg.Role == "tool":
+			block := fmt.Sprintf("<tool_result id=\"%s\"", msg.ToolCallID)
+			if msg.Name != "" {
+				block += fmt.Sprintf(" name=\"%s\"", msg.Name)
+			}
+			block += ">\n" + flattenContent(msg.Content, false) + "\n</tool_result>"
+
+			// 与上一条工具结果合并
+			if n := len(result); n > 0 && result[n-1].Role == "user" && strings.HasPrefix(fmt.Sprintf("%v", result[n-1].Content), "<tool_result") {
+				result[n-1].Content = fmt.Sprintf("%v", result[n-1].Content) + "\n" + block
+				continue
+			}
+			result = append(result, ChatMessage{Role: "user", Content: block})
+
+		case msg.Role == "assistant" && len(msg.ToolCalls) > 0:
+			parts := make([]string, 0, len(msg.ToolCalls)+1)
+			if text := flattenContent(msg.Content, false); text != "" {
+				parts = append(parts, text)
+			}
+			for _, call := range msg.ToolCalls {
+				var args interface{} = call.Function.Arguments
+				var parsed interface{}
+				if err := json.Unmarshal([]byte(call.Function.Arguments), &parsed); err == nil {
+					args = parsed
+				}
+				body, _ := json.Marshal(map[string]interface{}{"name": call.Function.Name, "arguments": args})
+				parts = append(parts, toolCallOpenTag+"\n"+string(body)+"\n"+toolCallCloseTag)
+			}
+			result = append(result, ChatMessage{Role: "assistant", Content: strings.Join(parts, "\n")})
+
+		default:
+			result = append(result, msg)
+		}
+	}
+	return result
+}
+
+// newToolCallID 生成 call_ 开头的工具调用ID
+func newToolCallID() string {
+	return "call_" + strings.ReplaceAll(uuid.New().String(), "-", "")[:24]
+}
+
+// toolCallParser 从模型输出中解析工具调用块
+//
+// 可能是调用块开始标签前缀的尾部文本会被暂存，因此标签跨越多次增量时同样可以识别。
+// 无法解析的调用块按原文作为普通文本输出。
+type toolCallParser struct {
+	pending string
+	inCall  bool
+	calls   []ToolCall
+}
+
+func newToolCallParser() *toolCallParser {
+	return &toolCallParser{}
+}
+
+// push 写入一段增量文本，返回可以输出的普通文本以及新解析出的工具调用
+func (p *toolCallParser) push(text string) (string, []ToolCall) {
+	p.pending += text
+
+	var out strings.Builder
+	var calls []ToolCall
+	for {
+		if !p.inCall {
+			if idx := strings.Index(p.pending, toolCallOpenTag); idx >= 0 {
+				out.WriteString(p.pending[:idx])
+				p.pending = p.pending[idx+len(toolCallOpenTag):]
+				p.inCall = true
+				continue
+			}
+			hold := partialSuffix(p.pending, toolCallOpenTag)
+			out.WriteString(p.pending[:len(p.pending)-hold])
+			p.pending = p.pending[len(p.pending)-hold:]
+			break
+		}
+
+		idx := strings.Index(p.pending, toolCallCloseTag)
+		if idx < 0 {
+			break
+		}
+		body := p.pending[:idx]
+		p.pending = p.pending[idx+len(toolCallCloseTag):]
+		p.inCall = false
+
+		call, ok := parseToolCallBody(body)
+		if !ok {
+			out.WriteString(toolCallOpenTag + body + toolCallCloseTag)
+			continue
+		}
+		index := len(p.calls)
+		call.Index = &index
+		p.calls = append(p.calls, call)
+		calls = append(calls, call)
+	}
+	return out.String(), calls
+}
+
+// flush 返回暂存的文本，未闭合的调用块按原文输出
+func (p *toolCallParser) flush() string {
+	pending := p.pending
+	if p.inCall {
+		pending = toolCallOpenTag + pending
+	}
+	p.pending = ""
+	p.inCall = false
+	return pending
+}
+
+// parseToolCallBody 解析调用块中的 JSON
+func parseToolCallBody(body string) (ToolCall, bool) {
+	body = strings.TrimSpace(body)
+	body = strings.TrimPrefix(body, "```json")
+	body = strings.TrimPrefix(body, "```")
+	body = strings.TrimSuffix(body, "```")
+
+	var raw struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(body)), &raw); err != nil || raw.Name == "" {
+		return ToolCall{}, false
+	}
+
+	// arguments 可能是对象，也可能是已经序列化的字符串
+	args := "{}"
+	if len(raw.Arguments) > 0 {
+		var s string
+		if err := json.Unmarshal(raw.Arguments, &s); err == nil {
+			args = s
+		} else {
+			args = string(raw.Arguments)
+		}
+	}
+
+	return ToolCall{
+		ID:   newToolCallID(),
+		Type: "function",
+		Function: ToolCallFunction{
+			Name:      raw.Name,
+			Arguments: args,
+		},
+	}, true
+}
+
+// partialSuffix 返回 s 的尾部与 tag 前缀重合的最大长度
+func partialSuffix(s string, tag string) int {
+	for k := len(tag) - 1; k > 0; k-- {
+		if strings.HasSuffix(s, tag[:k]) {
+			return k
+		}
+	}
+	return 0
+}
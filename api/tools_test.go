@@ -0,0 +1,216 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/trae2api/pkg/traefake"
+)
+
+var weatherTools = []interface{}{
+	map[string]interface{}{
+		"type": "function",
+		"function": map[string]interface{}{
+			"name":       "get_weather",
+			"parameters": map[string]interface{}{"type": "object", "properties": map[string]interface{}{"city": map[string]interface{}{"type": "string"}}},
+		},
+	},
+}
+
+// chunkedReply 按给定的片段依次输出正文的脚本
+func chunkedReply(chunks ...string) traefake.Script {
+	var steps []traefake.Step
+	for _, chunk := range chunks {
+		steps = append(steps, traefake.Output(chunk, "", ""))
+	}
+	return traefake.Script{Steps: append(steps, traefake.Done("stop"))}
+}
+
+// streamToolCalls 汇总流式响应中的正文、工具调用增量与结束原因
+func streamToolCalls(t *testing.T, body string) (content string, calls []map[string]interface{}, finishReason interface{}) {
+	t.Helper()
+	var sb strings.Builder
+	for _, chunk := range chatChunks(t, body) {
+		choices, _ := chunk["choices"].([]interface{})
+		if len(choices) == 0 {
+			continue
+		}
+		choice := choices[0].(map[string]interface{})
+		if reason := choice["finish_reason"]; reason != nil {
+			finishReason = reason
+		}
+		delta, _ := choice["delta"].(map[string]interface{})
+		if s, ok := delta["content"].(string); ok {
+			sb.WriteString(s)
+		}
+		if deltaCalls, ok := delta["tool_calls"].([]interface{}); ok {
+			for _, call := range deltaCalls {
+				calls = append(calls, call.(map[string]interface{}))
+			}
+		}
+	}
+	return sb.String(), calls, finishReason
+}
+
+// checkCall 校验工具调用的函数名与参数
+func checkCall(t *testing.T, call map[string]interface{}, name string, args map[string]interface{}) {
+	t.Helper()
+	if call["type"] != "function" || !strings.HasPrefix(call["id"].(string), "call_") {
+		t.Errorf("type %v, id %v", call["type"], call["id"])
+	}
+	fn := call["function"].(map[string]interface{})
+	if fn["name"] != name {
+		t.Errorf("name = %v, want %s", fn["name"], name)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal([]byte(fn["arguments"].(string)), &got); err != nil || !reflect.DeepEqual(got, args) {
+		t.Errorf("arguments = %v, want %v", fn["arguments"], args)
+	}
+}
+
+func TestToolCallSplitAcrossChunks(t *testing.T) {
+	env := newTestEnv(t, traefake.Options{})
+	// 开始与结束标签都跨越多个增量
+	env.fake.SetDefault(chunkedReply(
+		"Let me check. <to", "ol_ca", "ll>\n{\"name\": \"get_wea",
+		"ther\", \"arguments\": {\"city\": \"Paris\"}}\n</tool", "_call>",
+	))
+	extra := map[string]interface{}{"tools": weatherTools}
+
+	w := env.chat(t, "gpt-4o", "weather in Paris?", extra)
+	if w.Code != http.StatusOK {
+		t.Fatalf("状态码 %d, body: %s", w.Code, w.Body.String())
+	}
+	choice := decodeJSON(t, w)["choices"].([]interface{})[0].(map[string]interface{})
+	if choice["finish_reason"] != "tool_calls" {
+		t.Errorf("finish_reason = %v", choice["finish_reason"])
+	}
+	message := choice["message"].(map[string]interface{})
+	if message["content"] != "Let me check." {
+		t.Errorf("content = %q", message["content"])
+	}
+	calls := message["tool_calls"].([]interface{})
+	if len(calls) != 1 {
+		t.Fatalf("tool_calls = %v", calls)
+	}
+	call := calls[0].(map[string]interface{})
+	if _, ok := call["index"]; ok {
+		t.Errorf("非流式响应的工具调用不应带 index")
+	}
+	checkCall(t, call, "get_weather", map[string]interface{}{"city": "Paris"})
+
+	extra["stream"] = true
+	w = env.chat(t, "gpt-4o", "weather in Paris?", extra)
+	content, streamCalls, finishReason := streamToolCalls(t, w.Body.String())
+	if content != "Let me check. " {
+		t.Errorf("content = %q", content)
+	}
+	if len(streamCalls) != 1 || streamCalls[0]["index"] != float64(0) {
+		t.Fatalf("tool_calls = %v", streamCalls)
+	}
+	checkCall(t, streamCalls[0], "get_weather", map[string]interface{}{"city": "Paris"})
+	if finishReason != "tool_calls" {
+		t.Errorf("finish_reason = %v", finishReason)
+	}
+}
+
+func TestToolCallMalformedJSON(t *testing.T) {
+	env := newTestEnv(t, traefake.Options{})
+	const reply = "Try this: <tool_call>{\"name\": \"get_weather\", arguments}</tool_call>"
+	env.fake.SetDefault(chunkedReply("Try this: <tool_call>{\"name\": ", "\"get_weather\", arguments}</tool_call>"))
+	extra := map[string]interface{}{"tools": weatherTools}
+
+	// 无法解析的调用块按原文作为正文输出
+	w := env.chat(t, "gpt-4o", "hi", extra)
+	choice := decodeJSON(t, w)["choices"].([]interface{})[0].(map[string]interface{})
+	message := choice["message"].(map[string]interface{})
+	if message["content"] != reply || message["tool_calls"] != nil {
+		t.Errorf("content %q, tool_calls %v", message["content"], message["tool_calls"])
+	}
+	if choice["finish_reason"] != "stop" {
+		t.Errorf("finish_reason = %v", choice["finish_reason"])
+	}
+
+	extra["stream"] = true
+	w = env.chat(t, "gpt-4o", "hi", extra)
+	content, calls, finishReason := streamToolCalls(t, w.Body.String())
+	if content != reply || len(calls) != 0 || finishReason != "stop" {
+		t.Errorf("content %q, tool_calls %v, finish_reason %v", content, calls, finishReason)
+	}
+}
+
+func TestToolCallMultiple(t *testing.T) {
+	env := newTestEnv(t, traefake.Options{})
+	env.fake.SetDefault(chunkedReply(
+		"<tool_call>\n{\"name\": \"get_weather\", \"arguments\": {\"city\": \"Paris\"}}\n</tool_call>\n",
+		"<tool_call>\n{\"name\": \"get_weather\", \"arguments\": \"{\\\"city\\\": \\\"Tokyo\\\"}\"}\n</tool_call>",
+	))
+	extra := map[string]interface{}{"tools": weatherTools, "stream": true}
+
+	w := env.chat(t, "gpt-4o", "weather in Paris and Tokyo?", extra)
+	content, calls, finishReason := streamToolCalls(t, w.Body.String())
+	if len(calls) != 2 {
+		t.Fatalf("tool_calls = %v", calls)
+	}
+	// 每个调用以递增的 index 单独输出，已序列化的字符串参数原样返回
+	for i, city := range []string{"Paris", "Tokyo"} {
+		if calls[i]["index"] != float64(i) {
+			t.Errorf("第 %d 个调用的 index 为 %v", i, calls[i]["index"])
+		}
+		checkCall(t, calls[i], "get_weather", map[string]interface{}{"city": city})
+	}
+	if calls[0]["id"] == calls[1]["id"] {
+		t.Errorf("工具调用ID重复: %v", calls[0]["id"])
+	}
+	if strings.TrimSpace(content) != "" {
+		t.Errorf("content = %q", content)
+	}
+	if finishReason != "tool_calls" {
+		t.Errorf("finish_reason = %v", finishReason)
+	}
+}
+
+func TestToolCallHistory(t *testing.T) {
+	env := newTestEnv(t, traefake.Options{})
+	env.fake.SetDefault(traefake.Reply("", "It is sunny in Paris and rainy in Tokyo.", "stop"))
+
+	body := map[string]interface{}{
+		"model": "gpt-4o",
+		"tools": weatherTools,
+		"messages": []interface{}{
+			map[string]interface{}{"role": "user", "content": "weather in Paris and Tokyo?"},
+			map[string]interface{}{
+				"role":    "assistant",
+				"content": nil,
+				"tool_calls": []interface{}{
+					map[string]interface{}{"id": "call_1", "type": "function", "function": map[string]interface{}{"name": "get_weather", "arguments": `{"city":"Paris"}`}},
+					map[string]interface{}{"id": "call_2", "type": "function", "function": map[string]interface{}{"name": "get_weather", "arguments": `{"city":"Tokyo"}`}},
+				},
+			},
+			map[string]interface{}{"role": "tool", "tool_call_id": "call_1", "name": "get_weather", "content": "sunny"},
+			map[string]interface{}{"role": "tool", "tool_call_id": "call_2", "content": "rainy"},
+		},
+	}
+	w := env.do(t, http.MethodPost, "/v1/chat/completions", body)
+	if w.Code != http.StatusOK {
+		t.Fatalf("状态码 %d, body: %s", w.Code, w.Body.String())
+	}
+	choice := decodeJSON(t, w)["choices"].([]interface{})[0].(map[string]interface{})
+	if choice["finish_reason"] != "stop" {
+		t.Errorf("finish_reason = %v", choice["finish_reason"])
+	}
+
+	// 历史中的工具调用与工具结果以调用块与结果块的形式发送给上游，连续的工具结果合并为一条
+	prompt := lastPrompt(t, env)
+	for _, want := range []string{
+		"<tool_call>\n{\"arguments\":{\"city\":\"Paris\"},\"name\":\"get_weather\"}\n</tool_call>\n<tool_call>\n{\"arguments\":{\"city\":\"Tokyo\"},\"name\":\"get_weather\"}\n</tool_call>",
+		"<tool_result id=\"call_1\" name=\"get_weather\">\nsunny\n</tool_result>\n<tool_result id=\"call_2\">\nrainy\n</tool_result>",
+	} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("上游对话缺少\n%s\n实际:\n%s", want, prompt)
+		}
+	}
+}
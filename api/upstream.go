@@ -0,0 +1,70 @@
+package api
+
+import (
+	"expvar"
+	"net/http"
+	"time"
+
+	"github.com/trae2api/config"
+	customhttp "github.com/trae2api/pkg/http"
+	"github.com/trae2api/pkg/upstream"
+	"github.com/trae2api/pkg/upstream/trae"
+)
+
+// traeUpstream 处理函数使用的上游对话服务
+var traeUpstream upstream.Upstream
+
+// upstreamHTTP 访问 Trae 各接口共用的连接池，每个主机使用独立的长连接
+var upstreamHTTP = customhttp.NewPool(upstreamClientOptions())
+
+// imageHTTP 下载用户图片使用的客户端，图片地址的主机不固定，不计入上游连接池统计
+var imageHTTP = newImageClient()
+
+func init() {
+	// 上游连接池统计，通过 /debug/vars 查看
+	expvar.Publish("upstream_http", expvar.Func(func() interface{} {
+		return upstreamHTTP.Stats()
+	}))
+}
+
+// newImageClient 创建下载图片的客户端，只允许连接公网地址
+func newImageClient() *http.Client {
+	opts := upstreamClientOptions()
+	opts.PublicOnly = true
+	return customhttp.NewClient(opts)
+}
+
+// upstreamClientOptions 按配置返回上游 HTTP 客户端参数
+func upstreamClientOptions() customhttp.ClientOptions {
+	defaults := customhttp.DefaultClientOptions()
+	seconds := func(value string, fallback time.Duration) time.Duration {
+		return time.Duration(atoiOr(value, int(fallback/time.Second))) * time.Second
+	}
+	return customhttp.ClientOptions{
+		DialTimeout:           seconds(config.UpstreamDialTimeout, defaults.DialTimeout),
+		TLSHandshakeTimeout:   seconds(config.UpstreamTLSHandshakeTimeout, defaults.TLSHandshakeTimeout),
+		ResponseHeaderTimeout: seconds(config.UpstreamResponseHeaderTimeout, defaults.ResponseHeaderTimeout),
+		IdleConnTimeout:       seconds(config.UpstreamIdleConnTimeout, defaults.IdleConnTimeout),
+		Timeout:               seconds(config.UpstreamClientTimeout, defaults.Timeout),
+		MaxConnsPerHost:       atoiOr(config.UpstreamMaxConnsPerHost, defaults.MaxConnsPerHost),
+		MaxIdleConnsPerHost:   atoiOr(config.UpstreamMaxIdleConnsPerHost, defaults.MaxIdleConnsPerHost),
+		EnableHTTP2:           config.UpstreamHTTP2 == "true",
+	}
+}
+
+// SetUpstream 设置处理函数使用的上游对话服务，需在启动服务前调用
+func SetUpstream(u upstream.Upstream) {
+	traeUpstream = u
+}
+
+// NewTraeUpstream 按当前配置创建 Trae 上游客户端
+func NewTraeUpstream() upstream.Upstream {
+	return trae.New(trae.Options{
+		BaseURL:    config.AppConfig.BaseURL,
+		TokenURL:   config.AppConfig.RefreshTokenURL,
+		ImageXURL:  config.AppConfig.GetFileIDURL,
+		UploadURL:  config.AppConfig.UploadFileURL,
+		SetHeaders: setRequestHeaders,
+		HTTPClient: upstreamHTTP.Client(),
+	})
+}
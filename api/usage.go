@@ -0,0 +1,86 @@
+package api
+
+import (
+	"fmt"
+
+	"github.com/trae2api/pkg/tokenizer"
+)
+
+const (
+	// 每条消息的格式开销（角色与分隔符）
+	tokensPerMessage = 3
+	// 回复开头的格式开销
+	tokensPerReply = 3
+)
+
+// tokenUsage 一次响应的 token 用量，CompletionTokens 包含 ReasoningTokens
+type tokenUsage struct {
+	PromptTokens     int
+	CompletionTokens int
+	ReasoningTokens  int
+}
+
+// add 累加另一次上游请求的用量
+func (u tokenUsage) add(other tokenUsage) tokenUsage {
+	return tokenUsage{
+		PromptTokens:     u.PromptTokens + other.PromptTokens,
+		CompletionTokens: u.CompletionTokens + other.CompletionTokens,
+		ReasoningTokens:  u.ReasoningTokens + other.ReasoningTokens,
+	}
+}
+
+func (u tokenUsage) total() int {
+	return u.PromptTokens + u.CompletionTokens
+}
+
+// openAI 返回 Chat Completions 与 Completions 格式的 usage
+func (u tokenUsage) openAI() map[string]interface{} {
+	return map[string]interface{}{
+		"prompt_tokens":     u.PromptTokens,
+		"completion_tokens": u.CompletionTokens,
+		"total_tokens":      u.total(),
+		"completion_tokens_details": map[string]interface{}{
+			"reasoning_tokens": u.ReasoningTokens,
+		},
+	}
+}
+
+// responses 返回 Responses API 格式的 usage
+func (u tokenUsage) responses() map[string]interface{} {
+	return map[string]interface{}{
+		"input_tokens":  u.PromptTokens,
+		"output_tokens": u.CompletionTokens,
+		"total_tokens":  u.total(),
+		"output_tokens_details": map[string]interface{}{
+			"reasoning_tokens": u.ReasoningTokens,
+		},
+	}
+}
+
+// anthropic 返回 Anthropic Messages 格式的 usage
+func (u tokenUsage) anthropic() map[string]interface{} {
+	return map[string]interface{}{
+		"input_tokens":  u.PromptTokens,
+		"output_tokens": u.CompletionTokens,
+	}
+}
+
+// countPromptTokens 统计发送给上游的消息占用的 token 数
+func countPromptTokens(model string, messages []ChatMessage) int {
+	tk := tokenizer.ForModel(model)
+	tokens := tokensPerReply
+	for _, msg := range messages {
+		tokens += tokensPerMessage + tk.Count(msg.Role) + tk.Count(fmt.Sprintf("%v", msg.Content))
+	}
+	return tokens
+}
+
+// countCompletionTokens 统计一次回复的用量，推理内容计入 completion 并单独列出
+func countCompletionTokens(model string, content string, reasoning string) tokenUsage {
+	tk := tokenizer.ForModel(model)
+	reasoningTokens := tk.Count(reasoning)
+	return tokenUsage{
+		CompletionTokens: tk.Count(content) + reasoningTokens,
+		ReasoningTokens:  reasoningTokens,
+	}
+}
@@ -0,0 +1,35 @@
+// traefake 独立运行的 Trae 模拟服务
+//
+// 将 BASE_URL 与 REFRESH_TOKEN_URL 指向该服务即可在本地调试，
+// 在用户输入中包含 [fake:queue]、[fake:reasoning]、[fake:error-429] 等触发词可获得对应的脚本化响应。
+package main
+
+import (
+	"flag"
+	"net/http"
+	"time"
+
+	"github.com/trae2api/pkg/logger"
+	"github.com/trae2api/pkg/traefake"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:18080", "监听地址")
+	refreshToken := flag.String("refresh-token", "", "初始有效的 RefreshToken，为空时接受任意 RefreshToken")
+	checkToken := flag.Bool("check-token", false, "校验请求头 x-ide-token 是否为已签发的 Token")
+	interval := flag.Duration("interval", 20*time.Millisecond, "事件之间的间隔")
+	flag.Parse()
+
+	logger.Init()
+
+	server := traefake.New(traefake.Options{
+		RefreshToken: *refreshToken,
+		CheckToken:   *checkToken,
+		StepInterval: *interval,
+	})
+
+	logger.Log.Infof("Trae 模拟服务启动成功，监听地址: %s", *addr)
+	if err := http.ListenAndServe(*addr, server); err != nil {
+		logger.Log.Fatalf("启动服务失败: %v", err)
+	}
+}
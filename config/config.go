@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/trae2api/pkg/logger"
+	"github.com/trae2api/pkg/upstream"
 )
 
 type Config struct {
@@ -23,6 +24,87 @@ type Config struct {
 var RefreshTokenCacheEnabled = getEnv("REFRESH_TOKEN_CACHE_ENABLED", "false")
 var AutoContinueEnabled = getEnv("AUTO_CONTINUE_ENABLED", "false")
 
+// AutoContinueModels 逗号分隔的模型ID、别名或 Trae 模型名，输出因长度截断时自动继续，* 表示所有模型
+var AutoContinueModels = getEnv("AUTO_CONTINUE_MODELS", "claude-3-7-sonnet")
+
+// AutoContinuePrompt 自动继续时发送的用户消息
+var AutoContinuePrompt = getEnv("AUTO_CONTINUE_PROMPT", "请从上次中断的地方直接继续输出，不要重复已经输出的内容。")
+
+// AutoContinueMaxRounds 单次响应最多自动继续的轮数
+var AutoContinueMaxRounds = getEnv("AUTO_CONTINUE_MAX_ROUNDS", "3")
+
+// AutoContinueMaxChars 所有轮次正文的总长度（字符数）达到该值后不再继续，0 表示不限制
+var AutoContinueMaxChars = getEnv("AUTO_CONTINUE_MAX_CHARS", "0")
+
+// SystemPromptMode 系统提示词的放置方式: history 作为对话历史的第一条消息, user 拼接在本轮用户输入之前
+var SystemPromptMode = getEnv("SYSTEM_PROMPT_MODE", "history")
+
+// SystemPromptUserModels 逗号分隔的模型ID、别名或 Trae 模型名，这些模型始终将系统提示词拼接在用户输入之前
+var SystemPromptUserModels = getEnv("SYSTEM_PROMPT_USER_MODELS", "")
+
+// JSONModeMaxRetries 结构化输出校验失败后重新请求上游的最大次数
+var JSONModeMaxRetries = getEnv("JSON_MODE_MAX_RETRIES", "2")
+
+// ReasoningFormat 推理内容的默认输出方式: reasoning_content、think 或 none
+var ReasoningFormat = getEnv("REASONING_FORMAT", "think")
+
+// AuthTokens 除 AUTH_TOKEN 外允许访问的 API Key，多个以逗号分隔
+var AuthTokens = getEnv("AUTH_TOKENS", "")
+
+// DebugVars 未配置鉴权时是否仍然提供 /debug/vars 运行指标，设为 1 开启
+var DebugVars = getEnv("DEBUG_VARS", "0")
+
+// ReasoningFormatKeys 按 API Key 指定推理输出方式，格式为 key:format，多个以逗号分隔，只对通过鉴权的 Key 生效
+var ReasoningFormatKeys = getEnv("REASONING_FORMAT_KEYS", "")
+
+// QueueMaxRetries 上游返回排队状态时重新发起请求的最大次数
+var QueueMaxRetries = getEnv("QUEUE_MAX_RETRIES", "3")
+
+// QueueRetryInterval 排队后第一次重试前的等待时间（秒）
+var QueueRetryInterval = getEnv("QUEUE_RETRY_INTERVAL", "3")
+
+// QueueRetryBackoff 每次重试后等待时间的倍数
+var QueueRetryBackoff = getEnv("QUEUE_RETRY_BACKOFF", "2")
+
+// QueueMaxWait 排队的最长等待时间（秒），超过后返回 429，0 表示不限制
+var QueueMaxWait = getEnv("QUEUE_MAX_WAIT", "120")
+
+// ImageURLFetchEnabled 是否允许下载请求中 http(s) 地址的图片，关闭时只接受 data URI
+var ImageURLFetchEnabled = getEnv("IMAGE_URL_FETCH_ENABLED", "false")
+
+// UpstreamRequestTimeout 单次对话请求（含排队与自动继续）的最长处理时间（秒），0 表示不限制
+var UpstreamRequestTimeout = getEnv("UPSTREAM_REQUEST_TIMEOUT", "1800")
+
+// UpstreamDialTimeout 连接上游时建立 TCP 连接的超时时间（秒）
+var UpstreamDialTimeout = getEnv("UPSTREAM_DIAL_TIMEOUT", "30")
+
+// UpstreamTLSHandshakeTimeout 连接上游时 TLS 握手的超时时间（秒）
+var UpstreamTLSHandshakeTimeout = getEnv("UPSTREAM_TLS_HANDSHAKE_TIMEOUT", "10")
+
+// UpstreamResponseHeaderTimeout 等待上游响应头的超时时间（秒）
+var UpstreamResponseHeaderTimeout = getEnv("UPSTREAM_RESPONSE_HEADER_TIMEOUT", "1200")
+
+// UpstreamIdleConnTimeout 上游空闲连接保留的时间（秒）
+var UpstreamIdleConnTimeout = getEnv("UPSTREAM_IDLE_CONN_TIMEOUT", "2400")
+
+// UpstreamClientTimeout 单个上游 HTTP 请求（含读取响应体）的总超时时间（秒），0 表示不限制
+var UpstreamClientTimeout = getEnv("UPSTREAM_CLIENT_TIMEOUT", "1800")
+
+// UpstreamMaxConnsPerHost 每个上游主机的最大连接数，0 表示不限制
+var UpstreamMaxConnsPerHost = getEnv("UPSTREAM_MAX_CONNS_PER_HOST", "0")
+
+// UpstreamMaxIdleConnsPerHost 每个上游主机保留的最大空闲连接数
+var UpstreamMaxIdleConnsPerHost = getEnv("UPSTREAM_MAX_IDLE_CONNS_PER_HOST", "16")
+
+// UpstreamHTTP2 是否允许与上游使用 HTTP/2
+var UpstreamHTTP2 = getEnv("UPSTREAM_HTTP2", "false")
+
+// MaxChoices 单次请求参数 n 允许的最大值，每个候选对应一次并发的上游请求
+var MaxChoices = getEnv("MAX_CHOICES", "4")
+
+// ResponseStoreMaxEntries 为 previous_response_id 保留的响应数上限，超过后淘汰最久未使用的响应
+var ResponseStoreMaxEntries = getEnv("RESPONSE_STORE_MAX_ENTRIES", "1000")
+
 var AppConfig Config
 
 func InitConfig() error {
@@ -49,16 +131,41 @@ func InitConfig() error {
 		}
 	}
 
-	// 打印是否开启claude3.7自动发起继续对话
-	logger.Log.Info("当前是否开启claude3.7自动继续请求: " + AutoContinueEnabled)
+	// 打印是否开启自动发起继续对话
+	logger.Log.Info("当前是否开启自动继续请求: " + AutoContinueEnabled + ", 模型: " + AutoContinueModels)
+
+	// 打印系统提示词放置方式
+	logger.Log.Info("系统提示词放置方式: " + SystemPromptMode)
+
+	// 加载模型注册表
+	if err := initModels(); err != nil {
+		return fmt.Errorf("load model registry failed: %v", err)
+	}
 
+	logger.Log.Info("Trae2Api配置加载完成:\n" +
+		"----------------------------------------\n" +
+		"AppID:        " + AppConfig.AppID + "\n" +
+		"ClientID:     " + AppConfig.ClientID + "\n" +
+		"UserID:       " + AppConfig.UserID + "\n" +
+		"RefreshToken: " + AppConfig.RefreshToken + "\n" +
+		"BaseURL:      " + AppConfig.BaseURL + "\n" +
+		"RefreshTokenURL: " + AppConfig.RefreshTokenURL + "\n" +
+		"GetFileIDURL: " + AppConfig.GetFileIDURL + "\n" +
+		"UploadFileURL: " + AppConfig.UploadFileURL + "\n" +
+		"AuthToken:    " + AppConfig.AuthToken + "\n" +
+		"----------------------------------------")
+
+	return nil
+}
+
+// StartTokenRefresh 获取 Token 并启动定期刷新 Token 的 goroutine
+func StartTokenRefresh(exchanger upstream.Upstream) error {
 	// 是否为开发调试模式
 	codingMode := os.Getenv("CODING_MODE") == "true"
 	codingToken := os.Getenv("CODING_TOKEN")
 
-	refreshTokenUrl := AppConfig.RefreshTokenURL
 	// 初始化获取 Token
-	if err := RefreshIDEToken(refreshTokenUrl, codingMode, codingToken); err != nil {
+	if err := RefreshIDEToken(exchanger, codingMode, codingToken); err != nil {
 		return fmt.Errorf("initial token refresh failed: %v", err)
 	}
 
@@ -66,25 +173,12 @@ func InitConfig() error {
 	go func() {
 		ticker := time.NewTicker(5 * time.Minute)
 		for range ticker.C {
-			if err := RefreshIDEToken(refreshTokenUrl, codingMode, codingToken); err != nil {
+			if err := RefreshIDEToken(exchanger, codingMode, codingToken); err != nil {
 				logger.Log.Errorf("自动刷新 Token 失败: %v", err)
 			}
 		}
 	}()
 
-	logger.Log.Info("Trae2Api配置加载完成:\n" +
-		"----------------------------------------\n" +
-		"AppID:        " + AppConfig.AppID + "\n" +
-		"ClientID:     " + AppConfig.ClientID + "\n" +
-		"UserID:       " + AppConfig.UserID + "\n" +
-		"RefreshToken: " + AppConfig.RefreshToken + "\n" +
-		"BaseURL:      " + AppConfig.BaseURL + "\n" +
-		"RefreshTokenURL: " + AppConfig.RefreshTokenURL + "\n" +
-		"GetFileIDURL: " + AppConfig.GetFileIDURL + "\n" +
-		"UploadFileURL: " + AppConfig.UploadFileURL + "\n" +
-		"AuthToken:    " + AppConfig.AuthToken + "\n" +
-		"----------------------------------------")
-
 	return nil
 }
 
@@ -0,0 +1,235 @@
+package config
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/trae2api/pkg/logger"
+)
+
+// ModelsConfigFile 模型注册表配置文件路径，未配置时使用内置的默认模型列表
+var ModelsConfigFile = getEnv("MODELS_CONFIG_FILE", "")
+
+// ModelsReloadInterval 检查模型配置文件是否变更的间隔（秒），0 表示不自动重新加载
+var ModelsReloadInterval = getEnv("MODELS_RELOAD_INTERVAL", "30")
+
+// ModelCatalogSyncInterval 后台同步 Trae 模型目录的间隔（秒），0 表示不同步
+var ModelCatalogSyncInterval = getEnv("MODEL_CATALOG_SYNC_INTERVAL", "300")
+
+//go:embed models.default.json
+var defaultModelsConfig []byte
+
+// 注册表中未配置 created 时使用的固定时间（2024-01-01）
+const defaultModelCreated int64 = 1704067200
+
+// ModelCapabilities 模型能力
+type ModelCapabilities struct {
+	// 是否支持图片输入
+	Multimodal bool `json:"multimodal"`
+	// 是否输出推理内容
+	Reasoning bool `json:"reasoning"`
+	// 上下文长度（token 数），0 表示未知
+	ContextLength int `json:"context_length"`
+}
+
+// ModelEntry 模型注册表中的一个模型
+type ModelEntry struct {
+	// 对外展示的模型ID
+	ID string `json:"id"`
+	// 发送给 Trae 的模型名，为空时与 ID 相同
+	Upstream    string   `json:"upstream"`
+	DisplayName string   `json:"display_name"`
+	Aliases     []string `json:"aliases"`
+	// 模型发布时间（Unix 秒），作为 /v1/models 中固定的 created
+	Created int64 `json:"created"`
+
+	Capabilities ModelCapabilities `json:"capabilities"`
+	// 未配置时默认启用
+	Enabled *bool `json:"enabled"`
+}
+
+// IsEnabled 模型是否启用
+func (m *ModelEntry) IsEnabled() bool {
+	return m.Enabled == nil || *m.Enabled
+}
+
+// ModelRegistry 模型注册表，加载后只读
+type ModelRegistry struct {
+	models []ModelEntry
+	// 小写的模型ID、别名与上游模型名 -> models 中的序号，仅包含启用的模型
+	index map[string]int
+}
+
+type modelsFile struct {
+	Models []ModelEntry `json:"models"`
+}
+
+var (
+	modelRegistryMutex sync.RWMutex
+	modelRegistry      *ModelRegistry
+	// 内置配置解析后的注册表
+	defaultModelRegistry *ModelRegistry
+)
+
+func init() {
+	registry, err := ParseModelRegistry(defaultModelsConfig)
+	if err != nil {
+		panic(fmt.Sprintf("内置模型配置无效: %v", err))
+	}
+	defaultModelRegistry = registry
+}
+
+// ParseModelRegistry 解析模型注册表配置，未知字段视为配置错误
+func ParseModelRegistry(data []byte) (*ModelRegistry, error) {
+	var file modelsFile
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&file); err != nil {
+		return nil, fmt.Errorf("解析模型配置失败: %v", err)
+	}
+	if len(file.Models) == 0 {
+		return nil, fmt.Errorf("模型配置中没有模型")
+	}
+
+	registry := &ModelRegistry{
+		models: make([]ModelEntry, 0, len(file.Models)),
+		index:  make(map[string]int),
+	}
+	ids := make(map[string]bool)
+	for _, m := range file.Models {
+		m.ID = strings.TrimSpace(m.ID)
+		if m.ID == "" {
+			return nil, fmt.Errorf("模型配置缺少 id")
+		}
+		if ids[strings.ToLower(m.ID)] {
+			return nil, fmt.Errorf("模型 %s 重复配置", m.ID)
+		}
+		ids[strings.ToLower(m.ID)] = true
+		if m.Upstream == "" {
+			m.Upstream = m.ID
+		}
+		if m.DisplayName == "" {
+			m.DisplayName = m.ID
+		}
+		if m.Created <= 0 {
+			m.Created = defaultModelCreated
+		}
+
+		registry.models = append(registry.models, m)
+		if !m.IsEnabled() {
+			continue
+		}
+		pos := len(registry.models) - 1
+		for _, name := range append([]string{m.ID, m.Upstream}, m.Aliases...) {
+			key := strings.ToLower(strings.TrimSpace(name))
+			if key == "" {
+				continue
+			}
+			if other, ok := registry.index[key]; ok && other != pos {
+				return nil, fmt.Errorf("模型名 %s 同时属于 %s 和 %s", name, registry.models[other].ID, m.ID)
+			}
+			registry.index[key] = pos
+		}
+	}
+	return registry, nil
+}
+
+// Lookup 按模型ID、别名或上游模型名查找启用的模型，不区分大小写
+func (r *ModelRegistry) Lookup(name string) (*ModelEntry, bool) {
+	pos, ok := r.index[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return nil, false
+	}
+	return &r.models[pos], true
+}
+
+// List 返回所有启用的模型，顺序与配置一致
+func (r *ModelRegistry) List() []ModelEntry {
+	models := make([]ModelEntry, 0, len(r.models))
+	for _, m := range r.models {
+		if m.IsEnabled() {
+			models = append(models, m)
+		}
+	}
+	return models
+}
+
+// Models 返回当前生效的模型注册表
+func Models() *ModelRegistry {
+	modelRegistryMutex.RLock()
+	registry := modelRegistry
+	modelRegistryMutex.RUnlock()
+	if registry != nil {
+		return registry
+	}
+	// 尚未初始化时使用内置配置
+	return defaultModelRegistry
+}
+
+// ReloadModels 重新加载模型注册表，加载失败时保留当前生效的配置
+func ReloadModels() error {
+	registry := defaultModelRegistry
+	if ModelsConfigFile != "" {
+		data, err := os.ReadFile(ModelsConfigFile)
+		if err != nil {
+			return fmt.Errorf("读取模型配置文件失败: %v", err)
+		}
+		if registry, err = ParseModelRegistry(data); err != nil {
+			return err
+		}
+	}
+
+	modelRegistryMutex.Lock()
+	modelRegistry = registry
+	modelRegistryMutex.Unlock()
+
+	logger.Log.Infof("模型注册表加载完成，共 %d 个启用的模型", len(registry.List()))
+	return nil
+}
+
+// initModels 加载模型注册表，并在配置文件变更时自动重新加载
+func initModels() error {
+	var lastModTime time.Time
+	if info, err := os.Stat(ModelsConfigFile); err == nil {
+		lastModTime = info.ModTime()
+	}
+	if err := ReloadModels(); err != nil {
+		return err
+	}
+	if ModelsConfigFile == "" {
+		logger.Log.Info("未配置 MODELS_CONFIG_FILE，使用内置模型列表")
+		return nil
+	}
+
+	seconds, err := strconv.Atoi(ModelsReloadInterval)
+	if err != nil || seconds <= 0 {
+		return nil
+	}
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(seconds) * time.Second)
+		for range ticker.C {
+			info, err := os.Stat(ModelsConfigFile)
+			if err != nil {
+				logger.Log.Errorf("检查模型配置文件失败: %v", err)
+				continue
+			}
+			if info.ModTime().Equal(lastModTime) {
+				continue
+			}
+			lastModTime = info.ModTime()
+
+			if err := ReloadModels(); err != nil {
+				logger.Log.Errorf("重新加载模型配置失败，继续使用当前配置: %v", err)
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,156 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestParseModelRegistry(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{name: "有效配置", data: `{"models":[{"id":"gpt-4o","aliases":["4o"]},{"id":"claude","enabled":false}]}`},
+		{name: "空文件", data: ``, wantErr: "解析模型配置失败"},
+		{name: "没有模型", data: `{"models":[]}`, wantErr: "没有模型"},
+		{name: "未知字段", data: `{"models":[{"id":"gpt-4o","alias":["4o"]}]}`, wantErr: "unknown field"},
+		{name: "缺少 id", data: `{"models":[{"upstream":"gpt-4o"}]}`, wantErr: "缺少 id"},
+		{name: "ID 重复", data: `{"models":[{"id":"gpt-4o"},{"id":"GPT-4o"}]}`, wantErr: "重复配置"},
+		{name: "别名重复", data: `{"models":[{"id":"a","aliases":["x"]},{"id":"b","aliases":["X"]}]}`, wantErr: "同时属于 a 和 b"},
+		{name: "别名与其他模型 ID 相同", data: `{"models":[{"id":"a"},{"id":"b","aliases":["a"]}]}`, wantErr: "同时属于 a 和 b"},
+		// 禁用的模型不占用模型名
+		{name: "别名与禁用的模型重复", data: `{"models":[{"id":"a","aliases":["x"],"enabled":false},{"id":"b","aliases":["x"]}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry, err := ParseModelRegistry([]byte(tt.data))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("解析失败: %v", err)
+				}
+				if len(registry.List()) == 0 {
+					t.Errorf("没有启用的模型")
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err = %v, 期望包含 %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReloadModelsKeepsCurrentOnError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "models.json")
+	setModelsConfigFile(t, path)
+
+	if err := os.WriteFile(path, []byte(`{"models":[{"id":"custom","aliases":["c"]}]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ReloadModels(); err != nil {
+		t.Fatalf("加载失败: %v", err)
+	}
+	if m, ok := Models().Lookup("C"); !ok || m.ID != "custom" {
+		t.Fatalf("Lookup(C) = %v, %v", m, ok)
+	}
+
+	// 空文件加载失败，继续使用当前配置
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ReloadModels(); err == nil {
+		t.Fatalf("空文件应加载失败")
+	}
+	if _, ok := Models().Lookup("custom"); !ok {
+		t.Errorf("加载失败后配置被替换")
+	}
+}
+
+func TestReloadModelsConcurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "models.json")
+	setModelsConfigFile(t, path)
+	configs := []string{
+		`{"models":[{"id":"a","aliases":["shared"]}]}`,
+		`{"models":[{"id":"b","aliases":["shared"]}]}`,
+	}
+	if err := os.WriteFile(path, []byte(configs[0]), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ReloadModels(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 重新加载与读取同时进行，读取方总能拿到完整的注册表（使用 -race 运行）
+	var wg sync.WaitGroup
+	var writeMutex sync.Mutex
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				writeMutex.Lock()
+				err := os.WriteFile(path, []byte(configs[(i+j)%2]), 0o644)
+				if err == nil {
+					err = ReloadModels()
+				}
+				writeMutex.Unlock()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				registry := Models()
+				m, ok := registry.Lookup("shared")
+				if !ok || (m.ID != "a" && m.ID != "b") || len(registry.List()) != 1 {
+					t.Errorf("Lookup(shared) = %v, %v", m, ok)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestModelsDefault(t *testing.T) {
+	modelRegistryMutex.Lock()
+	saved := modelRegistry
+	modelRegistry = nil
+	modelRegistryMutex.Unlock()
+	t.Cleanup(func() {
+		modelRegistryMutex.Lock()
+		modelRegistry = saved
+		modelRegistryMutex.Unlock()
+	})
+
+	// 尚未加载时返回启动时解析好的内置配置，不重复解析
+	if Models() != defaultModelRegistry || Models() != Models() {
+		t.Errorf("未加载时应返回同一个内置注册表")
+	}
+	if len(Models().List()) == 0 {
+		t.Errorf("内置配置没有启用的模型")
+	}
+}
+
+// setModelsConfigFile 在测试期间使用指定的模型配置文件，结束后恢复原有配置
+func setModelsConfigFile(t *testing.T, path string) {
+	t.Helper()
+	savedFile := ModelsConfigFile
+	modelRegistryMutex.Lock()
+	savedRegistry := modelRegistry
+	modelRegistryMutex.Unlock()
+	ModelsConfigFile = path
+	t.Cleanup(func() {
+		ModelsConfigFile = savedFile
+		modelRegistryMutex.Lock()
+		modelRegistry = savedRegistry
+		modelRegistryMutex.Unlock()
+	})
+}
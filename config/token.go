@@ -1,36 +1,18 @@
 package config
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
 	"github.com/go-redis/redis/v8"
-	"io"
-	"net/http"
 	"os"
 	"sync"
 	"time"
 
 	"github.com/trae2api/pkg/logger"
+	"github.com/trae2api/pkg/upstream"
 )
 
-type TokenConfig struct {
-	ClientID     string `json:"ClientID"`
-	RefreshToken string `json:"RefreshToken"`
-	ClientSecret string `json:"ClientSecret"`
-	UserID       string `json:"UserID"`
-}
-
-type TokenResponse struct {
-	Result struct {
-		Token           string `json:"Token"`
-		TokenExpireAt   int64  `json:"TokenExpireAt"`
-		RefreshToken    string `json:"RefreshToken"`
-		RefreshExpireAt int64  `json:"RefreshExpireAt"`
-	} `json:"Result"`
-}
-
 var (
 	tokenMutex      sync.RWMutex
 	currentToken    string
@@ -39,7 +21,22 @@ var (
 	refreshToken    string
 )
 
-func RefreshIDEToken(baseURL string, codingMode bool, codingToken string) error {
+// tokenExchangeTimeout 单次换取 Token 的超时时间，刷新期间持有 tokenMutex，不能无限等待
+var tokenExchangeTimeout = 30 * time.Second
+
+// exchangeToken 使用 RefreshToken 换取 Token，超过 tokenExchangeTimeout 后放弃
+func exchangeToken(exchanger upstream.Upstream, refreshToken string) (*upstream.TokenResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), tokenExchangeTimeout)
+	defer cancel()
+	return exchanger.ExchangeToken(ctx, &upstream.TokenRequest{
+		ClientID:     os.Getenv("CLIENT_ID"),
+		RefreshToken: refreshToken,
+		ClientSecret: "-",
+		UserID:       os.Getenv("USER_ID"),
+	})
+}
+
+func RefreshIDEToken(exchanger upstream.Upstream, codingMode bool, codingToken string) error {
 	tokenMutex.Lock()
 	defer tokenMutex.Unlock()
 
@@ -85,45 +82,13 @@ func RefreshIDEToken(baseURL string, codingMode bool, codingToken string) error
 	}
 
 	// 请求新的 Refresh Token
-	refreshConfig := TokenConfig{
-		ClientID:     os.Getenv("CLIENT_ID"),
-		RefreshToken: currentRefreshToken,
-		ClientSecret: "-",
-		UserID:       os.Getenv("USER_ID"),
-	}
-
-	jsonData, err := json.Marshal(refreshConfig)
-	if err != nil {
-		return fmt.Errorf("marshal refresh config failed: %v", err)
-	}
-
 	logger.Log.Info("开始执行RefreshToken获取......")
 
-	resp, err := http.Post(baseURL+"/cloudide/api/v3/trae/oauth/ExchangeToken",
-		"application/json",
-		bytes.NewBuffer(jsonData),
-	)
+	refreshResp, err := exchangeToken(exchanger, currentRefreshToken)
 	if err != nil {
 		logger.Log.Error("请求RefreshToken刷新失败: " + err.Error())
 		return fmt.Errorf("refresh token request failed: %v", err)
 	}
-	defer resp.Body.Close()
-
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("read response body failed: %v", err)
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		logger.Log.Error(fmt.Sprintf("请求失败\n状态码: %d\n响应内容:\n%s", resp.StatusCode, string(respBody)))
-		return fmt.Errorf("request failed with status code: %d", resp.StatusCode)
-	}
-
-	var refreshResp TokenResponse
-	if err := json.NewDecoder(bytes.NewReader(respBody)).Decode(&refreshResp); err != nil {
-		logger.Log.Error("解析 RefreshToken 响应失败: " + err.Error())
-		return fmt.Errorf("decode refresh response failed: %v", err)
-	}
 
 	// 将新的refreshToken保存到内存中
 	refreshToken = refreshResp.Result.RefreshToken
@@ -131,44 +96,18 @@ func RefreshIDEToken(baseURL string, codingMode bool, codingToken string) error
 	logger.Log.Info("获取到新的 RefreshToken: " + refreshToken + "\n")
 
 	// 使用新的 RefreshToken 刷新 Token
-	tokenConfig := TokenConfig{
-		ClientID:     os.Getenv("CLIENT_ID"),
-		RefreshToken: refreshToken,
-		ClientSecret: "-",
-		UserID:       os.Getenv("USER_ID"),
-	}
-
-	jsonData, err = json.Marshal(tokenConfig)
-	if err != nil {
-		return fmt.Errorf("marshal token config failed: %v", err)
-	}
-
 	logger.Log.Info("开始执行Token获取......")
 
-	resp, err = http.Post(baseURL+"/cloudide/api/v3/trae/oauth/ExchangeToken",
-		"application/json",
-		bytes.NewBuffer(jsonData),
-	)
+	tokenResp, err := exchangeToken(exchanger, refreshToken)
 	if err != nil {
+		logger.Log.Error("请求Token刷新失败: " + err.Error())
 		return fmt.Errorf("refresh token request failed: %v", err)
 	}
-	defer resp.Body.Close()
-
-	respBody, err = io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("read response body failed: %v", err)
-	}
 
-	if resp.StatusCode != http.StatusOK {
-		logger.Log.Error(fmt.Sprintf("请求失败\n状态码: %d\n响应内容:\n%s", resp.StatusCode, string(respBody)))
-		return fmt.Errorf("request failed with status code: %d", resp.StatusCode)
+	// 换取 Token 时使用过的 RefreshToken 可能已失效，保存本次返回的最新 RefreshToken
+	if tokenResp.Result.RefreshToken != "" {
+		refreshToken = tokenResp.Result.RefreshToken
 	}
-
-	var tokenResp TokenResponse
-	if err := json.NewDecoder(bytes.NewReader(respBody)).Decode(&tokenResp); err != nil {
-		return fmt.Errorf("decode token response failed: %v", err)
-	}
-
 	currentToken = tokenResp.Result.Token
 	tokenExpireAt = tokenResp.Result.TokenExpireAt
 	refreshExpireAt = tokenResp.Result.RefreshExpireAt
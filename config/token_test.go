@@ -0,0 +1,183 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/trae2api/pkg/logger"
+	"github.com/trae2api/pkg/traefake"
+	"github.com/trae2api/pkg/upstream"
+	"github.com/trae2api/pkg/upstream/trae"
+)
+
+func TestMain(m *testing.M) {
+	logger.Init()
+	logger.Log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// newTokenTestClient 启动 Trae 模拟服务，清空内存中的 Token 状态，返回指向模拟服务的客户端
+func newTokenTestClient(t *testing.T, opts traefake.Options) *trae.Client {
+	t.Helper()
+
+	server := httptest.NewServer(traefake.New(opts))
+	t.Cleanup(server.Close)
+
+	resetTokenState := func() {
+		tokenMutex.Lock()
+		defer tokenMutex.Unlock()
+		currentToken, refreshToken = "", ""
+		tokenExpireAt, refreshExpireAt = 0, 0
+	}
+	resetTokenState()
+	t.Cleanup(resetTokenState)
+
+	t.Setenv("REFRESH_TOKEN", "init-rt")
+	t.Setenv("CLIENT_ID", "client")
+	t.Setenv("USER_ID", "user")
+	saved := RefreshTokenCacheEnabled
+	RefreshTokenCacheEnabled = "false"
+	t.Cleanup(func() { RefreshTokenCacheEnabled = saved })
+
+	client := trae.New(trae.Options{
+		BaseURL:  server.URL,
+		TokenURL: server.URL,
+		SetHeaders: func(req *http.Request) {
+			req.Header.Set("x-ide-token", GetCurrentToken())
+		},
+	})
+	return client
+}
+
+// expireToken 将当前 Token 标记为已过期，下次调用 RefreshIDEToken 时刷新
+func expireToken() {
+	tokenMutex.Lock()
+	defer tokenMutex.Unlock()
+	tokenExpireAt = time.Now().UnixMilli()
+}
+
+func TestRefreshIDETokenRotation(t *testing.T) {
+	client := newTokenTestClient(t, traefake.Options{RefreshToken: "init-rt", CheckToken: true})
+
+	// 刷新前没有可用的 Token
+	if _, err := client.ListModels(context.Background()); err == nil {
+		t.Fatalf("未刷新 Token 时模型列表请求应失败")
+	}
+
+	// 第一次换取得到新的 RefreshToken，再用它换取 Token
+	if err := RefreshIDEToken(client, false, ""); err != nil {
+		t.Fatalf("刷新 Token 失败: %v", err)
+	}
+	if currentToken != "fake-token-2" || refreshToken != "fake-refresh-token-2" {
+		t.Errorf("token %q, refreshToken %q", currentToken, refreshToken)
+	}
+	if IsRefreshTokenExpired() {
+		t.Errorf("RefreshToken 不应过期")
+	}
+	if _, err := client.ListModels(context.Background()); err != nil {
+		t.Errorf("使用刷新后的 Token 请求模型列表失败: %v", err)
+	}
+
+	// Token 仍然有效时不再刷新
+	if err := RefreshIDEToken(client, false, ""); err != nil {
+		t.Fatalf("刷新 Token 失败: %v", err)
+	}
+	if currentToken != "fake-token-2" {
+		t.Errorf("Token 未过期时不应刷新, token %q", currentToken)
+	}
+
+	// Token 过期后使用内存中的 RefreshToken 而不是已失效的环境变量
+	expireToken()
+	if err := RefreshIDEToken(client, false, ""); err != nil {
+		t.Fatalf("再次刷新 Token 失败: %v", err)
+	}
+	if currentToken != "fake-token-4" || refreshToken != "fake-refresh-token-4" {
+		t.Errorf("token %q, refreshToken %q", currentToken, refreshToken)
+	}
+	if _, err := client.ListModels(context.Background()); err != nil {
+		t.Errorf("使用轮换后的 Token 请求模型列表失败: %v", err)
+	}
+}
+
+func TestRefreshIDETokenInvalidRefreshToken(t *testing.T) {
+	client := newTokenTestClient(t, traefake.Options{RefreshToken: "other-rt"})
+
+	if err := RefreshIDEToken(client, false, ""); err == nil {
+		t.Fatalf("RefreshToken 无效时应返回错误")
+	}
+	if currentToken != "" {
+		t.Errorf("刷新失败时不应设置 Token, token %q", currentToken)
+	}
+}
+
+func TestRefreshIDETokenExpiredRefreshToken(t *testing.T) {
+	client := newTokenTestClient(t, traefake.Options{})
+
+	tokenMutex.Lock()
+	refreshExpireAt = time.Now().Add(-time.Minute).UnixMilli()
+	tokenMutex.Unlock()
+
+	// RefreshToken 已过期时不再请求换取，由模型列表接口提示更新
+	if err := RefreshIDEToken(client, false, ""); err != nil {
+		t.Fatalf("RefreshToken 过期时不应返回错误: %v", err)
+	}
+	if currentToken != "" {
+		t.Errorf("RefreshToken 过期时不应刷新, token %q", currentToken)
+	}
+	if !IsRefreshTokenExpired() {
+		t.Errorf("IsRefreshTokenExpired 应为 true")
+	}
+}
+
+func TestRefreshIDETokenCodingMode(t *testing.T) {
+	client := newTokenTestClient(t, traefake.Options{RefreshToken: "other-rt"})
+
+	// Coding 模式直接使用预设的 Token，不请求换取
+	if err := RefreshIDEToken(client, true, "coding-token"); err != nil {
+		t.Fatalf("Coding 模式返回错误: %v", err)
+	}
+	if GetCurrentToken() != "coding-token" {
+		t.Errorf("token %q, want coding-token", GetCurrentToken())
+	}
+}
+
+// blockingExchanger 换取 Token 时一直等待到 ctx 结束
+type blockingExchanger struct {
+	upstream.Upstream
+}
+
+func (blockingExchanger) ExchangeToken(ctx context.Context, req *upstream.TokenRequest) (*upstream.TokenResponse, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		return nil, errors.New("换取 Token 的 ctx 没有超时时间")
+	}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestRefreshIDETokenExchangeTimeout(t *testing.T) {
+	newTokenTestClient(t, traefake.Options{})
+	saved := tokenExchangeTimeout
+	tokenExchangeTimeout = 50 * time.Millisecond
+	t.Cleanup(func() { tokenExchangeTimeout = saved })
+
+	// 上游不响应时按超时时间放弃，不会一直持有锁
+	start := time.Now()
+	err := RefreshIDEToken(blockingExchanger{}, false, "")
+	if err == nil || !strings.Contains(err.Error(), context.DeadlineExceeded.Error()) {
+		t.Fatalf("err = %v, 期望超时错误", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("耗时 %v", elapsed)
+	}
+	if !tokenMutex.TryLock() {
+		t.Fatalf("超时后仍持有 tokenMutex")
+	}
+	tokenMutex.Unlock()
+}
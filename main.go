@@ -1,6 +1,7 @@
 package main
 
 import (
+	"expvar"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -8,6 +9,7 @@ import (
 	"github.com/trae2api/config"
 	"github.com/trae2api/middleware"
 	"github.com/trae2api/pkg/logger"
+	"github.com/trae2api/pkg/tokenizer"
 )
 
 func main() {
@@ -34,6 +36,25 @@ func main() {
 		logger.Log.Fatalf("Trae2API Config Init Failed: %v", err)
 	}
 
+	// 创建 Trae 上游客户端
+	traeUpstream := api.NewTraeUpstream()
+	api.SetUpstream(traeUpstream)
+
+	// 获取 Token 并定期刷新
+	if err := config.StartTokenRefresh(traeUpstream); err != nil {
+		logger.Log.Fatalf("Trae2API Token Init Failed: %v", err)
+	}
+
+	// 未内置 tiktoken 词表时 GPT 模型的 token 数只能估算
+	for _, tk := range []tokenizer.Tokenizer{tokenizer.CL100K, tokenizer.O200K} {
+		if !tokenizer.Exact(tk) {
+			logger.Log.Warnf("未内置 %s 词表，GPT 模型的 token 用量为估算值，执行 go generate ./pkg/tokenizer 后重新编译", tk.Name())
+		}
+	}
+
+	// 后台同步 Trae 模型目录
+	api.StartModelCatalogSync()
+
 	r := gin.Default()
 
 	// 跨域
@@ -46,9 +67,22 @@ func main() {
 
 	// OpenAI 格式的 API 路由
 	r.GET("/v1/models", api.GetModels)
+	r.GET("/v1/models/:id", api.GetModel)
 	r.POST("/v1", api.CreateChatCompletion)
 	r.POST("/v1/chat", api.CreateChatCompletion)
 	r.POST("/v1/chat/completions", api.CreateChatCompletion)
+	r.POST("/v1/responses", api.CreateResponse)
+	r.POST("/v1/completions", api.CreateCompletion)
+
+	// Anthropic 格式的 API 路由
+	r.POST("/v1/messages", api.CreateMessage)
+
+	// 运行指标（取消的请求数等），未配置鉴权时需要 DEBUG_VARS=1 才提供
+	if api.DebugVarsEnabled() {
+		r.GET("/debug/vars", gin.WrapH(expvar.Handler()))
+	} else {
+		logger.Log.Info("未配置鉴权，/debug/vars 已关闭，设置 DEBUG_VARS=1 开启")
+	}
 
 	logger.Log.WithFields(map[string]interface{}{
 		"port": 17080,
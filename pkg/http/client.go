@@ -1,8 +1,10 @@
 package http
 
 import (
+	"context"
 	"crypto/tls"
 	"io"
+	"net"
 	"net/http"
 	"time"
 
@@ -30,34 +32,85 @@ func (d *DebugTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 	return resp, err
 }
 
-// NewHTTP11Client 创建一个严格使用HTTP/1.1的HTTP客户端
+// ClientOptions HTTP 客户端配置，超时为 0 表示不限制
+type ClientOptions struct {
+	// DialTimeout 建立 TCP 连接的超时时间
+	DialTimeout time.Duration
+	// TLSHandshakeTimeout TLS 握手的超时时间
+	TLSHandshakeTimeout time.Duration
+	// ResponseHeaderTimeout 发送请求后等待响应头的超时时间
+	ResponseHeaderTimeout time.Duration
+	// IdleConnTimeout 空闲连接保留的时间
+	IdleConnTimeout time.Duration
+	// Timeout 单个请求（含读取响应体）的总超时时间
+	Timeout time.Duration
+	// MaxConnsPerHost 每个主机的最大连接数，0 表示不限制
+	MaxConnsPerHost int
+	// MaxIdleConnsPerHost 每个主机保留的最大空闲连接数
+	MaxIdleConnsPerHost int
+	// EnableHTTP2 是否允许通过 TLS 协商使用 HTTP/2，默认严格使用 HTTP/1.1
+	EnableHTTP2 bool
+	// PublicOnly 为 true 时拒绝连接回环、内网、链路本地等非公网地址，用于访问客户端提供的地址
+	PublicOnly bool
+}
+
+// DefaultClientOptions 返回默认的客户端配置
+func DefaultClientOptions() ClientOptions {
+	return ClientOptions{
+		DialTimeout:           30 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 1200 * time.Second,
+		IdleConnTimeout:       2400 * time.Second,
+		Timeout:               1800 * time.Second,
+		MaxIdleConnsPerHost:   16,
+	}
+}
+
+// NewHTTP11Client 创建一个使用默认配置、严格使用HTTP/1.1的HTTP客户端
 func NewHTTP11Client() *http.Client {
-	// 创建一个配置为HTTP/1.1的传输
+	return NewClient(DefaultClientOptions())
+}
+
+// NewClient 按配置创建HTTP客户端，客户端应当长期复用以便复用连接
+func NewClient(opts ClientOptions) *http.Client {
+	dialer := &net.Dialer{Timeout: opts.DialTimeout, KeepAlive: 30 * time.Second}
+	if opts.PublicOnly {
+		// 在解析出地址后、建立连接前检查，重定向与 DNS 重绑定同样受限
+		dialer.Control = denyNonPublic
+	}
+	return &http.Client{
+		Transport: &DebugTransport{Transport: newTransport(opts, dialer.DialContext)},
+		Timeout:   opts.Timeout,
+	}
+}
+
+// newTransport 按配置创建 Transport，dial 用于建立 TCP 连接
+func newTransport(opts ClientOptions, dial func(ctx context.Context, network, addr string) (net.Conn, error)) *http.Transport {
 	transport := &http.Transport{
+		DialContext:       dial,
 		DisableKeepAlives: false,
-		ForceAttemptHTTP2: false,                                                                  // 强制不使用HTTP/2
-		TLSNextProto:      make(map[string]func(authority string, c *tls.Conn) http.RoundTripper), // 禁用HTTP/2
 		TLSClientConfig: &tls.Config{
 			InsecureSkipVerify: false,
 			MinVersion:         tls.VersionTLS12,
 			MaxVersion:         tls.VersionTLS13,
 		},
+		TLSHandshakeTimeout:   opts.TLSHandshakeTimeout,
 		MaxIdleConns:          3000,
-		IdleConnTimeout:       2400 * time.Second,
-		ResponseHeaderTimeout: 1200 * time.Second,
+		MaxIdleConnsPerHost:   opts.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       opts.MaxConnsPerHost,
+		IdleConnTimeout:       opts.IdleConnTimeout,
+		ResponseHeaderTimeout: opts.ResponseHeaderTimeout,
 		ExpectContinueTimeout: 1 * time.Second,
 		DisableCompression:    false,
 	}
-
-	// 包装调试Transport
-	debugTransport := &DebugTransport{
-		Transport: transport,
-	}
-
-	return &http.Client{
-		Transport: debugTransport,
-		Timeout:   1800 * time.Second,
+	if opts.EnableHTTP2 {
+		// 自定义 DialContext 后需要显式开启 HTTP/2
+		transport.ForceAttemptHTTP2 = true
+	} else {
+		// 禁用HTTP/2
+		transport.TLSNextProto = make(map[string]func(authority string, c *tls.Conn) http.RoundTripper)
 	}
+	return transport
 }
 
 // NewHTTP11Request 创建一个使用HTTP/1.1的请求
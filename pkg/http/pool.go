@@ -0,0 +1,179 @@
+package http
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Pool 按主机复用连接的HTTP客户端
+//
+// 每个主机使用独立的长期 Transport，并记录连接与请求的统计信息。
+// Pool 实现了 http.RoundTripper，通过 Client 获取使用该连接池的客户端。
+type Pool struct {
+	opts   ClientOptions
+	client *http.Client
+
+	mu    sync.Mutex
+	hosts map[string]*hostPool
+}
+
+// hostPool 单个主机的 Transport 与统计
+type hostPool struct {
+	base      *http.Transport
+	transport http.RoundTripper
+
+	openConns      atomic.Int64
+	activeRequests atomic.Int64
+	requests       atomic.Int64
+	dials          atomic.Int64
+	dialErrors     atomic.Int64
+	reusedConns    atomic.Int64
+	errors         atomic.Int64
+}
+
+// HostStats 单个主机的连接池统计
+type HostStats struct {
+	Host string `json:"host"`
+	// OpenConns 当前打开的连接数
+	OpenConns int64 `json:"open_conns"`
+	// ActiveRequests 尚未关闭响应体的请求数
+	ActiveRequests int64 `json:"active_requests"`
+	// Requests 累计请求数
+	Requests int64 `json:"requests"`
+	// Dials 累计新建的连接数
+	Dials int64 `json:"dials"`
+	// DialErrors 累计建立连接失败的次数
+	DialErrors int64 `json:"dial_errors"`
+	// ReusedConns 累计复用已有连接的请求数
+	ReusedConns int64 `json:"reused_conns"`
+	// Errors 累计失败的请求数
+	Errors int64 `json:"errors"`
+}
+
+// NewPool 创建连接池
+func NewPool(opts ClientOptions) *Pool {
+	p := &Pool{
+		opts:  opts,
+		hosts: make(map[string]*hostPool),
+	}
+	p.client = &http.Client{Transport: p, Timeout: opts.Timeout}
+	return p
+}
+
+// Client 返回使用该连接池的客户端
+func (p *Pool) Client() *http.Client {
+	return p.client
+}
+
+// RoundTrip 实现http.RoundTripper接口
+func (p *Pool) RoundTrip(req *http.Request) (*http.Response, error) {
+	h := p.host(req.URL.Host)
+	h.requests.Add(1)
+	h.activeRequests.Add(1)
+
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				h.reusedConns.Add(1)
+			}
+		},
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	resp, err := h.transport.RoundTrip(req)
+	if err != nil {
+		h.activeRequests.Add(-1)
+		h.errors.Add(1)
+		return nil, err
+	}
+	resp.Body = &trackedBody{ReadCloser: resp.Body, done: func() { h.activeRequests.Add(-1) }}
+	return resp, nil
+}
+
+// Stats 返回各主机的统计，按主机名排序
+func (p *Pool) Stats() []HostStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats := make([]HostStats, 0, len(p.hosts))
+	for host, h := range p.hosts {
+		stats = append(stats, HostStats{
+			Host:           host,
+			OpenConns:      h.openConns.Load(),
+			ActiveRequests: h.activeRequests.Load(),
+			Requests:       h.requests.Load(),
+			Dials:          h.dials.Load(),
+			DialErrors:     h.dialErrors.Load(),
+			ReusedConns:    h.reusedConns.Load(),
+			Errors:         h.errors.Load(),
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Host < stats[j].Host })
+	return stats
+}
+
+// CloseIdleConnections 关闭所有主机的空闲连接
+func (p *Pool) CloseIdleConnections() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, h := range p.hosts {
+		h.base.CloseIdleConnections()
+	}
+}
+
+// host 返回主机对应的连接池，不存在时创建
+func (p *Pool) host(host string) *hostPool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if h, ok := p.hosts[host]; ok {
+		return h
+	}
+	h := &hostPool{}
+	dialer := &net.Dialer{Timeout: p.opts.DialTimeout, KeepAlive: 30 * time.Second}
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			h.dialErrors.Add(1)
+			return nil, err
+		}
+		h.dials.Add(1)
+		h.openConns.Add(1)
+		return &trackedConn{Conn: conn, done: func() { h.openConns.Add(-1) }}, nil
+	}
+	h.base = newTransport(p.opts, dial)
+	h.transport = &DebugTransport{Transport: h.base}
+	p.hosts[host] = h
+	return h
+}
+
+// trackedConn 关闭时更新连接数的连接
+type trackedConn struct {
+	net.Conn
+	once sync.Once
+	done func()
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(c.done)
+	return c.Conn.Close()
+}
+
+// trackedBody 关闭时更新进行中请求数的响应体
+type trackedBody struct {
+	io.ReadCloser
+	once sync.Once
+	done func()
+}
+
+func (b *trackedBody) Close() error {
+	b.once.Do(b.done)
+	return b.ReadCloser.Close()
+}
@@ -0,0 +1,142 @@
+package http
+
+import (
+	"encoding/json"
+	"expvar"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/trae2api/pkg/logger"
+)
+
+func TestMain(m *testing.M) {
+	logger.Init()
+	logger.Log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// newPoolTestServer 启动返回固定内容的测试服务，返回其主机名（含端口）
+func newPoolTestServer(t *testing.T) string {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	t.Cleanup(server.Close)
+	return strings.TrimPrefix(server.URL, "http://")
+}
+
+// poolGet 通过连接池请求主机并读完、关闭响应体
+func poolGet(t *testing.T, p *Pool, host string) {
+	t.Helper()
+	resp, err := p.Client().Get("http://" + host + "/")
+	if err != nil {
+		t.Fatalf("请求 %s 失败: %v", host, err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+}
+
+// hostStats 通过 expvar 输出的 JSON 读取各主机的统计
+func hostStats(t *testing.T, p *Pool) map[string]HostStats {
+	t.Helper()
+	v := expvar.Func(func() interface{} { return p.Stats() })
+	var list []HostStats
+	if err := json.Unmarshal([]byte(v.String()), &list); err != nil {
+		t.Fatalf("解析统计失败: %v", err)
+	}
+	stats := make(map[string]HostStats, len(list))
+	for _, s := range list {
+		stats[s.Host] = s
+	}
+	return stats
+}
+
+func TestPoolSeparateHosts(t *testing.T) {
+	p := NewPool(DefaultClientOptions())
+	a, b := newPoolTestServer(t), newPoolTestServer(t)
+	poolGet(t, p, a)
+	poolGet(t, p, b)
+
+	// 每个主机使用独立的 Transport 与连接
+	if p.host(a) == p.host(b) || p.host(a).base == p.host(b).base {
+		t.Fatalf("两个主机共用了 Transport")
+	}
+	stats := hostStats(t, p)
+	if len(stats) != 2 {
+		t.Fatalf("统计中有 %d 个主机, 期望 2: %v", len(stats), stats)
+	}
+	for _, host := range []string{a, b} {
+		if s := stats[host]; s.Requests != 1 || s.Dials != 1 || s.OpenConns != 1 {
+			t.Errorf("%s: %+v", host, s)
+		}
+	}
+}
+
+func TestPoolReusesHostTransport(t *testing.T) {
+	p := NewPool(DefaultClientOptions())
+	host := newPoolTestServer(t)
+	poolGet(t, p, host)
+	first := p.host(host)
+	poolGet(t, p, host)
+	poolGet(t, p, host)
+
+	// 同一主机始终使用同一个 Transport，第二次起复用已有连接
+	if p.host(host) != first {
+		t.Fatalf("同一主机创建了新的 Transport")
+	}
+	s := hostStats(t, p)[host]
+	if s.Requests != 3 || s.Dials != 1 || s.ReusedConns != 2 {
+		t.Errorf("统计 %+v, 期望 3 次请求、1 次建立连接、2 次复用", s)
+	}
+}
+
+func TestPoolCounters(t *testing.T) {
+	p := NewPool(DefaultClientOptions())
+	host := newPoolTestServer(t)
+
+	resp, err := p.Client().Get("http://" + host + "/")
+	if err != nil {
+		t.Fatalf("请求失败: %v", err)
+	}
+	// 响应体关闭前计为进行中的请求
+	if s := hostStats(t, p)[host]; s.ActiveRequests != 1 || s.Requests != 1 || s.OpenConns != 1 {
+		t.Errorf("读取响应前 %+v", s)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	// 重复关闭不会重复计数
+	resp.Body.Close()
+	if s := hostStats(t, p)[host]; s.ActiveRequests != 0 || s.Requests != 1 {
+		t.Errorf("关闭响应体后 %+v", s)
+	}
+
+	poolGet(t, p, host)
+	if s := hostStats(t, p)[host]; s.ActiveRequests != 0 || s.Requests != 2 {
+		t.Errorf("第二次请求后 %+v", s)
+	}
+
+	// 关闭空闲连接后打开的连接数归零，累计值不变
+	p.CloseIdleConnections()
+	if s := hostStats(t, p)[host]; s.OpenConns != 0 || s.Dials != 1 || s.Requests != 2 {
+		t.Errorf("关闭空闲连接后 %+v", s)
+	}
+
+	// 连接失败计入失败的请求与建立连接失败次数，不计为进行中
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refused := ln.Addr().String()
+	ln.Close()
+	if _, err := p.Client().Get("http://" + refused + "/"); err == nil {
+		t.Fatalf("连接已关闭的端口应失败")
+	}
+	if s := hostStats(t, p)[refused]; s.Requests != 1 || s.Errors != 1 || s.DialErrors != 1 || s.ActiveRequests != 0 || s.OpenConns != 0 {
+		t.Errorf("连接失败后 %+v", s)
+	}
+}
@@ -0,0 +1,324 @@
+// Package jsonschema 实现结构化输出校验所需的 JSON Schema 子集
+//
+// 支持 type、enum、const、properties、required、additionalProperties、items、
+// minItems/maxItems、minLength/maxLength、minimum/maximum、pattern 以及
+// anyOf/oneOf/allOf。未识别的关键字被忽略，$ref 只支持指向 #/$defs 与
+// #/definitions 的本地引用，不消耗值的循环引用视为校验失败。
+package jsonschema
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// ValidationError 校验失败的位置与原因
+type ValidationError struct {
+	Path    string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// Validate 校验 value 是否符合 schema，value 为 encoding/json 解码得到的值
+func Validate(schema interface{}, value interface{}) error {
+	root, _ := schema.(map[string]interface{})
+	v := &validator{root: root, resolving: make(map[refKey]bool)}
+	return v.validate(schema, value, "$")
+}
+
+type validator struct {
+	root map[string]interface{}
+	// 正在解析的引用，用于发现不消耗值的循环引用
+	resolving map[refKey]bool
+}
+
+// refKey 在某个位置上解析的引用
+//
+// 递归的 schema 每深入一层路径都会变长，只有同一位置上再次解析同一引用才是循环。
+type refKey struct {
+	ref  string
+	path string
+}
+
+func (v *validator) validate(schema interface{}, value interface{}, path string) error {
+	s, ok := schema.(map[string]interface{})
+	if !ok {
+		// true 或缺省的 schema 接受任意值
+		if b, isBool := schema.(bool); isBool && !b {
+			return &ValidationError{Path: path, Message: "不允许出现该值"}
+		}
+		return nil
+	}
+
+	if ref, ok := s["$ref"].(string); ok {
+		key := refKey{ref: ref, path: path}
+		if v.resolving[key] {
+			return &ValidationError{Path: path, Message: fmt.Sprintf("引用 %s 存在循环", ref)}
+		}
+		target, err := v.resolve(ref)
+		if err != nil {
+			return &ValidationError{Path: path, Message: err.Error()}
+		}
+		v.resolving[key] = true
+		defer delete(v.resolving, key)
+		return v.validate(target, value, path)
+	}
+
+	if t, ok := s["type"]; ok {
+		if err := checkType(t, value, path); err != nil {
+			return err
+		}
+	}
+
+	if enum, ok := s["enum"].([]interface{}); ok {
+		matched := false
+		for _, candidate := range enum {
+			if equal(candidate, value) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return &ValidationError{Path: path, Message: fmt.Sprintf("取值必须是 %v 之一", enum)}
+		}
+	}
+	if c, ok := s["const"]; ok && !equal(c, value) {
+		return &ValidationError{Path: path, Message: fmt.Sprintf("取值必须是 %v", c)}
+	}
+
+	switch val := value.(type) {
+	case map[string]interface{}:
+		if err := v.validateObject(s, val, path); err != nil {
+			return err
+		}
+	case []interface{}:
+		if err := v.validateArray(s, val, path); err != nil {
+			return err
+		}
+	case string:
+		if err := validateString(s, val, path); err != nil {
+			return err
+		}
+	case float64:
+		if err := validateNumber(s, val, path); err != nil {
+			return err
+		}
+	}
+
+	return v.validateCombinators(s, value, path)
+}
+
+func (v *validator) validateObject(s map[string]interface{}, obj map[string]interface{}, path string) error {
+	if required, ok := s["required"].([]interface{}); ok {
+		for _, r := range required {
+			name, _ := r.(string)
+			if _, exists := obj[name]; !exists {
+				return &ValidationError{Path: path, Message: fmt.Sprintf("缺少必填字段 %q", name)}
+			}
+		}
+	}
+
+	props, _ := s["properties"].(map[string]interface{})
+	for name, child := range obj {
+		childPath := path + "." + name
+		if propSchema, ok := props[name]; ok {
+			if err := v.validate(propSchema, child, childPath); err != nil {
+				return err
+			}
+			continue
+		}
+		if additional, ok := s["additionalProperties"]; ok {
+			if b, isBool := additional.(bool); isBool && !b {
+				return &ValidationError{Path: path, Message: fmt.Sprintf("不允许出现字段 %q", name)}
+			}
+			if err := v.validate(additional, child, childPath); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (v *validator) validateArray(s map[string]interface{}, arr []interface{}, path string) error {
+	if min, ok := number(s["minItems"]); ok && float64(len(arr)) < min {
+		return &ValidationError{Path: path, Message: fmt.Sprintf("至少需要 %v 个元素", min)}
+	}
+	if max, ok := number(s["maxItems"]); ok && float64(len(arr)) > max {
+		return &ValidationError{Path: path, Message: fmt.Sprintf("最多允许 %v 个元素", max)}
+	}
+	if items, ok := s["items"]; ok {
+		for i, item := range arr {
+			if err := v.validate(items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func validateString(s map[string]interface{}, str string, path string) error {
+	length := float64(len([]rune(str)))
+	if min, ok := number(s["minLength"]); ok && length < min {
+		return &ValidationError{Path: path, Message: fmt.Sprintf("长度不能小于 %v", min)}
+	}
+	if max, ok := number(s["maxLength"]); ok && length > max {
+		return &ValidationError{Path: path, Message: fmt.Sprintf("长度不能大于 %v", max)}
+	}
+	if pattern, ok := s["pattern"].(string); ok {
+		re, err := regexp.Compile(pattern)
+		if err == nil && !re.MatchString(str) {
+			return &ValidationError{Path: path, Message: fmt.Sprintf("不匹配正则 %s", pattern)}
+		}
+	}
+	return nil
+}
+
+func validateNumber(s map[string]interface{}, n float64, path string) error {
+	if min, ok := number(s["minimum"]); ok && n < min {
+		return &ValidationError{Path: path, Message: fmt.Sprintf("不能小于 %v", min)}
+	}
+	if max, ok := number(s["maximum"]); ok && n > max {
+		return &ValidationError{Path: path, Message: fmt.Sprintf("不能大于 %v", max)}
+	}
+	if min, ok := number(s["exclusiveMinimum"]); ok && n <= min {
+		return &ValidationError{Path: path, Message: fmt.Sprintf("必须大于 %v", min)}
+	}
+	if max, ok := number(s["exclusiveMaximum"]); ok && n >= max {
+		return &ValidationError{Path: path, Message: fmt.Sprintf("必须小于 %v", max)}
+	}
+	return nil
+}
+
+func (v *validator) validateCombinators(s map[string]interface{}, value interface{}, path string) error {
+	if all, ok := s["allOf"].([]interface{}); ok {
+		for _, sub := range all {
+			if err := v.validate(sub, value, path); err != nil {
+				return err
+			}
+		}
+	}
+	if anyOf, ok := s["anyOf"].([]interface{}); ok {
+		var firstErr error
+		matched := false
+		for _, sub := range anyOf {
+			err := v.validate(sub, value, path)
+			if err == nil {
+				matched = true
+				break
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		if !matched && firstErr != nil {
+			return firstErr
+		}
+	}
+	if one, ok := s["oneOf"].([]interface{}); ok {
+		count := 0
+		for _, sub := range one {
+			if v.validate(sub, value, path) == nil {
+				count++
+			}
+		}
+		if count != 1 {
+			return &ValidationError{Path: path, Message: fmt.Sprintf("必须恰好匹配 oneOf 中的一项，实际匹配 %d 项", count)}
+		}
+	}
+	return nil
+}
+
+// resolve 解析本地 $ref
+func (v *validator) resolve(ref string) (interface{}, error) {
+	for _, prefix := range []string{"#/$defs/", "#/definitions/"} {
+		if strings.HasPrefix(ref, prefix) {
+			defs, _ := v.root[strings.TrimSuffix(strings.TrimPrefix(prefix, "#/"), "/")].(map[string]interface{})
+			if target, ok := defs[strings.TrimPrefix(ref, prefix)]; ok {
+				return target, nil
+			}
+		}
+	}
+	if ref == "#" {
+		return v.root, nil
+	}
+	return nil, fmt.Errorf("无法解析引用 %s", ref)
+}
+
+// checkType 校验 type 关键字，支持字符串或字符串数组
+func checkType(t interface{}, value interface{}, path string) error {
+	var types []string
+	switch tv := t.(type) {
+	case string:
+		types = []string{tv}
+	case []interface{}:
+		for _, item := range tv {
+			if s, ok := item.(string); ok {
+				types = append(types, s)
+			}
+		}
+	}
+	for _, typ := range types {
+		if matchType(typ, value) {
+			return nil
+		}
+	}
+	return &ValidationError{Path: path, Message: fmt.Sprintf("类型应为 %s，实际为 %s", strings.Join(types, "|"), typeName(value))}
+}
+
+func matchType(typ string, value interface{}) bool {
+	switch typ {
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		n, ok := value.(float64)
+		return ok && n == math.Trunc(n)
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	}
+	return false
+}
+
+func typeName(value interface{}) string {
+	switch value.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case nil:
+		return "null"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func number(v interface{}) (float64, bool) {
+	n, ok := v.(float64)
+	return n, ok
+}
+
+func equal(a, b interface{}) bool {
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,109 @@
+package jsonschema
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		schema  string
+		value   string
+		wantErr string
+	}{
+		{
+			name:   "对象",
+			schema: `{"type":"object","properties":{"name":{"type":"string"}},"required":["name"],"additionalProperties":false}`,
+			value:  `{"name":"a"}`,
+		},
+		{
+			name:    "缺少必填字段",
+			schema:  `{"type":"object","required":["name"]}`,
+			value:   `{}`,
+			wantErr: `$: 缺少必填字段 "name"`,
+		},
+		{
+			name:    "不允许额外字段",
+			schema:  `{"type":"object","properties":{},"additionalProperties":false}`,
+			value:   `{"x":1}`,
+			wantErr: `$: 不允许出现字段 "x"`,
+		},
+		{
+			name:    "数组元素类型",
+			schema:  `{"type":"array","items":{"type":"integer"}}`,
+			value:   `[1,2.5]`,
+			wantErr: "$[1]: 类型应为 integer，实际为 number",
+		},
+		{
+			name:   "$defs 引用",
+			schema: `{"$defs":{"name":{"type":"string"}},"type":"object","properties":{"a":{"$ref":"#/$defs/name"}}}`,
+			value:  `{"a":"x"}`,
+		},
+		{
+			name:    "无法解析的引用",
+			schema:  `{"$ref":"#/$defs/missing"}`,
+			value:   `1`,
+			wantErr: "$: 无法解析引用 #/$defs/missing",
+		},
+		{
+			name:   "递归 schema",
+			schema: `{"$defs":{"node":{"type":"object","properties":{"children":{"type":"array","items":{"$ref":"#/$defs/node"}}}}},"$ref":"#/$defs/node"}`,
+			value:  `{"children":[{"children":[]},{"children":[{"children":[]}]}]}`,
+		},
+		{
+			name:    "递归 schema 中的错误",
+			schema:  `{"$defs":{"node":{"type":"object","properties":{"children":{"type":"array","items":{"$ref":"#/$defs/node"}}}}},"$ref":"#/$defs/node"}`,
+			value:   `{"children":[{"children":[1]}]}`,
+			wantErr: "$.children[0].children[0]: 类型应为 object，实际为 number",
+		},
+		{
+			name:    "引用自身",
+			schema:  `{"$defs":{"a":{"$ref":"#/$defs/a"}},"$ref":"#/$defs/a"}`,
+			value:   `{}`,
+			wantErr: "$: 引用 #/$defs/a 存在循环",
+		},
+		{
+			name:    "相互引用",
+			schema:  `{"definitions":{"a":{"$ref":"#/definitions/b"},"b":{"$ref":"#/definitions/a"}},"$ref":"#/definitions/a"}`,
+			value:   `1`,
+			wantErr: "存在循环",
+		},
+		{
+			name:    "通过 allOf 引用根",
+			schema:  `{"allOf":[{"$ref":"#"}]}`,
+			value:   `1`,
+			wantErr: "$: 引用 # 存在循环",
+		},
+		{
+			name:    "子字段中的循环",
+			schema:  `{"$defs":{"a":{"anyOf":[{"$ref":"#/$defs/a"}]}},"properties":{"x":{"$ref":"#/$defs/a"}}}`,
+			value:   `{"x":1}`,
+			wantErr: "$.x: 引用 #/$defs/a 存在循环",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var schema, value interface{}
+			if err := json.Unmarshal([]byte(tt.schema), &schema); err != nil {
+				t.Fatalf("解析 schema 失败: %v", err)
+			}
+			if err := json.Unmarshal([]byte(tt.value), &value); err != nil {
+				t.Fatalf("解析 value 失败: %v", err)
+			}
+
+			err := Validate(schema, value)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() 返回错误: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() = %v, 期望包含 %q", err, tt.wantErr)
+			}
+		})
+	}
+}
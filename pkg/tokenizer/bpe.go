@@ -0,0 +1,150 @@
+package tokenizer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"unicode"
+	"unicode/utf8"
+)
+
+// 与 tiktoken 相同的预切分规则
+//
+// Go 的 regexp 不支持 (?!\S)，\s+(?!\S) 分支由 split 在匹配后处理；
+// \s 在 Go 中只匹配 ASCII 空白，这里展开为 Unicode 空白。
+const (
+	ws = `\s\v\x{85}\p{Z}`
+
+	cl100kPattern = `(?i:'s|'t|'re|'ve|'m|'ll|'d)` +
+		`|[^\r\n\p{L}\p{N}]?\p{L}+` +
+		`|\p{N}{1,3}` +
+		`| ?[^` + ws + `\p{L}\p{N}]+[\r\n]*` +
+		`|[` + ws + `]*[\r\n]+` +
+		`|[` + ws + `]+`
+
+	o200kPattern = `[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]*[\p{Ll}\p{Lm}\p{Lo}\p{M}]+(?i:'s|'t|'re|'ve|'m|'ll|'d)?` +
+		`|[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]+[\p{Ll}\p{Lm}\p{Lo}\p{M}]*(?i:'s|'t|'re|'ve|'m|'ll|'d)?` +
+		`|\p{N}{1,3}` +
+		`| ?[^` + ws + `\p{L}\p{N}]+[\r\n/]*` +
+		`|[` + ws + `]*[\r\n]+` +
+		`|[` + ws + `]+`
+)
+
+var (
+	cl100kRegexp = regexp.MustCompile(cl100kPattern)
+	o200kRegexp  = regexp.MustCompile(o200kPattern)
+)
+
+// split 按预切分规则把文本切成片段
+func split(pattern *regexp.Regexp, text string) []string {
+	var pieces []string
+	for len(text) > 0 {
+		loc := pattern.FindStringIndex(text)
+		if loc == nil || loc[0] != 0 || loc[1] == 0 {
+			// 规则覆盖所有字符，不会出现；保险起见按单个字符切分
+			_, size := utf8.DecodeRuneInString(text)
+			loc = []int{0, size}
+		}
+		end := loc[1]
+
+		// \s+(?!\S)：后面还有非空白字符时，空白串的最后一个字符留给下一个片段
+		piece := text[:end]
+		if end < len(text) && isSpaceRun(piece) {
+			last, size := utf8.DecodeLastRuneInString(piece)
+			if last != '\r' && last != '\n' && size < len(piece) {
+				end -= size
+			}
+		}
+
+		pieces = append(pieces, text[:end])
+		text = text[end:]
+	}
+	return pieces
+}
+
+// isSpaceRun 判断片段是否全部由空白字符组成
+func isSpaceRun(s string) bool {
+	for _, r := range s {
+		if !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseRanks 解析 tiktoken 格式的词表，每行为 base64 编码的 token 与其序号
+func parseRanks(data []byte) (map[string]int, error) {
+	ranks := make(map[string]int, bytes.Count(data, []byte("\n")))
+	for i, line := range bytes.Split(data, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		fields := bytes.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("第 %d 行格式错误", i+1)
+		}
+		token, err := base64.StdEncoding.DecodeString(string(fields[0]))
+		if err != nil {
+			return nil, fmt.Errorf("第 %d 行 token 解码失败: %v", i+1, err)
+		}
+		rank, err := strconv.Atoi(string(fields[1]))
+		if err != nil {
+			return nil, fmt.Errorf("第 %d 行序号错误: %v", i+1, err)
+		}
+		ranks[string(token)] = rank
+	}
+	if len(ranks) == 0 {
+		return nil, fmt.Errorf("词表为空")
+	}
+	return ranks, nil
+}
+
+// bpe 使用 tiktoken 词表的字节级 BPE 分词
+type bpe struct {
+	ranks   map[string]int
+	pattern *regexp.Regexp
+}
+
+func (b *bpe) count(text string) int {
+	tokens := 0
+	for _, piece := range split(b.pattern, text) {
+		if _, ok := b.ranks[piece]; ok {
+			tokens++
+			continue
+		}
+		tokens += len(b.merge(piece))
+	}
+	return tokens
+}
+
+// merge 与 tiktoken 的 byte_pair_merge 相同：从单个字节开始，
+// 每次合并序号最小的相邻两部分，直到没有可合并的部分
+func (b *bpe) merge(piece string) []string {
+	// bounds[i] 为第 i 部分的起始位置，最后一个元素为片段长度
+	bounds := make([]int, len(piece)+1)
+	for i := range bounds {
+		bounds[i] = i
+	}
+
+	for len(bounds) > 2 {
+		minRank, minIndex := math.MaxInt, -1
+		for i := 0; i+2 < len(bounds); i++ {
+			if rank, ok := b.ranks[piece[bounds[i]:bounds[i+2]]]; ok && rank < minRank {
+				minRank, minIndex = rank, i
+			}
+		}
+		if minIndex < 0 {
+			break
+		}
+		bounds = append(bounds[:minIndex+1], bounds[minIndex+2:]...)
+	}
+
+	parts := make([]string, len(bounds)-1)
+	for i := range parts {
+		parts[i] = piece[bounds[i]:bounds[i+1]]
+	}
+	return parts
+}
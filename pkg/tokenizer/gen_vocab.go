@@ -0,0 +1,81 @@
+//go:build ignore
+
+// gen_vocab 下载 tiktoken 词表到 vocab 目录，由 go generate 调用
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const baseURL = "https://openaipublic.blob.core.windows.net/encodings/"
+
+// 词表至少包含的 token 数，防止写入截断的下载结果
+const minTokens = 100000
+
+var encodings = []string{"cl100k_base", "o200k_base"}
+
+func main() {
+	client := &http.Client{Timeout: 5 * time.Minute}
+	for _, enc := range encodings {
+		file := filepath.Join("vocab", enc+".tiktoken")
+		data, err := download(client, baseURL+enc+".tiktoken")
+		if err != nil {
+			log.Fatalf("下载 %s 失败: %v", enc, err)
+		}
+		if err := validate(data); err != nil {
+			log.Fatalf("%s 词表校验失败: %v", enc, err)
+		}
+		if err := os.WriteFile(file, data, 0o644); err != nil {
+			log.Fatalf("写入 %s 失败: %v", file, err)
+		}
+		log.Printf("已写入 %s (%d 字节)", file, len(data))
+	}
+}
+
+func download(client *http.Client, url string) ([]byte, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("状态码 %d", resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// validate 检查每行格式，序号必须从 0 开始连续且 token 不重复
+func validate(data []byte) error {
+	lines := bytes.Split(bytes.TrimRight(data, "\n"), []byte("\n"))
+	if len(lines) < minTokens {
+		return fmt.Errorf("token 数 %d, 至少应为 %d", len(lines), minTokens)
+	}
+	seen := make(map[string]bool, len(lines))
+	for i, line := range lines {
+		fields := bytes.Fields(line)
+		if len(fields) != 2 {
+			return fmt.Errorf("第 %d 行格式错误", i+1)
+		}
+		token, err := base64.StdEncoding.DecodeString(string(fields[0]))
+		if err != nil {
+			return fmt.Errorf("第 %d 行 token 解码失败: %v", i+1, err)
+		}
+		if seen[string(token)] {
+			return fmt.Errorf("第 %d 行 token 重复", i+1)
+		}
+		seen[string(token)] = true
+		if rank, err := strconv.Atoi(string(fields[1])); err != nil || rank != i {
+			return fmt.Errorf("第 %d 行序号 %q, 期望 %d", i+1, fields[1], i)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+// Package tokenizer 离线统计文本的 token 数
+//
+// 上游不返回用量信息，GPT 模型使用内置的 tiktoken 词表（cl100k_base、o200k_base）
+// 按字节级 BPE 精确计数，结果与 tiktoken 一致。
+//
+// Claude、DeepSeek、Gemini 的词表未公开或未内置，按模型家族估算：先使用与 BPE
+// 分词器相同思路的正则预切分（单词、数字、中日韩字符、标点、空白），再按各家族
+// 词表的平均压缩率把每个片段折算为 token 数，结果只是确定的近似值。
+package tokenizer
+
+import (
+	"math"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Tokenizer 统计文本的 token 数
+type Tokenizer interface {
+	// Name 分词器名称
+	Name() string
+	// Count 返回文本的 token 数
+	Count(text string) int
+}
+
+// 估算使用的预切分规则，各分支的顺序决定匹配优先级
+var pretokenizePattern = regexp.MustCompile(
+	`(?i:'s|'t|'re|'ve|'m|'ll|'d)` +
+		`|(?P<cjk> ?[\p{Han}\p{Hiragana}\p{Katakana}\p{Hangul}])` +
+		`|(?P<word> ?[\p{Latin}\p{Greek}\p{Cyrillic}\p{Arabic}\p{Hebrew}\p{Devanagari}\p{Thai}\p{M}]+)` +
+		`|(?P<number>\p{N}{1,3})` +
+		`|(?P<punct> ?[^\s\p{L}\p{N}]+)` +
+		`|(?P<space>\s+)` +
+		`|(?P<other>.)`)
+
+var (
+	groupCJK    = pretokenizePattern.SubexpIndex("cjk")
+	groupWord   = pretokenizePattern.SubexpIndex("word")
+	groupNumber = pretokenizePattern.SubexpIndex("number")
+	groupPunct  = pretokenizePattern.SubexpIndex("punct")
+	groupSpace  = pretokenizePattern.SubexpIndex("space")
+	groupOther  = pretokenizePattern.SubexpIndex("other")
+)
+
+// family 一个模型家族的词表特征
+type family struct {
+	name string
+	// 不超过该长度的单词通常是词表中的单个 token
+	wordChars int
+	// 长单词平均每个 token 覆盖的字符数
+	charsPerToken float64
+	// 每个中日韩字符平均占用的 token 数
+	tokensPerCJK float64
+}
+
+var (
+	// Claude 词表的英文压缩率略低，中文接近逐字多 token
+	Claude Tokenizer = &family{name: "claude-approx", wordChars: 6, charsPerToken: 3.5, tokensPerCJK: 1.4}
+	// DeepSeek 词表包含大量中文词，中文压缩率较高
+	DeepSeek Tokenizer = &family{name: "deepseek-approx", wordChars: 7, charsPerToken: 4, tokensPerCJK: 0.6}
+	// Gemini 为 SentencePiece 词表
+	Gemini Tokenizer = &family{name: "gemini-approx", wordChars: 7, charsPerToken: 4, tokensPerCJK: 0.8}
+)
+
+// o200k_base 词表的模型名称前缀
+var o200kPrefixes = []string{"gpt-4o", "gpt-4.1", "gpt-4.5", "gpt-5", "o1", "o3", "o4", "chatgpt-4o"}
+
+// ForModel 根据模型名称选择分词器，无法识别的模型按 cl100k_base 处理
+func ForModel(model string) Tokenizer {
+	m := strings.ToLower(model)
+	switch {
+	case strings.Contains(m, "claude"):
+		return Claude
+	case strings.Contains(m, "deepseek"):
+		return DeepSeek
+	case strings.Contains(m, "gemini"):
+		return Gemini
+	}
+	for _, prefix := range o200kPrefixes {
+		if strings.HasPrefix(m, prefix) {
+			return O200K
+		}
+	}
+	return CL100K
+}
+
+func (f *family) Name() string {
+	return f.name
+}
+
+func (f *family) Count(text string) int {
+	if text == "" {
+		return 0
+	}
+
+	tokens := 0
+	cjk := 0.0
+	for _, m := range pretokenizePattern.FindAllStringSubmatchIndex(text, -1) {
+		switch {
+		case m[2*groupCJK] >= 0:
+			cjk += f.tokensPerCJK
+		case m[2*groupWord] >= 0:
+			word := strings.TrimPrefix(text[m[0]:m[1]], " ")
+			tokens += f.countWord(utf8.RuneCountInString(word))
+		case m[2*groupNumber] >= 0, m[2*groupSpace] >= 0, m[2*groupOther] >= 0:
+			tokens++
+		case m[2*groupPunct] >= 0:
+			punct := strings.TrimPrefix(text[m[0]:m[1]], " ")
+			tokens += (utf8.RuneCountInString(punct) + 1) / 2
+		default:
+			// 英文缩写
+			tokens++
+		}
+	}
+	return tokens + int(math.Ceil(cjk))
+}
+
+// countWord 估算长度为 n 个字符的单词占用的 token 数
+func (f *family) countWord(n int) int {
+	if n <= f.wordChars {
+		return 1
+	}
+	return 1 + int(math.Ceil(float64(n-f.wordChars)/f.charsPerToken))
+}
@@ -0,0 +1,165 @@
+package tokenizer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		pattern string
+		text    string
+		want    []string
+	}{
+		{pattern: "cl100k", text: "Hello, world!", want: []string{"Hello", ",", " world", "!"}},
+		{pattern: "cl100k", text: "I'm here", want: []string{"I", "'m", " here"}},
+		{pattern: "cl100k", text: "1234567", want: []string{"123", "456", "7"}},
+		// 多个空格时最后一个空格与后面的单词合并
+		{pattern: "cl100k", text: "a   b", want: []string{"a", "  ", " b"}},
+		{pattern: "cl100k", text: "a  \n\n  b", want: []string{"a", "  \n\n", " ", " b"}},
+		{pattern: "cl100k", text: "a  12", want: []string{"a", " ", " ", "12"}},
+		// 末尾的空白不拆分
+		{pattern: "cl100k", text: "x  ", want: []string{"x", "  "}},
+		// Unicode 空白与 ASCII 空白同样处理
+		{pattern: "cl100k", text: "a　　b", want: []string{"a", "　", "　b"}},
+		// 标点可作为单词的前缀
+		{pattern: "cl100k", text: "你好，世界", want: []string{"你好", "，世界"}},
+		{pattern: "cl100k", text: "HelloWorld", want: []string{"HelloWorld"}},
+		{pattern: "o200k", text: "HelloWorld", want: []string{"Hello", "World"}},
+		{pattern: "o200k", text: "don't", want: []string{"don't"}},
+		{pattern: "o200k", text: "a/b\n", want: []string{"a", "/b", "\n"}},
+	}
+	for _, tt := range tests {
+		pattern := cl100kRegexp
+		if tt.pattern == "o200k" {
+			pattern = o200kRegexp
+		}
+		if got := split(pattern, tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s split(%q) = %q, want %q", tt.pattern, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	b := &bpe{
+		ranks:   map[string]int{"a": 0, "b": 1, "c": 2, "d": 3, "ab": 4, "cd": 5, "abcd": 6, "bc": 7},
+		pattern: cl100kRegexp,
+	}
+	tests := []struct {
+		piece string
+		want  []string
+	}{
+		// 先合并序号最小的 ab，再合并 cd、abcd
+		{piece: "abcdab", want: []string{"abcd", "ab"}},
+		// cd 的序号小于 bc
+		{piece: "bcd", want: []string{"b", "cd"}},
+		{piece: "dcba", want: []string{"d", "c", "b", "a"}},
+		// 词表中没有的字节保持独立
+		{piece: "axb", want: []string{"a", "x", "b"}},
+	}
+	for _, tt := range tests {
+		if got := b.merge(tt.piece); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("merge(%q) = %q, want %q", tt.piece, got, tt.want)
+		}
+	}
+	if got := b.count("abcd abcdab"); got != 4 {
+		t.Errorf("count = %d, want 4", got)
+	}
+}
+
+func TestParseRanks(t *testing.T) {
+	// "a" "b" "ab" "你"
+	ranks, err := parseRanks([]byte("YQ== 0\nYg== 1\nYWI= 2\n5L2g 3\n"))
+	if err != nil {
+		t.Fatalf("parseRanks: %v", err)
+	}
+	want := map[string]int{"a": 0, "b": 1, "ab": 2, "你": 3}
+	if !reflect.DeepEqual(ranks, want) {
+		t.Errorf("ranks = %v, want %v", ranks, want)
+	}
+
+	for _, data := range []string{"", "YQ==\n", "!!! 0\n", "YQ== x\n"} {
+		if _, err := parseRanks([]byte(data)); err == nil {
+			t.Errorf("parseRanks(%q) 应返回错误", data)
+		}
+	}
+}
+
+// TestReference 与 tiktoken 的实际分词结果对比，需先执行 go generate 下载词表
+func TestReference(t *testing.T) {
+	tests := []struct {
+		tk   Tokenizer
+		text string
+		want int
+	}{
+		{tk: CL100K, text: "", want: 0},
+		{tk: CL100K, text: "hello", want: 1},
+		// "hello" " world"
+		{tk: CL100K, text: "hello world", want: 2},
+		// "Hello" "," " world" "!"
+		{tk: CL100K, text: "Hello, world!", want: 4},
+		{tk: CL100K, text: "The quick brown fox jumps over the lazy dog.", want: 10},
+		// "123" "456" "7"
+		{tk: CL100K, text: "1234567", want: 3},
+		// "I" "'m" " here"
+		{tk: CL100K, text: "I'm here", want: 3},
+		// "t" "ik" "token" " is" " great" "!"
+		{tk: CL100K, text: "tiktoken is great!", want: 6},
+		// "Open" "AI"
+		{tk: CL100K, text: "OpenAI", want: 2},
+		// "token" "ization"
+		{tk: CL100K, text: "tokenization", want: 2},
+		{tk: O200K, text: "hello world", want: 2},
+		{tk: O200K, text: "The quick brown fox jumps over the lazy dog.", want: 10},
+		{tk: O200K, text: "1234567", want: 3},
+	}
+	for _, tt := range tests {
+		if !Exact(tt.tk) {
+			t.Skipf("未内置 %s 词表，执行 go generate ./pkg/tokenizer 后再运行", tt.tk.Name())
+		}
+		if got := tt.tk.Count(tt.text); got != tt.want {
+			t.Errorf("%s.Count(%q) = %d, want %d", tt.tk.Name(), tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestFamilies(t *testing.T) {
+	const chinese = "今天天气很好，我们一起去公园散步吧。"
+	const english = "The quick brown fox jumps over the lazy dog."
+
+	// 各家族的英文短句估算一致
+	for _, tk := range []Tokenizer{Claude, DeepSeek, Gemini} {
+		if got := tk.Count(english); got != 10 {
+			t.Errorf("%s.Count(english) = %d, want 10", tk.Name(), got)
+		}
+	}
+
+	// 中文压缩率: DeepSeek > Gemini > Claude
+	deepseek, gemini, claude := DeepSeek.Count(chinese), Gemini.Count(chinese), Claude.Count(chinese)
+	if !(deepseek < gemini && gemini < claude) {
+		t.Errorf("中文估算顺序不符合预期: deepseek=%d gemini=%d claude=%d", deepseek, gemini, claude)
+	}
+}
+
+func TestForModel(t *testing.T) {
+	tests := []struct {
+		model string
+		want  Tokenizer
+	}{
+		{model: "aws_sdk_claude37_sonnet", want: Claude},
+		{model: "claude3.5", want: Claude},
+		{model: "deepseek-R1", want: DeepSeek},
+		{model: "gemini-2.5-pro-preview-03-25", want: Gemini},
+		{model: "gpt-4o", want: O200K},
+		{model: "GPT-4.1", want: O200K},
+		{model: "o3-mini", want: O200K},
+		{model: "gpt-4", want: CL100K},
+		{model: "gpt-3.5-turbo", want: CL100K},
+		{model: "unknown", want: CL100K},
+	}
+	for _, tt := range tests {
+		if got := ForModel(tt.model); got != tt.want {
+			t.Errorf("ForModel(%q) = %s, want %s", tt.model, got.Name(), tt.want.Name())
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package tokenizer
+
+//go:generate go run gen_vocab.go
+
+import (
+	"embed"
+	"regexp"
+	"sync"
+)
+
+// vocab 目录存放 tiktoken 格式的词表，由 go generate 下载
+//
+//go:embed vocab
+var vocabFS embed.FS
+
+// encoding 使用内置词表的 tiktoken 分词器，词表未内置时使用 fallback 估算
+type encoding struct {
+	name     string
+	file     string
+	pattern  *regexp.Regexp
+	fallback *family
+
+	once sync.Once
+	bpe  *bpe
+}
+
+var (
+	// CL100K 为 cl100k_base 词表（GPT-4、GPT-3.5）
+	CL100K Tokenizer = &encoding{
+		name:     "cl100k_base",
+		file:     "vocab/cl100k_base.tiktoken",
+		pattern:  cl100kRegexp,
+		fallback: &family{name: "cl100k-approx", wordChars: 7, charsPerToken: 4, tokensPerCJK: 1.0},
+	}
+	// O200K 为 o200k_base 词表（GPT-4o、GPT-4.1、o 系列）
+	O200K Tokenizer = &encoding{
+		name:     "o200k_base",
+		file:     "vocab/o200k_base.tiktoken",
+		pattern:  o200kRegexp,
+		fallback: &family{name: "o200k-approx", wordChars: 7, charsPerToken: 4, tokensPerCJK: 0.8},
+	}
+)
+
+// load 首次使用时解析词表，词表缺失或格式错误时保持 bpe 为 nil
+func (e *encoding) load() *bpe {
+	e.once.Do(func() {
+		data, err := vocabFS.ReadFile(e.file)
+		if err != nil {
+			return
+		}
+		ranks, err := parseRanks(data)
+		if err != nil {
+			return
+		}
+		e.bpe = &bpe{ranks: ranks, pattern: e.pattern}
+	})
+	return e.bpe
+}
+
+func (e *encoding) Name() string {
+	return e.name
+}
+
+func (e *encoding) Count(text string) int {
+	if text == "" {
+		return 0
+	}
+	if b := e.load(); b != nil {
+		return b.count(text)
+	}
+	return e.fallback.Count(text)
+}
+
+// Exact 判断分词器是否使用内置词表精确计数
+func Exact(tk Tokenizer) bool {
+	e, ok := tk.(*encoding)
+	return ok && e.load() != nil
+}
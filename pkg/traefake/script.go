@@ -0,0 +1,133 @@
+package traefake
+
+import (
+	"strings"
+	"time"
+
+	"github.com/trae2api/pkg/traesse"
+)
+
+// Step 脚本中的一条 SSE 事件
+type Step struct {
+	// Event 事件类型，如 traesse.EventOutput
+	Event string
+	// Data 事件数据，会被编码为 JSON
+	Data interface{}
+	// Delay 发送该事件前等待的时间
+	Delay time.Duration
+}
+
+// Script 一次对话请求的脚本化响应
+type Script struct {
+	// Status 非 0 且不为 200 时直接返回该状态码与 Body，不发送事件流
+	Status int
+	Body   string
+	// QueueTimes 同一会话的前 QueueTimes 次请求只返回排队事件
+	QueueTimes int
+	// Steps 依次发送的事件
+	Steps []Step
+	// Disconnect 为 true 时发送完 Steps 后直接断开连接，模拟上游中途断流
+	Disconnect bool
+}
+
+// Output 返回一条 output 事件
+func Output(response, reasoning, finishReason string) Step {
+	data := map[string]interface{}{}
+	if response != "" {
+		data["response"] = response
+	}
+	if reasoning != "" {
+		data["reasoning_content"] = reasoning
+	}
+	if finishReason != "" {
+		data["finish_reason"] = finishReason
+	}
+	return Step{Event: traesse.EventOutput, Data: data}
+}
+
+// Queue 返回一条排队事件
+func Queue(position int) Step {
+	return Step{Event: traesse.EventQueue, Data: map[string]interface{}{
+		"position": position,
+		"message":  "当前排队人数较多，请稍后",
+		"queue_id": "fake-queue",
+	}}
+}
+
+// Done 返回一条 done 事件
+func Done(finishReason string) Step {
+	return Step{Event: traesse.EventDone, Data: map[string]interface{}{"finish_reason": finishReason}}
+}
+
+// Reply 返回先输出推理内容、再按单词输出正文、最后以 finishReason 结束的脚本
+func Reply(reasoning, response, finishReason string) Script {
+	var steps []Step
+	for _, chunk := range splitWords(reasoning) {
+		steps = append(steps, Output("", chunk, ""))
+	}
+	for _, chunk := range splitWords(response) {
+		steps = append(steps, Output(chunk, "", ""))
+	}
+	steps = append(steps, Done(finishReason))
+	return Script{Steps: steps}
+}
+
+// Fail 返回直接以状态码 status 失败的脚本
+func Fail(status int, body string) Script {
+	return Script{Status: status, Body: body}
+}
+
+// splitWords 按空格切分文本，切分后的片段保留原有空格
+func splitWords(text string) []string {
+	if text == "" {
+		return nil
+	}
+	var chunks []string
+	for {
+		i := strings.Index(text[1:], " ")
+		if i < 0 {
+			return append(chunks, text)
+		}
+		chunks = append(chunks, text[:i+1])
+		text = text[i+1:]
+	}
+}
+
+// builtinScripts 独立运行时可通过在用户输入中包含触发词使用的脚本
+func builtinScripts() map[string]Script {
+	queued := Reply("", "Finally your turn.", "stop")
+	queued.QueueTimes = 2
+
+	noDone := Reply("", "The stream ends without done.", "")
+	noDone.Steps = noDone.Steps[:len(noDone.Steps)-1]
+
+	// 输出一部分正文后排队 10 秒再继续
+	midStream := Reply("", "Partial answer", "")
+	midStream.Steps = midStream.Steps[:len(midStream.Steps)-1]
+	for i := 0; i < 10; i++ {
+		step := Queue(1)
+		step.Delay = time.Second
+		midStream.Steps = append(midStream.Steps, step)
+	}
+	midStream.Steps = append(midStream.Steps, Output(" and the rest.", "", ""), Done("stop"))
+
+	disconnect := Reply("", "This reply is cut off", "")
+	disconnect.Steps = disconnect.Steps[:len(disconnect.Steps)-1]
+	disconnect.Disconnect = true
+
+	return map[string]Script{
+		"[fake:queue]":            queued,
+		"[fake:queue-forever]":    {QueueTimes: 1 << 30},
+		"[fake:queue-mid-stream]": midStream,
+		"[fake:reasoning]":        Reply("Let me think about it.", "Here is the answer.", "stop"),
+		"[fake:length]":           Reply("", "This reply hits the length limit", "length"),
+		"[fake:content-filter]":   Reply("", "Sorry,", "content_filter"),
+		"[fake:no-done]":          noDone,
+		"[fake:disconnect]":       disconnect,
+		"[fake:error-400]":        Fail(400, `{"error":"bad request"}`),
+		"[fake:error-401]":        Fail(401, `{"error":"unauthorized"}`),
+		"[fake:error-429]":        Fail(429, `{"error":"too many requests"}`),
+		"[fake:error-500]":        Fail(500, `{"error":"internal error"}`),
+		"[fake:error-503]":        Fail(503, `{"error":"service unavailable"}`),
+	}
+}
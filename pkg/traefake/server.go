@@ -0,0 +1,289 @@
+// Package traefake 提供一个本地的 Trae 模拟服务，用于端到端调试
+//
+// Server 实现了模型列表、对话、换取 Token 与图片上传流程的接口，既可以通过
+// httptest.NewServer(traefake.New(opts)) 在测试中使用，也可以通过
+// cmd/traefake 独立运行。对话接口按用户输入中包含的触发词返回脚本化的事件流。
+package traefake
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/trae2api/pkg/upstream"
+)
+
+// Options 模拟服务配置
+type Options struct {
+	// Models 模型列表，为空时使用 DefaultModels
+	Models []upstream.ModelConfig
+	// RefreshToken 初始有效的 RefreshToken，为空时接受任意 RefreshToken
+	RefreshToken string
+	// TokenTTL 签发的 Token 有效期，默认 1 小时
+	TokenTTL time.Duration
+	// RefreshTokenTTL 签发的 RefreshToken 有效期，默认 14 天
+	RefreshTokenTTL time.Duration
+	// CheckToken 为 true 时模型列表与对话接口要求 x-ide-token 为未过期的已签发 Token
+	CheckToken bool
+	// StepInterval 事件之间的默认间隔
+	StepInterval time.Duration
+}
+
+// Server Trae 模拟服务
+type Server struct {
+	opts    Options
+	handler http.Handler
+
+	mu            sync.Mutex
+	models        []upstream.ModelConfig
+	scripts       map[string]Script
+	defaultScript Script
+	// 会话ID -> 已返回排队事件的次数
+	queued map[string]int
+	// 仍然有效的 RefreshToken
+	refreshTokens map[string]bool
+	// 已签发的 Token -> 过期时间
+	tokens   map[string]time.Time
+	issued   int
+	requests []upstream.ChatRequest
+
+	// 申请上传的会话ID -> 上传会话，uploadKeys 保持申请顺序
+	uploads    map[string]*uploadSession
+	uploadKeys []string
+	uploadSeq  int
+}
+
+// DefaultModels 返回与内置模型注册表对应的 Trae 模型列表
+func DefaultModels() []upstream.ModelConfig {
+	return []upstream.ModelConfig{
+		{Name: "claude3.5", DisplayName: "Claude-3.5-Sonnet", Multimodal: true},
+		{Name: "aws_sdk_claude37_sonnet", DisplayName: "Claude-3.7-Sonnet", Multimodal: true, IsDefault: true},
+		{Name: "gpt-4o", DisplayName: "GPT-4o", Multimodal: true, CustomConfig: `{"max_tokens":4096}`},
+		{Name: "gpt-4.1-2025-04-14", DisplayName: "GPT-4.1", Multimodal: true},
+		{Name: "deepseek-V3", DisplayName: "DeepSeek-V3"},
+		{Name: "deepseek-V3-0324", DisplayName: "DeepSeek-V3-0324"},
+		{Name: "deepseek-R1", DisplayName: "DeepSeek-R1"},
+		{Name: "gemini-2.5-pro-preview-03-25", DisplayName: "Gemini-2.5-Pro", Multimodal: true},
+		{Name: "gemini_2.5_flash", DisplayName: "Gemini-2.5-Flash", Multimodal: true},
+	}
+}
+
+// New 创建模拟服务
+func New(opts Options) *Server {
+	if opts.Models == nil {
+		opts.Models = DefaultModels()
+	}
+	if opts.TokenTTL <= 0 {
+		opts.TokenTTL = time.Hour
+	}
+	if opts.RefreshTokenTTL <= 0 {
+		opts.RefreshTokenTTL = 14 * 24 * time.Hour
+	}
+
+	s := &Server{
+		opts:          opts,
+		models:        opts.Models,
+		scripts:       builtinScripts(),
+		defaultScript: Reply("", "Hello from the fake Trae server.", "stop"),
+		queued:        make(map[string]int),
+		refreshTokens: make(map[string]bool),
+		tokens:        make(map[string]time.Time),
+		uploads:       make(map[string]*uploadSession),
+	}
+	if opts.RefreshToken != "" {
+		s.refreshTokens[opts.RefreshToken] = true
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/ide/v1/model_list", s.handleModelList)
+	mux.HandleFunc("/api/ide/v1/chat", s.handleChat)
+	mux.HandleFunc("/cloudide/api/v3/trae/oauth/ExchangeToken", s.handleExchangeToken)
+	// 图片上传流程：获取凭证、ImageX 申请与提交上传、存储服务接收内容
+	mux.HandleFunc("/api/ide/v1/get_upload_token", s.handleUploadToken)
+	mux.HandleFunc("/upload/v1/", s.handleStoreUpload)
+	mux.HandleFunc("/", s.handleImageX)
+	s.handler = mux
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+// SetModels 替换模型列表，用于模拟上游模型变化
+func (s *Server) SetModels(models []upstream.ModelConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.models = models
+}
+
+// Handle 注册脚本，用户输入包含 trigger 时使用该脚本
+func (s *Server) Handle(trigger string, script Script) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scripts[trigger] = script
+}
+
+// SetDefault 设置没有触发词匹配时使用的脚本
+func (s *Server) SetDefault(script Script) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.defaultScript = script
+}
+
+// Requests 返回收到的全部对话请求
+func (s *Server) Requests() []upstream.ChatRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]upstream.ChatRequest(nil), s.requests...)
+}
+
+func (s *Server) handleModelList(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		http.Error(w, `{"error":"invalid token"}`, http.StatusUnauthorized)
+		return
+	}
+
+	s.mu.Lock()
+	models := upstream.ModelList{ModelConfigs: s.models}
+	s.mu.Unlock()
+	writeJSON(w, models)
+}
+
+func (s *Server) handleChat(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.authorized(r) {
+		http.Error(w, `{"error":"invalid token"}`, http.StatusUnauthorized)
+		return
+	}
+
+	var req upstream.ChatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":%q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	script, queue := s.match(req)
+	if script.Status != 0 && script.Status != http.StatusOK {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(script.Status)
+		fmt.Fprint(w, script.Body)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	steps := script.Steps
+	if queue {
+		steps = []Step{Queue(1)}
+	}
+	for _, step := range steps {
+		delay := step.Delay
+		if delay == 0 {
+			delay = s.opts.StepInterval
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(delay):
+		}
+
+		data, _ := json.Marshal(step.Data)
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", step.Event, data)
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+
+	if script.Disconnect && !queue {
+		// 中断连接，客户端读取到不完整的响应
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// match 记录请求并选择脚本，queue 为 true 表示本次请求只返回排队事件
+func (s *Server) match(req upstream.ChatRequest) (Script, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, req)
+
+	// 按触发词排序，保证多个触发词同时匹配时结果稳定
+	triggers := make([]string, 0, len(s.scripts))
+	for trigger := range s.scripts {
+		triggers = append(triggers, trigger)
+	}
+	sort.Strings(triggers)
+
+	script := s.defaultScript
+	for _, trigger := range triggers {
+		if strings.Contains(req.UserInput, trigger) {
+			script = s.scripts[trigger]
+			break
+		}
+	}
+
+	if s.queued[req.SessionID] < script.QueueTimes {
+		s.queued[req.SessionID]++
+		return script, true
+	}
+	return script, false
+}
+
+func (s *Server) handleExchangeToken(w http.ResponseWriter, r *http.Request) {
+	var req upstream.TokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":%q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// 每个 RefreshToken 只能使用一次，换取时签发新的 RefreshToken
+	if s.opts.RefreshToken != "" {
+		if !s.refreshTokens[req.RefreshToken] {
+			http.Error(w, `{"error":"invalid refresh token"}`, http.StatusUnauthorized)
+			return
+		}
+		delete(s.refreshTokens, req.RefreshToken)
+	}
+
+	s.issued++
+	now := time.Now()
+	token := fmt.Sprintf("fake-token-%d", s.issued)
+	refreshToken := fmt.Sprintf("fake-refresh-token-%d", s.issued)
+	s.tokens[token] = now.Add(s.opts.TokenTTL)
+	s.refreshTokens[refreshToken] = true
+
+	var resp upstream.TokenResponse
+	resp.Result.Token = token
+	resp.Result.TokenExpireAt = now.Add(s.opts.TokenTTL).UnixMilli()
+	resp.Result.RefreshToken = refreshToken
+	resp.Result.RefreshExpireAt = now.Add(s.opts.RefreshTokenTTL).UnixMilli()
+	writeJSON(w, resp)
+}
+
+// authorized 校验 x-ide-token 是否为未过期的已签发 Token
+func (s *Server) authorized(r *http.Request) bool {
+	if !s.opts.CheckToken {
+		return true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expireAt, ok := s.tokens[r.Header.Get("x-ide-token")]
+	return ok && time.Now().Before(expireAt)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
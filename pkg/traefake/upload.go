@@ -0,0 +1,344 @@
+package traefake
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 上传凭证接口下发的固定临时凭证
+const (
+	UploadAccessKeyID     = "fake-access-key"
+	UploadSecretAccessKey = "fake-secret-key"
+	UploadSessionToken    = "fake-session-token"
+	UploadServiceID       = "fake-service"
+)
+
+// Upload 模拟服务收到的一次图片上传
+type Upload struct {
+	// StoreURI 申请上传时分配的存储地址
+	StoreURI string
+	// Size 申请上传时声明的大小
+	Size int
+	// Data 实际上传的内容
+	Data []byte
+	// Committed 是否已提交
+	Committed bool
+	// Scope 申请上传请求签名中的凭证范围，如 20250101/ap-singapore-1/imagex/aws4_request
+	Scope string
+}
+
+// uploadSession 申请上传后等待上传与提交的会话
+type uploadSession struct {
+	upload *Upload
+	auth   string
+}
+
+// Uploads 返回收到的全部图片上传
+func (s *Server) Uploads() []Upload {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	uploads := make([]Upload, 0, len(s.uploadKeys))
+	for _, key := range s.uploadKeys {
+		uploads = append(uploads, *s.uploads[key].upload)
+	}
+	return uploads
+}
+
+func (s *Server) handleUploadToken(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		http.Error(w, `{"error":"invalid token"}`, http.StatusUnauthorized)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"Result": map[string]string{
+			"AccessKeyID":     UploadAccessKeyID,
+			"SecretAccessKey": UploadSecretAccessKey,
+			"SessionToken":    UploadSessionToken,
+			"ServiceId":       UploadServiceID,
+		},
+	})
+}
+
+// handleImageX 模拟 ImageX OpenAPI，按 Action 参数分发
+func (s *Server) handleImageX(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	scope, err := verifySignature(r, body)
+	if err != nil {
+		writeImageXError(w, http.StatusForbidden, "SignatureDoesNotMatch", err.Error())
+		return
+	}
+	query := r.URL.Query()
+	if query.Get("ServiceId") != UploadServiceID {
+		writeImageXError(w, http.StatusBadRequest, "InvalidServiceId", "unknown service id")
+		return
+	}
+
+	switch query.Get("Action") {
+	case "ApplyImageUpload":
+		s.applyImageUpload(w, r, query, scope)
+	case "CommitImageUpload":
+		s.commitImageUpload(w, body)
+	default:
+		writeImageXError(w, http.StatusBadRequest, "InvalidAction", "unknown action")
+	}
+}
+
+func (s *Server) applyImageUpload(w http.ResponseWriter, r *http.Request, query url.Values, scope string) {
+	size, err := strconv.Atoi(query.Get("FileSize"))
+	if err != nil || size <= 0 {
+		writeImageXError(w, http.StatusBadRequest, "InvalidFileSize", "invalid FileSize")
+		return
+	}
+
+	s.mu.Lock()
+	s.uploadSeq++
+	n := s.uploadSeq
+	storeURI := fmt.Sprintf("tos-fake/image-%d", n)
+	sessionKey := fmt.Sprintf("fake-session-%d", n)
+	auth := fmt.Sprintf("fake-store-auth-%d", n)
+	s.uploads[sessionKey] = &uploadSession{
+		upload: &Upload{StoreURI: storeURI, Size: size, Scope: scope},
+		auth:   auth,
+	}
+	s.uploadKeys = append(s.uploadKeys, sessionKey)
+	s.mu.Unlock()
+
+	writeJSON(w, map[string]interface{}{
+		"Result": map[string]interface{}{
+			"UploadAddress": map[string]interface{}{
+				"StoreInfos":  []map[string]string{{"StoreUri": storeURI, "Auth": auth}},
+				"UploadHosts": []string{r.Host},
+				"SessionKey":  sessionKey,
+			},
+		},
+	})
+}
+
+func (s *Server) commitImageUpload(w http.ResponseWriter, body []byte) {
+	var req struct {
+		SessionKey string `json:"SessionKey"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeImageXError(w, http.StatusBadRequest, "InvalidParameter", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	session, ok := s.uploads[req.SessionKey]
+	if ok && session.upload.Data != nil {
+		session.upload.Committed = true
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeImageXError(w, http.StatusBadRequest, "InvalidSessionKey", "unknown session key")
+		return
+	}
+	upload := session.upload
+	if upload.Data == nil {
+		writeImageXError(w, http.StatusBadRequest, "FileNotUploaded", "file not uploaded")
+		return
+	}
+
+	config, format, err := image.DecodeConfig(bytes.NewReader(upload.Data))
+	if err != nil {
+		writeImageXError(w, http.StatusBadRequest, "InvalidImage", err.Error())
+		return
+	}
+	imageURI := "fake-image/" + upload.StoreURI
+	writeJSON(w, map[string]interface{}{
+		"Result": map[string]interface{}{
+			"Results": []map[string]interface{}{{"Uri": imageURI, "UriStatus": 2000}},
+			"PluginResult": []map[string]interface{}{{
+				"ImageUri":    imageURI,
+				"ImageWidth":  config.Width,
+				"ImageHeight": config.Height,
+				"ImageFormat": format,
+				"ImageSize":   len(upload.Data),
+			}},
+		},
+	})
+}
+
+// handleStoreUpload 模拟存储服务接收图片内容
+func (s *Server) handleStoreUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	storeURI := strings.TrimPrefix(r.URL.Path, "/upload/v1/")
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var session *uploadSession
+	for _, key := range s.uploadKeys {
+		if s.uploads[key].upload.StoreURI == storeURI {
+			session = s.uploads[key]
+			break
+		}
+	}
+	switch {
+	case session == nil:
+		http.Error(w, `{"error":"unknown store uri"}`, http.StatusNotFound)
+	case r.Header.Get("Authorization") != session.auth:
+		http.Error(w, `{"error":"invalid auth"}`, http.StatusUnauthorized)
+	case r.Header.Get("Content-CRC32") != fmt.Sprintf("%08x", crc32.ChecksumIEEE(data)):
+		http.Error(w, `{"error":"crc32 mismatch"}`, http.StatusBadRequest)
+	case len(data) != session.upload.Size:
+		http.Error(w, `{"error":"size mismatch"}`, http.StatusBadRequest)
+	default:
+		session.upload.Data = data
+		writeJSON(w, map[string]interface{}{"success": 0})
+	}
+}
+
+// verifySignature 校验 AWS4-HMAC-SHA256 签名，返回签名中的凭证范围
+func verifySignature(r *http.Request, body []byte) (string, error) {
+	auth := r.Header.Get("Authorization")
+	const algorithm = "AWS4-HMAC-SHA256 "
+	if !strings.HasPrefix(auth, algorithm) {
+		return "", fmt.Errorf("unsupported authorization: %q", auth)
+	}
+	fields := make(map[string]string)
+	for _, part := range strings.Split(strings.TrimPrefix(auth, algorithm), ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return "", fmt.Errorf("malformed authorization: %q", auth)
+		}
+		fields[key] = value
+	}
+
+	accessKeyID, scope, ok := strings.Cut(fields["Credential"], "/")
+	if !ok || accessKeyID != UploadAccessKeyID {
+		return "", fmt.Errorf("invalid credential: %q", fields["Credential"])
+	}
+	scopeParts := strings.Split(scope, "/")
+	if len(scopeParts) != 4 || scopeParts[3] != "aws4_request" {
+		return "", fmt.Errorf("invalid credential scope: %q", scope)
+	}
+	if r.Header.Get("X-Amz-Security-Token") != UploadSessionToken {
+		return "", fmt.Errorf("invalid security token")
+	}
+	amzDate := r.Header.Get("X-Amz-Date")
+	signedAt, err := time.Parse("20060102T150405Z", amzDate)
+	if err != nil || !strings.HasPrefix(amzDate, scopeParts[0]) {
+		return "", fmt.Errorf("invalid X-Amz-Date: %q", amzDate)
+	}
+	if d := time.Since(signedAt); d > 15*time.Minute || d < -15*time.Minute {
+		return "", fmt.Errorf("request expired")
+	}
+
+	signedHeaders := strings.Split(fields["SignedHeaders"], ";")
+	for _, required := range []string{"x-amz-date", "x-amz-security-token"} {
+		if !contains(signedHeaders, required) {
+			return "", fmt.Errorf("%s is not signed", required)
+		}
+	}
+	var headers strings.Builder
+	for _, name := range signedHeaders {
+		headers.WriteString(name + ":" + strings.TrimSpace(r.Header.Get(name)) + "\n")
+	}
+
+	bodyHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		r.URL.EscapedPath(),
+		canonicalQuery(r.URL.Query()),
+		headers.String(),
+		fields["SignedHeaders"],
+		hex.EncodeToString(bodyHash[:]),
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + UploadSecretAccessKey)
+	for _, part := range scopeParts {
+		key = hmacSum(key, part)
+	}
+	expected := hex.EncodeToString(hmacSum(key, stringToSign))
+	if !hmac.Equal([]byte(expected), []byte(fields["Signature"])) {
+		return "", fmt.Errorf("signature mismatch")
+	}
+	return scope, nil
+}
+
+// canonicalQuery 按 SigV4 规范排序并编码查询参数
+func canonicalQuery(query url.Values) string {
+	var pairs []string
+	for key, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, uriEncode(key)+"="+uriEncode(value))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode 除 RFC 3986 非保留字符外全部百分号编码
+func uriEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSum(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+func writeImageXError(w http.ResponseWriter, status int, code string, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ResponseMetadata": map[string]interface{}{
+			"Error": map[string]string{"Code": code, "Message": message},
+		},
+	})
+}
@@ -0,0 +1,42 @@
+package traefake
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestImageXRejectsInvalidSignature(t *testing.T) {
+	s := New(Options{})
+	amzDate := time.Now().UTC().Format("20060102T150405Z")
+	scope := amzDate[:8] + "/ap-singapore-1/imagex/aws4_request"
+
+	tests := []struct {
+		name string
+		auth string
+	}{
+		{name: "缺少签名", auth: ""},
+		{name: "未知 AccessKey", auth: "AWS4-HMAC-SHA256 Credential=other/" + scope + ", SignedHeaders=x-amz-date;x-amz-security-token, Signature=00"},
+		{name: "签名错误", auth: "AWS4-HMAC-SHA256 Credential=" + UploadAccessKeyID + "/" + scope + ", SignedHeaders=x-amz-date;x-amz-security-token, Signature=00"},
+		{name: "未签名 security token", auth: "AWS4-HMAC-SHA256 Credential=" + UploadAccessKeyID + "/" + scope + ", SignedHeaders=x-amz-date, Signature=00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/?Action=ApplyImageUpload&ServiceId="+UploadServiceID+"&FileSize=1", nil)
+			req.Header.Set("Authorization", tt.auth)
+			req.Header.Set("X-Amz-Date", amzDate)
+			req.Header.Set("X-Amz-Security-Token", UploadSessionToken)
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, req)
+
+			if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "SignatureDoesNotMatch") {
+				t.Errorf("状态码 %d, body: %s", w.Code, w.Body.String())
+			}
+			if len(s.Uploads()) != 0 {
+				t.Errorf("签名无效时不应创建上传会话")
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+// Package traesse 解析 Trae 对话接口返回的 SSE 事件流
+package traesse
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Message 是按 SSE 规范解码出的一条原始事件
+type Message struct {
+	// Event 事件类型，未指定时为 "message"
+	Event string
+	// Data 多行 data 字段以 "\n" 拼接后的内容
+	Data string
+	// ID 最近一次收到的事件ID
+	ID string
+	// Retry 服务端建议的重连间隔（毫秒），未指定时为 -1
+	Retry int
+}
+
+// Decoder 从 io.Reader 中逐条解码 SSE 事件
+//
+// 支持多行 data、id、retry、注释行以及 LF/CR/CRLF 三种换行符。
+// 为兼容上游在两条事件之间不输出空行的情况，在已累积 data 后再次收到
+// event 字段时会先派发已累积的事件；流结束时未以空行结尾的事件同样会被派发。
+type Decoder struct {
+	r       *bufio.Reader
+	started bool
+
+	lastID  string
+	event   string
+	data    bytes.Buffer
+	hasData bool
+	retry   int
+}
+
+// NewDecoder 创建一个新的 SSE 解码器
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{
+		r:     bufio.NewReader(r),
+		retry: -1,
+	}
+}
+
+// Next 返回下一条事件，流结束时返回 io.EOF
+func (d *Decoder) Next() (*Message, error) {
+	for {
+		line, err := d.readLine()
+		if err != nil {
+			if err == io.EOF && line == "" {
+				if msg := d.dispatch(); msg != nil {
+					return msg, nil
+				}
+				return nil, io.EOF
+			}
+			if err != io.EOF {
+				return nil, err
+			}
+		}
+
+		// 空行：派发当前事件
+		if line == "" {
+			if msg := d.dispatch(); msg != nil {
+				return msg, nil
+			}
+			continue
+		}
+
+		// 注释行
+		if line[0] == ':' {
+			continue
+		}
+
+		field, value := line, ""
+		if idx := strings.IndexByte(line, ':'); idx >= 0 {
+			field = line[:idx]
+			value = strings.TrimPrefix(line[idx+1:], " ")
+		}
+
+		var pending *Message
+		if field == "event" && d.hasData {
+			pending = d.dispatch()
+		}
+		d.processField(field, value)
+		if pending != nil {
+			return pending, nil
+		}
+	}
+}
+
+// processField 按规范处理单个字段
+func (d *Decoder) processField(field, value string) {
+	switch field {
+	case "event":
+		d.event = value
+	case "data":
+		if d.hasData {
+			d.data.WriteByte('\n')
+		}
+		d.data.WriteString(value)
+		d.hasData = true
+	case "id":
+		// 包含 NULL 字符的 id 需要忽略
+		if !strings.ContainsRune(value, 0) {
+			d.lastID = value
+		}
+	case "retry":
+		if !isDigits(value) {
+			return
+		}
+		if n, err := strconv.Atoi(value); err == nil {
+			d.retry = n
+		}
+	default:
+		// 未知字段直接忽略
+	}
+}
+
+// dispatch 生成当前缓冲的事件并重置缓冲区，没有 data 时返回 nil
+func (d *Decoder) dispatch() *Message {
+	if !d.hasData {
+		d.event = ""
+		return nil
+	}
+
+	msg := &Message{
+		Event: d.event,
+		Data:  d.data.String(),
+		ID:    d.lastID,
+		Retry: d.retry,
+	}
+	if msg.Event == "" {
+		msg.Event = "message"
+	}
+
+	d.event = ""
+	d.data.Reset()
+	d.hasData = false
+	return msg
+}
+
+// readLine 读取一行并去除行尾的 LF、CR 或 CRLF
+func (d *Decoder) readLine() (string, error) {
+	var buf []byte
+	for {
+		b, err := d.r.ReadByte()
+		if err != nil {
+			if len(buf) > 0 && err == io.EOF {
+				return d.stripBOM(buf), nil
+			}
+			return d.stripBOM(buf), err
+		}
+
+		switch b {
+		case '\n':
+			return d.stripBOM(buf), nil
+		case '\r':
+			// CRLF 视为一个换行
+			if next, err := d.r.Peek(1); err == nil && next[0] == '\n' {
+				_, _ = d.r.ReadByte()
+			}
+			return d.stripBOM(buf), nil
+		default:
+			buf = append(buf, b)
+		}
+	}
+}
+
+// stripBOM 去除流开头的 UTF-8 BOM
+func (d *Decoder) stripBOM(line []byte) string {
+	if !d.started {
+		d.started = true
+		line = bytes.TrimPrefix(line, []byte("\xEF\xBB\xBF"))
+	}
+	return string(line)
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
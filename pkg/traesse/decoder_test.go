@@ -0,0 +1,317 @@
+package traesse
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// decodeAll 读取流中的全部事件
+func decodeAll(t testing.TB, input string) []Message {
+	t.Helper()
+	d := NewDecoder(strings.NewReader(input))
+	var msgs []Message
+	for {
+		msg, err := d.Next()
+		if err == io.EOF {
+			return msgs
+		}
+		if err != nil {
+			t.Fatalf("Next() 返回错误: %v", err)
+		}
+		msgs = append(msgs, *msg)
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []Message
+	}{
+		{
+			name:  "单行 data",
+			input: "event: output\ndata: {\"response\":\"hi\"}\n\n",
+			want:  []Message{{Event: "output", Data: `{"response":"hi"}`, Retry: -1}},
+		},
+		{
+			name:  "多行 data 以换行拼接",
+			input: "data: line1\ndata: line2\ndata:\ndata: line4\n\n",
+			want:  []Message{{Event: "message", Data: "line1\nline2\n\nline4", Retry: -1}},
+		},
+		{
+			name:  "CRLF 换行",
+			input: "event: output\r\ndata: a\r\n\r\nevent: done\r\ndata: b\r\n\r\n",
+			want: []Message{
+				{Event: "output", Data: "a", Retry: -1},
+				{Event: "done", Data: "b", Retry: -1},
+			},
+		},
+		{
+			name:  "CR 换行",
+			input: "event: output\rdata: a\r\rdata: b\r\r",
+			want: []Message{
+				{Event: "output", Data: "a", Retry: -1},
+				{Event: "message", Data: "b", Retry: -1},
+			},
+		},
+		{
+			name:  "混合换行符",
+			input: "data: a\r\ndata: b\rdata: c\n\r\n",
+			want:  []Message{{Event: "message", Data: "a\nb\nc", Retry: -1}},
+		},
+		{
+			name:  "注释行被忽略",
+			input: ": keep-alive\nevent: output\n: comment between fields\ndata: x\n\n:\n\n",
+			want:  []Message{{Event: "output", Data: "x", Retry: -1}},
+		},
+		{
+			name:  "id 对后续事件持续生效",
+			input: "id: 1\ndata: a\n\ndata: b\n\nid\ndata: c\n\n",
+			want: []Message{
+				{Event: "message", Data: "a", ID: "1", Retry: -1},
+				{Event: "message", Data: "b", ID: "1", Retry: -1},
+				{Event: "message", Data: "c", ID: "", Retry: -1},
+			},
+		},
+		{
+			name:  "包含 NULL 字符的 id 被忽略",
+			input: "id: 1\ndata: a\n\nid: 2\x003\ndata: b\n\n",
+			want: []Message{
+				{Event: "message", Data: "a", ID: "1", Retry: -1},
+				{Event: "message", Data: "b", ID: "1", Retry: -1},
+			},
+		},
+		{
+			name:  "retry",
+			input: "retry: 3000\ndata: a\n\n",
+			want:  []Message{{Event: "message", Data: "a", Retry: 3000}},
+		},
+		{
+			name:  "非法 retry 被忽略",
+			input: "retry: 100\ndata: a\n\nretry: 1.5\ndata: b\n\nretry: -1\nretry: abc\nretry:\ndata: c\n\n",
+			want: []Message{
+				{Event: "message", Data: "a", Retry: 100},
+				{Event: "message", Data: "b", Retry: 100},
+				{Event: "message", Data: "c", Retry: 100},
+			},
+		},
+		{
+			name:  "去除开头的 BOM",
+			input: "\xEF\xBB\xBFevent: output\ndata: a\n\n",
+			want:  []Message{{Event: "output", Data: "a", Retry: -1}},
+		},
+		{
+			name:  "只去除流开头的 BOM",
+			input: "data: a\n\n\xEF\xBB\xBFdata: b\n\n",
+			want:  []Message{{Event: "message", Data: "a", Retry: -1}},
+		},
+		{
+			name:  "流结束时派发未以空行结尾的事件",
+			input: "event: output\ndata: a\n\nevent: done\ndata: {}",
+			want: []Message{
+				{Event: "output", Data: "a", Retry: -1},
+				{Event: "done", Data: "{}", Retry: -1},
+			},
+		},
+		{
+			name:  "流结束时派发以换行结尾但缺少空行的事件",
+			input: "event: done\ndata: {}\n",
+			want:  []Message{{Event: "done", Data: "{}", Retry: -1}},
+		},
+		{
+			name:  "事件之间缺少空行",
+			input: "event: output\ndata: a\nevent: output\ndata: b\nevent: done\ndata: {}\n\n",
+			want: []Message{
+				{Event: "output", Data: "a", Retry: -1},
+				{Event: "output", Data: "b", Retry: -1},
+				{Event: "done", Data: "{}", Retry: -1},
+			},
+		},
+		{
+			name:  "没有 data 的事件不派发",
+			input: "event: output\n\nevent: done\n\ndata: a\n\n",
+			want:  []Message{{Event: "message", Data: "a", Retry: -1}},
+		},
+		{
+			name:  "冒号后只去除一个空格",
+			input: "data:  a \ndata:b\n\n",
+			want:  []Message{{Event: "message", Data: " a \nb", Retry: -1}},
+		},
+		{
+			name:  "未知字段被忽略",
+			input: "foo: bar\ndata: a\n\n",
+			want:  []Message{{Event: "message", Data: "a", Retry: -1}},
+		},
+		{
+			name:  "空流",
+			input: "",
+			want:  nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := decodeAll(t, tt.input)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decode(%q)\n got: %#v\nwant: %#v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		msg     Message
+		want    Event
+		wantErr bool
+	}{
+		{
+			name: "排队事件",
+			msg:  Message{Event: EventQueue, Data: `{"position":3,"message":"wait","queue_id":"q"}`},
+			want: &QueueEvent{Position: 3, Message: "wait", QueueID: "q"},
+		},
+		{
+			name: "输出事件",
+			msg:  Message{Event: EventOutput, Data: `{"response":"a","reasoning_content":"b","finish_reason":"stop"}`},
+			want: &OutputEvent{Response: "a", ReasoningContent: "b", FinishReason: "stop"},
+		},
+		{
+			name: "data 为空的 done 事件",
+			msg:  Message{Event: EventDone},
+			want: &DoneEvent{},
+		},
+		{
+			name: "done 事件",
+			msg:  Message{Event: EventDone, Data: `{"finish_reason":"length"}`},
+			want: &DoneEvent{FinishReason: "length"},
+		},
+		{
+			name: "未知事件",
+			msg:  Message{Event: "ping", Data: "not json"},
+			want: &UnknownEvent{},
+		},
+		{
+			name:    "输出事件的 data 无法解析",
+			msg:     Message{Event: EventOutput, Data: "{"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := tt.msg
+			got, err := Parse(&msg)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Parse() 应当返回错误，实际返回 %#v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() 返回错误: %v", err)
+			}
+			if got.Raw() != &msg {
+				t.Errorf("Raw() 未返回原始事件")
+			}
+			// 比较时忽略原始事件
+			switch ev := got.(type) {
+			case *QueueEvent:
+				ev.raw = nil
+			case *OutputEvent:
+				ev.raw = nil
+			case *DoneEvent:
+				ev.raw = nil
+			case *UnknownEvent:
+				ev.raw = nil
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncoderRoundTrip(t *testing.T) {
+	msgs := []Message{
+		{Event: "output", Data: `{"response":"a"}`, Retry: -1},
+		{Event: "message", Data: "multi\nline\n\ndata", ID: "7", Retry: -1},
+		{Event: "done", Data: "", ID: "7", Retry: 500},
+		{Event: "done", Data: " leading space", ID: "", Retry: 500},
+	}
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	for i := range msgs {
+		if err := enc.Encode(&msgs[i]); err != nil {
+			t.Fatalf("Encode() 返回错误: %v", err)
+		}
+	}
+
+	if got := decodeAll(t, buf.String()); !reflect.DeepEqual(got, msgs) {
+		t.Errorf("round trip\n got: %#v\nwant: %#v", got, msgs)
+	}
+}
+
+func TestEncoderRejectsUnencodable(t *testing.T) {
+	for _, msg := range []Message{
+		{Event: "a\nb", Data: "x", Retry: -1},
+		{Event: "a", ID: "1\r", Data: "x", Retry: -1},
+		{Event: "a", ID: "1\x00", Data: "x", Retry: -1},
+		{Event: "a", Data: "x\ry", Retry: -1},
+	} {
+		if err := NewEncoder(io.Discard).Encode(&msg); err == nil {
+			t.Errorf("Encode(%#v) 应当返回错误", msg)
+		}
+	}
+}
+
+// FuzzDecoder 解码任意输入不应 panic，且解码出的事件重新编码后再次解码结果不变
+func FuzzDecoder(f *testing.F) {
+	for _, seed := range []string{
+		"event: output\ndata: {\"response\":\"hi\"}\n\n",
+		"data: a\r\ndata: b\rdata: c\n\n",
+		": comment\nid: 1\nretry: 10\ndata: x\n\n",
+		"\xEF\xBB\xBFdata: bom\n\n",
+		"id: 1\x002\ndata: nul\n\n",
+		"event: done\ndata: {}",
+		"event: a\ndata: 1\nevent: b\ndata: 2\n",
+		"retry: 99999999999999999999\ndata: x\n\n",
+		"data:  two spaces\n\n",
+	} {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, input []byte) {
+		d := NewDecoder(bytes.NewReader(input))
+		var msgs []Message
+		for {
+			msg, err := d.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("Next() 返回错误: %v", err)
+			}
+			if _, err := Parse(msg); err != nil {
+				// 解析失败只影响单条事件
+				_ = err
+			}
+			msgs = append(msgs, *msg)
+		}
+
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		for i := range msgs {
+			if err := enc.Encode(&msgs[i]); err != nil {
+				t.Fatalf("解码得到的事件无法编码: %v, msg: %#v", err, msgs[i])
+			}
+		}
+		if got := decodeAll(t, buf.String()); !reflect.DeepEqual(got, msgs) {
+			t.Fatalf("round trip\ninput: %q\nencoded: %q\n got: %#v\nwant: %#v", input, buf.String(), got, msgs)
+		}
+	})
+}
@@ -0,0 +1,55 @@
+package traesse
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Encoder 将事件按 SSE 格式写出，是 Decoder 的逆过程
+//
+// id 与 retry 在 SSE 中对后续事件持续生效，因此只在与上一条事件不同时写出。
+type Encoder struct {
+	w      io.Writer
+	lastID string
+	retry  int
+}
+
+// NewEncoder 创建一个新的 SSE 编码器
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w, retry: -1}
+}
+
+// Encode 写出一条事件，字段中包含无法用 SSE 表示的字符时返回错误
+func (e *Encoder) Encode(msg *Message) error {
+	if strings.ContainsAny(msg.Event, "\r\n") {
+		return fmt.Errorf("event 包含换行符: %q", msg.Event)
+	}
+	if strings.ContainsAny(msg.ID, "\r\n\x00") {
+		return fmt.Errorf("id 包含换行符或 NULL 字符: %q", msg.ID)
+	}
+	if strings.ContainsRune(msg.Data, '\r') {
+		return fmt.Errorf("data 包含回车符")
+	}
+
+	var b strings.Builder
+	if msg.Event != "" {
+		b.WriteString("event: " + msg.Event + "\n")
+	}
+	if msg.ID != e.lastID {
+		b.WriteString("id: " + msg.ID + "\n")
+		e.lastID = msg.ID
+	}
+	if msg.Retry >= 0 && msg.Retry != e.retry {
+		b.WriteString("retry: " + strconv.Itoa(msg.Retry) + "\n")
+		e.retry = msg.Retry
+	}
+	for _, line := range strings.Split(msg.Data, "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+
+	_, err := io.WriteString(e.w, b.String())
+	return err
+}
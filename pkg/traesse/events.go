@@ -0,0 +1,98 @@
+package traesse
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Trae 对话接口的事件类型
+const (
+	EventQueue  = "request_wait_in_queue"
+	EventOutput = "output"
+	EventDone   = "done"
+)
+
+// Event 是解析后的强类型事件
+type Event interface {
+	// Raw 返回原始 SSE 事件
+	Raw() *Message
+}
+
+// QueueEvent 请求在服务端排队
+type QueueEvent struct {
+	Position int    `json:"position"`
+	Message  string `json:"message"`
+	QueueID  string `json:"queue_id"`
+
+	raw *Message
+}
+
+// OutputEvent 模型输出的增量内容
+type OutputEvent struct {
+	Response         string `json:"response"`
+	ReasoningContent string `json:"reasoning_content"`
+	FinishReason     string `json:"finish_reason"`
+
+	raw *Message
+}
+
+// DoneEvent 本轮对话结束
+type DoneEvent struct {
+	FinishReason string `json:"finish_reason"`
+
+	raw *Message
+}
+
+// UnknownEvent 未识别的事件类型
+type UnknownEvent struct {
+	raw *Message
+}
+
+func (e *QueueEvent) Raw() *Message   { return e.raw }
+func (e *OutputEvent) Raw() *Message  { return e.raw }
+func (e *DoneEvent) Raw() *Message    { return e.raw }
+func (e *UnknownEvent) Raw() *Message { return e.raw }
+
+// Parse 将原始事件解析为强类型事件
+//
+// 已知事件的 data 无法解析时返回错误，未知事件返回 *UnknownEvent。
+// done 事件允许 data 为空。
+func Parse(msg *Message) (Event, error) {
+	switch msg.Event {
+	case EventQueue:
+		ev := &QueueEvent{raw: msg}
+		if err := json.Unmarshal([]byte(msg.Data), ev); err != nil {
+			return nil, fmt.Errorf("parse %s event failed: %v", msg.Event, err)
+		}
+		return ev, nil
+	case EventOutput:
+		ev := &OutputEvent{raw: msg}
+		if err := json.Unmarshal([]byte(msg.Data), ev); err != nil {
+			return nil, fmt.Errorf("parse %s event failed: %v", msg.Event, err)
+		}
+		return ev, nil
+	case EventDone:
+		ev := &DoneEvent{raw: msg}
+		if msg.Data == "" {
+			return ev, nil
+		}
+		if err := json.Unmarshal([]byte(msg.Data), ev); err != nil {
+			return nil, fmt.Errorf("parse %s event failed: %v", msg.Event, err)
+		}
+		return ev, nil
+	default:
+		return &UnknownEvent{raw: msg}, nil
+	}
+}
+
+// NextEvent 读取并解析下一条事件
+//
+// 解析失败时同时返回原始事件和错误，调用方可以选择跳过该事件继续读取。
+func (d *Decoder) NextEvent() (Event, *Message, error) {
+	msg, err := d.Next()
+	if err != nil {
+		return nil, nil, err
+	}
+	ev, err := Parse(msg)
+	return ev, msg, err
+}
@@ -0,0 +1,207 @@
+// Package trae 通过 HTTP 调用 Trae 接口实现 upstream.Upstream 与 upstream.ImageUploader
+package trae
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+	customhttp "github.com/trae2api/pkg/http"
+	"github.com/trae2api/pkg/logger"
+	"github.com/trae2api/pkg/traesse"
+	"github.com/trae2api/pkg/upstream"
+)
+
+// Options Trae 客户端配置
+type Options struct {
+	// BaseURL 模型列表与对话接口的基础地址
+	BaseURL string
+	// TokenURL 换取 Token 接口的基础地址
+	TokenURL string
+	// ImageXURL 申请与提交图片上传的 ImageX 接口地址
+	ImageXURL string
+	// UploadURL 接收图片内容的存储服务地址
+	UploadURL string
+	// SetHeaders 为模型列表与对话请求设置鉴权与设备信息请求头
+	SetHeaders func(req *http.Request)
+	// HTTPClient 发送请求使用的客户端，为空时使用默认配置的HTTP/1.1客户端
+	HTTPClient *http.Client
+}
+
+// Client Trae 接口客户端
+type Client struct {
+	opts   Options
+	client *http.Client
+}
+
+var (
+	_ upstream.Upstream      = (*Client)(nil)
+	_ upstream.ImageUploader = (*Client)(nil)
+)
+
+// New 创建 Trae 接口客户端
+func New(opts Options) *Client {
+	client := opts.HTTPClient
+	if client == nil {
+		// 使用HTTP/1.1客户端
+		client = customhttp.NewHTTP11Client()
+	}
+	return &Client{
+		opts:   opts,
+		client: client,
+	}
+}
+
+// ListModels 获取 Trae 模型列表
+func (t *Client) ListModels(ctx context.Context) (*upstream.ModelList, error) {
+	url := fmt.Sprintf("%s/api/ide/v1/model_list?type=chat", t.opts.BaseURL)
+
+	// 创建HTTP/1.1请求
+	req, err := customhttp.NewHTTP11Request("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	t.setHeaders(req)
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		logger.Log.Errorf("请求模型列表失败: %v, url: %s", err, url)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// 读取响应内容
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// 检查响应状态码
+	if resp.StatusCode != http.StatusOK {
+		return nil, &upstream.Error{
+			Status:  resp.StatusCode,
+			Message: fmt.Sprintf("API返回错误状态码 %d: %s", resp.StatusCode, string(body)),
+		}
+	}
+
+	// 记录原始响应
+	logger.Log.WithFields(logrus.Fields{
+		"response": string(body),
+	}).Debug("收到原始响应")
+
+	var models upstream.ModelList
+	if err := json.Unmarshal(body, &models); err != nil {
+		return nil, err
+	}
+	return &models, nil
+}
+
+// Chat 向 Trae 发送对话请求，非 200 响应以 *upstream.Error 返回
+func (t *Client) Chat(ctx context.Context, chatReq *upstream.ChatRequest) (upstream.ChatStream, error) {
+	jsonData, err := json.Marshal(chatReq)
+	if err != nil {
+		return nil, fmt.Errorf("JSON编码失败: %v", err)
+	}
+
+	url := fmt.Sprintf("%s/api/ide/v1/chat", t.opts.BaseURL)
+	// 创建HTTP/1.1请求
+	req, err := customhttp.NewHTTP11Request("POST", url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("请求失败: %v", err)
+	}
+	req = req.WithContext(ctx)
+	t.setHeaders(req)
+
+	// 记录请求头
+	headers := make(map[string]string)
+	for k, v := range req.Header {
+		headers[k] = v[0]
+	}
+	logger.Log.WithFields(logrus.Fields{
+		"headers": headers,
+	}).Debug("请求头信息")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("请求远端失败: %v", err)
+	}
+
+	// 检查响应状态码并直接返回对应的错误
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		errMsg := fmt.Sprintf("远程服务返回错误: %s", string(body))
+		logger.Log.Errorf("状态码: %d, 错误信息: %s", resp.StatusCode, errMsg)
+
+		return nil, &upstream.Error{
+			Status:  resp.StatusCode,
+			Message: errMsg,
+		}
+	}
+
+	return &chatStream{body: resp.Body, decoder: traesse.NewDecoder(resp.Body)}, nil
+}
+
+// ExchangeToken 使用 RefreshToken 换取新的 Token 与 RefreshToken
+func (t *Client) ExchangeToken(ctx context.Context, tokenReq *upstream.TokenRequest) (*upstream.TokenResponse, error) {
+	jsonData, err := json.Marshal(tokenReq)
+	if err != nil {
+		return nil, fmt.Errorf("marshal token request failed: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", t.opts.TokenURL+"/cloudide/api/v3/trae/oauth/ExchangeToken",
+		bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("refresh token request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read response body failed: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &upstream.Error{
+			Status:  resp.StatusCode,
+			Message: fmt.Sprintf("request failed with status code: %d, body: %s", resp.StatusCode, string(respBody)),
+		}
+	}
+
+	var tokenResp upstream.TokenResponse
+	if err := json.NewDecoder(bytes.NewReader(respBody)).Decode(&tokenResp); err != nil {
+		return nil, fmt.Errorf("decode token response failed: %v", err)
+	}
+	return &tokenResp, nil
+}
+
+func (t *Client) setHeaders(req *http.Request) {
+	if t.opts.SetHeaders != nil {
+		t.opts.SetHeaders(req)
+	}
+}
+
+// chatStream 基于 SSE 解码器的对话事件流
+type chatStream struct {
+	body    io.ReadCloser
+	decoder *traesse.Decoder
+}
+
+func (s *chatStream) Next() (traesse.Event, *traesse.Message, error) {
+	return s.decoder.NextEvent()
+}
+
+func (s *chatStream) Close() error {
+	return s.body.Close()
+}
@@ -0,0 +1,313 @@
+package trae
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	customhttp "github.com/trae2api/pkg/http"
+	"github.com/trae2api/pkg/upstream"
+)
+
+const (
+	// 获取图片上传凭证的路径
+	uploadTokenPath = "/api/ide/v1/get_upload_token"
+	// ImageX OpenAPI 版本
+	imagexAPIVersion = "2018-08-01"
+	// ImageX 签名使用的区域与服务名
+	imagexRegion  = "ap-singapore-1"
+	imagexService = "imagex"
+)
+
+// uploadToken Trae 下发的临时上传凭证
+type uploadToken struct {
+	AccessKeyID     string `json:"AccessKeyID"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	SessionToken    string `json:"SessionToken"`
+	ServiceID       string `json:"ServiceId"`
+}
+
+// applyUploadResult ApplyImageUpload 的返回结果
+type applyUploadResult struct {
+	Result struct {
+		UploadAddress struct {
+			StoreInfos []struct {
+				StoreURI string `json:"StoreUri"`
+				Auth     string `json:"Auth"`
+			} `json:"StoreInfos"`
+			UploadHosts []string `json:"UploadHosts"`
+			SessionKey  string   `json:"SessionKey"`
+		} `json:"UploadAddress"`
+	} `json:"Result"`
+}
+
+// commitUploadResult CommitImageUpload 的返回结果
+type commitUploadResult struct {
+	Result struct {
+		Results []struct {
+			URI       string `json:"Uri"`
+			URIStatus int    `json:"UriStatus"`
+		} `json:"Results"`
+		PluginResult []struct {
+			ImageURI    string `json:"ImageUri"`
+			ImageWidth  int    `json:"ImageWidth"`
+			ImageHeight int    `json:"ImageHeight"`
+			ImageFormat string `json:"ImageFormat"`
+			ImageSize   int    `json:"ImageSize"`
+		} `json:"PluginResult"`
+	} `json:"Result"`
+}
+
+// UploadImage 按 获取凭证 -> 申请上传 -> 上传 -> 提交 的流程上传一张图片
+func (t *Client) UploadImage(ctx context.Context, data []byte) (*upstream.MultiMediaImage, error) {
+	token, err := t.fetchUploadToken(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("获取上传凭证失败: %v", err)
+	}
+
+	apply, err := t.applyImageUpload(ctx, token, len(data))
+	if err != nil {
+		return nil, fmt.Errorf("申请上传地址失败: %v", err)
+	}
+	address := apply.Result.UploadAddress
+	if len(address.StoreInfos) == 0 {
+		return nil, fmt.Errorf("申请上传地址失败: 未返回存储信息")
+	}
+	store := address.StoreInfos[0]
+
+	if err := t.putImage(ctx, store.StoreURI, store.Auth, data); err != nil {
+		return nil, fmt.Errorf("上传图片失败: %v", err)
+	}
+
+	commit, err := t.commitImageUpload(ctx, token, address.SessionKey)
+	if err != nil {
+		return nil, fmt.Errorf("提交上传失败: %v", err)
+	}
+
+	image := &upstream.MultiMediaImage{Type: "image", ImageURI: store.StoreURI, Size: len(data)}
+	if len(commit.Result.PluginResult) > 0 {
+		plugin := commit.Result.PluginResult[0]
+		image.ImageURI = plugin.ImageURI
+		image.Width = plugin.ImageWidth
+		image.Height = plugin.ImageHeight
+		image.Format = plugin.ImageFormat
+	} else if len(commit.Result.Results) > 0 {
+		image.ImageURI = commit.Result.Results[0].URI
+	}
+	return image, nil
+}
+
+// fetchUploadToken 从 Trae 获取临时上传凭证
+func (t *Client) fetchUploadToken(ctx context.Context) (*uploadToken, error) {
+	req, err := customhttp.NewHTTP11Request("GET", t.opts.BaseURL+uploadTokenPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	t.setHeaders(req)
+
+	body, err := t.doUploadRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var tokenResp struct {
+		Result uploadToken `json:"Result"`
+	}
+	if err := json.Unmarshal(body, &tokenResp); err != nil {
+		return nil, fmt.Errorf("解析上传凭证失败: %v", err)
+	}
+	if tokenResp.Result.AccessKeyID == "" {
+		return nil, fmt.Errorf("上传凭证为空: %s", string(body))
+	}
+	return &tokenResp.Result, nil
+}
+
+// applyImageUpload 申请图片上传地址
+func (t *Client) applyImageUpload(ctx context.Context, token *uploadToken, size int) (*applyUploadResult, error) {
+	query := url.Values{}
+	query.Set("Action", "ApplyImageUpload")
+	query.Set("Version", imagexAPIVersion)
+	query.Set("ServiceId", token.ServiceID)
+	query.Set("FileSize", fmt.Sprintf("%d", size))
+
+	req, err := customhttp.NewHTTP11Request("GET", t.opts.ImageXURL+"/?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	signImageXRequest(req, nil, token, time.Now().UTC())
+
+	body, err := t.doUploadRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var result applyUploadResult
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("解析上传地址失败: %v", err)
+	}
+	return &result, nil
+}
+
+// putImage 将图片内容上传到存储服务
+func (t *Client) putImage(ctx context.Context, storeURI string, auth string, data []byte) error {
+	uploadURL := fmt.Sprintf("%s/upload/v1/%s", strings.TrimSuffix(t.opts.UploadURL, "/"), storeURI)
+	req, err := customhttp.NewHTTP11Request("PUT", uploadURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", auth)
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-CRC32", fmt.Sprintf("%08x", crc32.ChecksumIEEE(data)))
+
+	_, err = t.doUploadRequest(req)
+	return err
+}
+
+// commitImageUpload 提交已上传的图片
+func (t *Client) commitImageUpload(ctx context.Context, token *uploadToken, sessionKey string) (*commitUploadResult, error) {
+	query := url.Values{}
+	query.Set("Action", "CommitImageUpload")
+	query.Set("Version", imagexAPIVersion)
+	query.Set("ServiceId", token.ServiceID)
+
+	payload, err := json.Marshal(map[string]string{"SessionKey": sessionKey})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := customhttp.NewHTTP11Request("POST", t.opts.ImageXURL+"/?"+query.Encode(), bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	signImageXRequest(req, payload, token, time.Now().UTC())
+
+	body, err := t.doUploadRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var result commitUploadResult
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("解析提交结果失败: %v", err)
+	}
+	return &result, nil
+}
+
+// doUploadRequest 发送上传流程中的请求并读取响应体，非 200 响应以 *upstream.Error 返回
+func (t *Client) doUploadRequest(req *http.Request) ([]byte, error) {
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &upstream.Error{
+			Status:  resp.StatusCode,
+			Message: fmt.Sprintf("状态码: %d, 响应内容: %s", resp.StatusCode, string(body)),
+		}
+	}
+	return body, nil
+}
+
+// signImageXRequest 使用临时凭证为 ImageX 请求添加 AWS4-HMAC-SHA256 签名
+func signImageXRequest(req *http.Request, body []byte, token *uploadToken, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Security-Token", token.SessionToken)
+	if req.Method != "GET" {
+		req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+
+	// 参与签名的请求头
+	var signedHeaders []string
+	for key := range req.Header {
+		lower := strings.ToLower(key)
+		if strings.HasPrefix(lower, "x-amz-") {
+			signedHeaders = append(signedHeaders, lower)
+		}
+	}
+	sort.Strings(signedHeaders)
+
+	var canonicalHeaders strings.Builder
+	for _, key := range signedHeaders {
+		canonicalHeaders.WriteString(key + ":" + strings.TrimSpace(req.Header.Get(key)) + "\n")
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		"/",
+		canonicalQueryString(req.URL.Query()),
+		canonicalHeaders.String(),
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + imagexRegion + "/" + imagexService + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+token.SecretAccessKey), date)
+	key = hmacSHA256(key, imagexRegion)
+	key = hmacSHA256(key, imagexService)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		token.AccessKeyID, scope, strings.Join(signedHeaders, ";"), signature))
+}
+
+// canonicalQueryString 按键名排序并编码查询参数
+func canonicalQueryString(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		for _, v := range query[k] {
+			pairs = append(pairs, awsEscape(k)+"="+awsEscape(v))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// awsEscape 按 RFC 3986 编码
+func awsEscape(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(url.QueryEscape(s), "+", "%20"), "%7E", "~")
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
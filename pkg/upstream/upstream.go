@@ -0,0 +1,126 @@
+// Package upstream 定义代理所依赖的上游对话服务接口
+//
+// 处理函数只依赖 Upstream 接口，图片上传另需实现 ImageUploader。真实的 Trae
+// 实现位于 upstream/trae，测试或其他后端可以提供自己的实现。
+package upstream
+
+import (
+	"context"
+
+	"github.com/trae2api/pkg/traesse"
+)
+
+// Upstream 上游对话服务
+type Upstream interface {
+	// ListModels 获取上游提供的模型列表
+	ListModels(ctx context.Context) (*ModelList, error)
+	// Chat 发起一次对话请求，返回的事件流由调用方负责关闭
+	Chat(ctx context.Context, req *ChatRequest) (ChatStream, error)
+	// ExchangeToken 使用 RefreshToken 换取新的 Token 与 RefreshToken
+	ExchangeToken(ctx context.Context, req *TokenRequest) (*TokenResponse, error)
+}
+
+// ImageUploader 上游图片上传服务，由支持图片输入的 Upstream 实现
+type ImageUploader interface {
+	// UploadImage 上传一张图片，返回可以放入对话请求 multi_media 中的图片信息
+	UploadImage(ctx context.Context, data []byte) (*MultiMediaImage, error)
+}
+
+// ChatStream 对话接口返回的事件流
+type ChatStream interface {
+	// Next 返回下一条事件，流结束时返回 io.EOF
+	//
+	// 事件解析失败时同时返回原始事件和错误，调用方可以选择跳过该事件继续读取。
+	Next() (traesse.Event, *traesse.Message, error)
+	// Close 关闭事件流并释放上游连接
+	Close() error
+}
+
+// Error 上游返回的非 200 响应
+type Error struct {
+	Status  int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+type ContextResolver struct {
+	ResolverID string `json:"resolver_id"`
+	Variables  string `json:"variables"`
+}
+
+type LastLLMResponseInfo struct {
+	Turn     int    `json:"turn"`
+	IsError  bool   `json:"is_error"`
+	Response string `json:"response"`
+}
+
+type ChatHistory struct {
+	Role      string `json:"role"`
+	SessionID string `json:"session_id"`
+	Locale    string `json:"locale"`
+	Content   string `json:"content"`
+	Status    string `json:"status"`
+}
+
+// ChatRequest Trae 对话请求
+type ChatRequest struct {
+	UserInput                  string               `json:"user_input"`
+	IntentName                 string               `json:"intent_name"`
+	Variables                  string               `json:"variables"`
+	ContextResolvers           []ContextResolver    `json:"context_resolvers"`
+	GenerateSuggestedQuestions bool                 `json:"generate_suggested_questions"`
+	ChatHistory                []ChatHistory        `json:"chat_history"`
+	SessionID                  string               `json:"session_id"`
+	ConversationID             string               `json:"conversation_id"`
+	CurrentTurn                int                  `json:"current_turn"`
+	ValidTurns                 []int                `json:"valid_turns"`
+	MultiMedia                 []interface{}        `json:"multi_media"`
+	ModelName                  string               `json:"model_name"`
+	LastLLMResponseInfo        *LastLLMResponseInfo `json:"last_llm_response_info,omitempty"`
+	IsPreset                   bool                 `json:"is_preset"`
+	Provider                   string               `json:"provider"`
+}
+
+// MultiMediaImage 对话请求 multi_media 中的图片
+type MultiMediaImage struct {
+	Type     string `json:"type"`
+	ImageURI string `json:"image_uri"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	Format   string `json:"format"`
+	Size     int    `json:"size"`
+}
+
+// ModelList Trae 模型列表
+type ModelList struct {
+	ModelConfigs []ModelConfig `json:"model_configs"`
+}
+
+type ModelConfig struct {
+	CustomConfig string `json:"custom_config"`
+	DisplayName  string `json:"display_name"`
+	IsDefault    bool   `json:"is_default"`
+	Multimodal   bool   `json:"multimodal"`
+	Name         string `json:"name"`
+}
+
+// TokenRequest 换取 Token 的请求
+type TokenRequest struct {
+	ClientID     string `json:"ClientID"`
+	RefreshToken string `json:"RefreshToken"`
+	ClientSecret string `json:"ClientSecret"`
+	UserID       string `json:"UserID"`
+}
+
+// TokenResponse 换取 Token 的响应，过期时间为 Unix 毫秒
+type TokenResponse struct {
+	Result struct {
+		Token           string `json:"Token"`
+		TokenExpireAt   int64  `json:"TokenExpireAt"`
+		RefreshToken    string `json:"RefreshToken"`
+		RefreshExpireAt int64  `json:"RefreshExpireAt"`
+	} `json:"Result"`
+}